@@ -0,0 +1,364 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+	"github.com/isard-vdi/CAS_Exporter/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Statuses of the alerts
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// webhookTimeout is the maximum time a webhook request can take
+const webhookTimeout = 10 * time.Second
+
+// webhookAttempts is the number of times a webhook is requested before giving up
+const webhookAttempts = 3
+
+// Alert is the body of the webhook requests
+type Alert struct {
+	Status  string  `json:"status"`
+	Rule    string  `json:"rule"`
+	Host    string  `json:"host"`
+	CacheID uint16  `json:"cache_id"`
+	Device  string  `json:"device"`
+	Stat    string  `json:"stat"`
+	Value   float64 `json:"value"`
+	// Condition is the condition of the rule (e.g. > 80)
+	Condition string `json:"condition"`
+	// Since is when the condition started to be met
+	Since time.Time `json:"since"`
+	// Labels are the labels of the exporter series (e.g. the ones of the profile)
+	Labels map[string]string `json:"labels,omitempty"`
+	// Text is a summary of the alert, which is shown by the chat incoming webhooks
+	Text string `json:"text"`
+}
+
+// state is the state of a rule for a cache
+type state struct {
+	// since is when the condition started to be met. If it's zero, it isn't met
+	since   time.Time
+	firing  bool
+	value   float64
+	hasPrev bool
+	prev    float64
+	device  string
+}
+
+// Alerter evaluates the alert rules on the caches after every extraction, and notifies the
+// webhooks when they fire and resolve, for the small sites that don't run an Alertmanager
+type Alerter struct {
+	rules    []*config.AlertRule
+	webhooks []*config.Webhook
+	e        *casexporter.CasExporter
+
+	extracted <-chan struct{}
+	host      string
+	client    *http.Client
+
+	// states are indexed by rule name and cache ID
+	states map[string]map[uint16]*state
+
+	firing        *prometheus.GaugeVec
+	webhookErrors prometheus.Counter
+}
+
+// New returns the alerter of the caches of the exporter. It has to be called before the
+// exporter is started
+func New(rules []*config.AlertRule, webhooks []*config.Webhook, e *casexporter.CasExporter) *Alerter {
+	host, _ := os.Hostname()
+
+	a := &Alerter{
+		rules:    rules,
+		webhooks: webhooks,
+		e:        e,
+
+		extracted: e.Subscribe(),
+		host:      host,
+		client:    &http.Client{Timeout: webhookTimeout},
+
+		states: map[string]map[uint16]*state{},
+
+		firing: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_alert_firing",
+				Help: "Whether the alert rule is firing for the cache",
+			},
+			[]string{"rule", "cache_id"},
+		),
+		webhookErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_alert_webhook_errors_total",
+			Help: "Number of alert notifications that have failed to be delivered to a webhook",
+		}),
+	}
+
+	for _, r := range rules {
+		a.states[r.Name] = map[uint16]*state{}
+	}
+
+	return a
+}
+
+// Start evaluates the rules after every extraction, until the context is done
+func (a *Alerter) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-a.extracted:
+			for _, alert := range a.evaluate(a.e.Inventory()) {
+				go a.notify(alert)
+			}
+		}
+	}
+}
+
+// evaluate updates the state of the rules with the caches of the inventory, returning the
+// alerts that have fired or resolved
+func (a *Alerter) evaluate(inv *casexporter.Inventory) []*Alert {
+	if inv.UpdatedAt == nil {
+		return nil
+	}
+	now := *inv.UpdatedAt
+
+	alerts := []*Alert{}
+	for _, r := range a.rules {
+		states := a.states[r.Name]
+
+		found := map[uint16]bool{}
+		for _, cache := range inv.Caches {
+			found[cache.ID] = true
+
+			// The caches whose stats have failed keep their state
+			if cache.Stats == nil {
+				continue
+			}
+
+			value, ok := stat(cache.Stats, r.Stat)
+			if !ok {
+				continue
+			}
+
+			st, ok := states[cache.ID]
+			if !ok {
+				st = &state{}
+				states[cache.ID] = st
+			}
+			st.device = cache.Device
+			st.value = value
+
+			met := false
+			switch {
+			case r.Above != nil:
+				met = value > *r.Above
+			case r.Below != nil:
+				met = value < *r.Below
+			case r.Increasing:
+				met = st.hasPrev && value > st.prev
+			}
+			st.prev, st.hasPrev = value, true
+
+			if !met {
+				if st.firing {
+					alerts = append(alerts, a.alert(r, cache.ID, st, StatusResolved))
+				}
+
+				st.since, st.firing = time.Time{}, false
+				a.firing.WithLabelValues(r.Name, strconv.Itoa(int(cache.ID))).Set(0)
+				continue
+			}
+
+			if st.since.IsZero() {
+				st.since = now
+			}
+
+			if !st.firing && now.Sub(st.since) >= r.For {
+				st.firing = true
+				alerts = append(alerts, a.alert(r, cache.ID, st, StatusFiring))
+			}
+			a.firing.WithLabelValues(r.Name, strconv.Itoa(int(cache.ID))).Set(boolFloat(st.firing))
+		}
+
+		// The alerts of the caches that aren't found anymore are resolved
+		for id, st := range states {
+			if found[id] {
+				continue
+			}
+
+			if st.firing {
+				alerts = append(alerts, a.alert(r, id, st, StatusResolved))
+			}
+
+			delete(states, id)
+			a.firing.DeleteLabelValues(r.Name, strconv.Itoa(int(id)))
+		}
+	}
+
+	return alerts
+}
+
+func (a *Alerter) alert(r *config.AlertRule, id uint16, st *state, status string) *Alert {
+	condition := "increasing"
+	switch {
+	case r.Above != nil:
+		condition = "> " + strconv.FormatFloat(*r.Above, 'g', -1, 64)
+	case r.Below != nil:
+		condition = "< " + strconv.FormatFloat(*r.Below, 'g', -1, 64)
+	}
+
+	return &Alert{
+		Status:    status,
+		Rule:      r.Name,
+		Host:      a.host,
+		CacheID:   id,
+		Device:    st.device,
+		Stat:      r.Stat,
+		Value:     st.value,
+		Condition: condition,
+		Since:     st.since,
+		Labels:    a.e.ConstLabels(),
+		Text: fmt.Sprintf("[%s] %s: %s of cache %d (%s) on %s is %g (%s)",
+			status, r.Name, r.Stat, id, st.device, a.host, st.value, condition),
+	}
+}
+
+// notify posts the alert to all the webhooks, retrying the failed requests
+func (a *Alerter) notify(alert *Alert) {
+	slog.Info("alert",
+		slog.String("status", alert.Status),
+		slog.String("rule", alert.Rule),
+		slog.Int("cache_id", int(alert.CacheID)),
+		slog.Float64("value", alert.Value),
+	)
+
+	// The conditions are more readable without escaping > and <
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(alert); err != nil {
+		a.webhookErrors.Inc()
+		slog.Error("marshal alert",
+			slog.String("err", err.Error()),
+		)
+		return
+	}
+
+	for _, w := range a.webhooks {
+		var err error
+		for attempt := 0; attempt < webhookAttempts; attempt++ {
+			if attempt != 0 {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+
+			if err = a.post(w, buf.Bytes()); err == nil {
+				break
+			}
+		}
+
+		if err != nil {
+			a.webhookErrors.Inc()
+			slog.Error("notify alert webhook",
+				slog.String("rule", alert.Rule),
+				slog.String("err", err.Error()),
+			)
+		}
+	}
+}
+
+func (a *Alerter) post(w *config.Webhook, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create webhook request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	rsp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request webhook: %w", err)
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return fmt.Errorf("request webhook: unexpected status %s", rsp.Status)
+	}
+
+	return nil
+}
+
+// statAccessors return the value of each stat the rules can use, named like in the
+// inventory API. They're checked against config.AlertStats when the package is initialized
+var statAccessors = func() map[string]func(s *casexporter.InventoryStats) float64 {
+	accessors := map[string]func(s *casexporter.InventoryStats) float64{
+		"occupancy_percent":  func(s *casexporter.InventoryStats) float64 { return s.OccupancyPercent },
+		"dirty_percent":      func(s *casexporter.InventoryStats) float64 { return s.DirtyPercent },
+		"read_hits_percent":  func(s *casexporter.InventoryStats) float64 { return s.ReadHitsPercent },
+		"write_hits_percent": func(s *casexporter.InventoryStats) float64 { return s.WriteHitsPercent },
+		"cache_errors":       func(s *casexporter.InventoryStats) float64 { return float64(s.CacheErrors) },
+		"core_errors":        func(s *casexporter.InventoryStats) float64 { return float64(s.CoreErrors) },
+		"total_requests":     func(s *casexporter.InventoryStats) float64 { return float64(s.TotalRequests) },
+		"serviced_requests":  func(s *casexporter.InventoryStats) float64 { return float64(s.ServicedRequests) },
+		"dirty_for_seconds":  func(s *casexporter.InventoryStats) float64 { return float64(s.DirtyForSeconds) },
+		"inactive_cores":     func(s *casexporter.InventoryStats) float64 { return float64(s.InactiveCores) },
+	}
+
+	for _, name := range config.AlertStats {
+		if _, ok := accessors[name]; !ok {
+			panic(fmt.Sprintf("alert stat %s has no accessor", name))
+		}
+	}
+	if len(accessors) != len(config.AlertStats) {
+		panic("the alert stats accessors don't match config.AlertStats")
+	}
+
+	return accessors
+}()
+
+// stat returns the value of the stat, named like in the inventory API
+func stat(stats *casexporter.InventoryStats, name string) (float64, bool) {
+	get, ok := statAccessors[name]
+	if !ok {
+		return 0, false
+	}
+
+	return get(stats), true
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func (a *Alerter) Describe(ch chan<- *prometheus.Desc) {
+	a.firing.Describe(ch)
+	a.webhookErrors.Describe(ch)
+}
+
+func (a *Alerter) Collect(ch chan<- prometheus.Metric) {
+	a.firing.Collect(ch)
+	a.webhookErrors.Collect(ch)
+}
@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outcomes of the admin actions
+const (
+	OutcomeSuccess      = "success"
+	OutcomeFailure      = "failure"
+	OutcomeUnauthorized = "unauthorized"
+)
+
+// Entry is an admin action, written as a JSON line of the audit log
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	// Client identifies who requested the action (e.g. the remote address of the HTTP
+	// request, or the signal)
+	Client    string            `json:"client"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+	Outcome   string            `json:"outcome"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// Log records the admin actions (e.g. pausing the extraction) in an append-only file and
+// counts them, so the state changes of the exporter can be traced back
+type Log struct {
+	mu sync.Mutex
+	f  *os.File
+
+	actions *prometheus.CounterVec
+}
+
+// Open opens the audit log at path, creating it if it doesn't exist. If path is empty, the
+// actions are only counted
+func Open(path string) (*Log, error) {
+	l := &Log{
+		actions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ocf_admin_actions_total",
+				Help: "Number of admin actions requested to the exporter",
+			},
+			[]string{"action", "outcome"},
+		),
+	}
+
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log: %w", err)
+		}
+
+		l.f = f
+	}
+
+	return l, nil
+}
+
+// Record records the action. If the entry has no time, the current one is used
+func (l *Log) Record(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.actions.WithLabelValues(e.Action, e.Outcome).Inc()
+
+	slog.Info("admin action",
+		slog.String("action", e.Action),
+		slog.String("client", e.Client),
+		slog.String("outcome", e.Outcome),
+	)
+
+	if l.f == nil {
+		return
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("marshal audit log entry",
+			slog.String("err", err.Error()),
+		)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// A single write per entry, so concurrent writers to the file don't interleave them
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		slog.Error("write audit log",
+			slog.String("err", err.Error()),
+		)
+	}
+}
+
+func (l *Log) Describe(ch chan<- *prometheus.Desc) {
+	l.actions.Describe(ch)
+}
+
+func (l *Log) Collect(ch chan<- prometheus.Metric) {
+	l.actions.Collect(ch)
+}
+
+func (l *Log) ListMetrics() []prometheus.Collector {
+	return []prometheus.Collector{l.actions}
+}
+
+// Close closes the audit log file
+func (l *Log) Close() error {
+	if l.f == nil {
+		return nil
+	}
+
+	return l.f.Close()
+}
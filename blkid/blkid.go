@@ -0,0 +1,59 @@
+package blkid
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const blkidCmd = "blkid"
+
+// blkid exits with this code when the device has no recognizable signature
+const exitNotFound = 2
+
+type Info struct {
+	Type  string
+	Label string
+	UUID  string
+}
+
+func Probe(ctx context.Context, dev string) (*Info, error) {
+	b, err := exec.CommandContext(ctx, blkidCmd, "--output", "export", dev).CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == exitNotFound {
+			return &Info{}, nil
+		}
+
+		return nil, fmt.Errorf("probe device: %w: '%s'", err, b)
+	}
+
+	info := &Info{}
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), "=")
+		if !ok {
+			continue
+		}
+
+		switch k {
+		case "TYPE":
+			info.Type = v
+		case "LABEL":
+			info.Label = v
+		case "UUID":
+			info.UUID = v
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read blkid output: %w", err)
+	}
+
+	return info, nil
+}
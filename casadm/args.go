@@ -0,0 +1,109 @@
+package casadm
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Commands of the binary run by the client
+const (
+	CommandListCaches   = "list-caches"
+	CommandStats        = "stats"
+	CommandIOClassStats = "stats-io-class"
+	CommandIOClasses    = "io-class"
+	CommandSetCacheMode = "set-cache-mode"
+)
+
+// intelCASFlags are the flags of the legacy intelcas, by the Open CAS casadm ones. It only
+// has the short options of its administration guide
+var intelCASFlags = map[string]string{
+	"--list-caches":    "-L",
+	"--stats":          "-P",
+	"--io-class":       "-C",
+	"--list":           "-L",
+	"--set-cache-mode": "-Q",
+	"--cache-id":       "-i",
+	"--io-class-id":    "-d",
+	"--cache-mode":     "-c",
+	"--flush-cache":    "-f",
+	"--output-format":  "-o",
+}
+
+// Args returns the arguments of the command, written with the Open CAS flags, in the flags
+// of the binary of the schema
+func (s Schema) Args(args ...string) []string {
+	if s != SchemaIntelCAS {
+		return args
+	}
+
+	out := make([]string, len(args))
+	for i, a := range args {
+		out[i] = a
+		if f, ok := intelCASFlags[a]; ok {
+			out[i] = f
+		}
+	}
+
+	return out
+}
+
+// openCASArgs returns the arguments with the Open CAS flags, whichever the schema they're
+// written in
+func openCASArgs(args []string) []string {
+	out := slices.Clone(args)
+	for i, a := range args {
+		if !strings.HasPrefix(a, "-") || strings.HasPrefix(a, "--") {
+			continue
+		}
+
+		for f, short := range intelCASFlags {
+			if short != a {
+				continue
+			}
+
+			// -L is both the listing of the caches and of the IO classes
+			if a == "-L" && (f == "--list") != (i > 0 && out[0] == "--io-class") {
+				continue
+			}
+
+			out[i] = f
+			break
+		}
+	}
+
+	return out
+}
+
+// CommandArgs are the arguments of a command run by the client
+type CommandArgs struct {
+	// Command is the command run, one of the Command constants, or the first flag if it
+	// isn't known
+	Command string
+	// CacheID is the cache of the command, if HasCacheID is true
+	CacheID    uint16
+	HasCacheID bool
+}
+
+// ParseArgs parses the arguments of a command run by the client, in the flags of any of the
+// schemas
+func ParseArgs(args []string) CommandArgs {
+	args = openCASArgs(args)
+
+	a := CommandArgs{}
+	if len(args) != 0 {
+		a.Command = strings.TrimLeft(args[0], "-")
+	}
+
+	// The IO class stats are a variant of the cache ones
+	if a.Command == CommandStats && slices.Contains(args, "--io-class-id") {
+		a.Command = CommandIOClassStats
+	}
+
+	if i := slices.Index(args, "--cache-id"); i != -1 && i+1 < len(args) {
+		id, err := strconv.ParseUint(args[i+1], 10, 16)
+		a.CacheID, a.HasCacheID = uint16(id), err == nil
+	}
+
+	return a
+}
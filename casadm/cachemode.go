@@ -0,0 +1,33 @@
+package casadm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// CacheModes are the caching modes a cache can be switched to: Write-Through, Write-Back,
+// Write-Around, Pass-Through and Write-Only
+var CacheModes = []string{"wt", "wb", "wa", "pt", "wo"}
+
+// SetCacheMode switches the caching mode of the cache. If flush is true, the dirty data is
+// flushed to the cores before switching, which casadm requires when leaving Write-Back or
+// Write-Only
+func (c *Client) SetCacheMode(ctx context.Context, cacheID uint16, mode string, flush bool) error {
+	if !slices.Contains(CacheModes, mode) {
+		return fmt.Errorf("set cache mode: unknown cache mode '%s'", mode)
+	}
+
+	flushCache := "no"
+	if flush {
+		flushCache = "yes"
+	}
+
+	b, err := c.runner.Run(ctx, c.schema.Args("--set-cache-mode", "--cache-mode", mode, "--cache-id", strconv.Itoa(int(cacheID)), "--flush-cache", flushCache)...)
+	if err != nil {
+		return classifyError(ctx, fmt.Errorf("set cache mode: %w: '%s'", err, b), b)
+	}
+
+	return nil
+}
@@ -1,16 +1,130 @@
 package casadm
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
+)
+
+const (
+	casaCmd     = "casadm"
+	intelCasCmd = "intelcas"
+)
+
+// Schema is the variant of the CSV output of the binary
+type Schema int
+
+const (
+	// SchemaOpenCAS is the output of Open CAS Linux casadm
+	SchemaOpenCAS Schema = iota
+	// SchemaIntelCAS is the output of the legacy Intel CAS intelcas, which differs from
+	// the Open CAS one in the capitalization and spacing of the headers. Its flags differ
+	// too, see Schema.Args
+	SchemaIntelCAS
+)
+
+// Runner runs the CAS administration binary with the arguments, returning its output
+type Runner interface {
+	Run(ctx context.Context, args ...string) ([]byte, error)
+}
+
+// ExecRunner runs the binary as a child process
+type ExecRunner struct {
+	Binary string
+}
+
+func (r *ExecRunner) Run(ctx context.Context, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, r.Binary, args...).CombinedOutput()
+}
+
+type Client struct {
+	binary string
+	schema Schema
+	runner Runner
+}
+
+// NewClient returns a client that runs the binary. If binary is empty, casadm is looked
+// up in the PATH, falling back to the legacy intelcas
+func NewClient(binary string) (*Client, error) {
+	if binary == "" {
+		var err error
+		binary, err = DetectBinary()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	schema := SchemaOpenCAS
+	if filepath.Base(binary) == intelCasCmd {
+		schema = SchemaIntelCAS
+	}
+
+	return &Client{
+		binary: binary,
+		schema: schema,
+		runner: &ExecRunner{Binary: binary},
+	}, nil
+}
+
+// NewClientWithRunner returns a client that gets the output of the binary from the runner,
+// which is expected to follow the schema
+func NewClientWithRunner(r Runner, schema Schema) *Client {
+	return &Client{
+		schema: schema,
+		runner: r,
+	}
+}
+
+// DetectBinary returns the path of the installed CAS administration binary
+func DetectBinary() (string, error) {
+	for _, cmd := range []string{casaCmd, intelCasCmd} {
+		if p, err := exec.LookPath(cmd); err == nil {
+			return p, nil
+		}
+	}
+
+	return "", withKind(ErrCasadmNotInstalled, fmt.Errorf("detect binary: neither %s nor %s found in PATH", casaCmd, intelCasCmd))
+}
+
+// Binary returns the path of the binary run by the client, which is empty if it doesn't
+// run it directly
+func (c *Client) Binary() string {
+	return c.binary
+}
+
+// WithRunner returns a copy of the client that gets the output of the binary from the runner
+func (c *Client) WithRunner(r Runner) *Client {
+	cp := *c
+	cp.runner = r
+
+	return &cp
+}
+
+func (c *Client) Runner() Runner {
+	return c.runner
+}
+
+func (c *Client) Schema() Schema {
+	return c.schema
+}
+
+func normalizeHeader(h string) string {
+	return strings.ToLower(strings.Join(strings.Fields(h), " "))
+}
 
-	"github.com/gocarina/gocsv"
+const (
+	TypeCache = "cache"
+	TypeCore  = "core"
 )
 
-const casaCmd = "casadm"
+// StatusRunning is the status of the caches that are fully operational. Caches can also be
+// Incomplete (missing cores), Stopping, etc
+const StatusRunning = "Running"
 
 type Cache struct {
 	Type        string `csv:"type"`
@@ -19,23 +133,60 @@ type Cache struct {
 	Status      string `csv:"status"`
 	WritePolicy string `csv:"write policy"`
 	Device      string `csv:"device"`
+
+	// CacheID is the ID of the cache the row belongs to. For cache rows it's the same as ID,
+	// for core rows it's the ID of the cache listed before them
+	CacheID uint16 `csv:"-"`
 }
 
-func ListCaches(ctx context.Context) ([]*Cache, error) {
-	b, err := exec.CommandContext(ctx, casaCmd, "--list-caches", "--output-format", "csv").CombinedOutput()
+var listCachesArgs = []string{"--list-caches", "--output-format", "csv"}
+
+// ListCachesOutput returns the raw CSV output of the caches listing
+func (c *Client) ListCachesOutput(ctx context.Context) ([]byte, error) {
+	b, err := c.runner.Run(ctx, c.schema.Args(listCachesArgs...)...)
 	if err != nil {
-		return nil, fmt.Errorf("list caches: %w: '%s'", err, b)
+		return nil, classifyError(ctx, fmt.Errorf("list caches: %w: '%s'", err, b), b)
 	}
 
+	return b, nil
+}
+
+func (c *Client) ListCaches(ctx context.Context) ([]*Cache, error) {
 	caches := []*Cache{}
 
-	if err := gocsv.UnmarshalBytes(b, &caches); err != nil {
+	if err := c.decodeOutput(ctx, "list caches", &caches, listCachesArgs...); err != nil {
+		return nil, err
+	}
+	setCacheIDs(caches)
+
+	return caches, nil
+}
+
+// ParseCaches parses the raw CSV output of the caches listing
+func (c *Client) ParseCaches(b []byte) ([]*Cache, error) {
+	caches := []*Cache{}
+
+	if err := decodeCSV(bytes.NewReader(b), &caches); err != nil {
 		return nil, fmt.Errorf("unmarshal list caches csv: %w", err)
 	}
+	setCacheIDs(caches)
 
 	return caches, nil
 }
 
+// setCacheIDs sets the ID of the cache of each row, which is the one of the last cache
+// listed before its cores
+func setCacheIDs(caches []*Cache) {
+	var cacheID uint16
+	for _, cache := range caches {
+		if cache.Type == TypeCache {
+			cacheID = cache.ID
+		}
+
+		cache.CacheID = cacheID
+	}
+}
+
 type CacheStats struct {
 	ID                                uint16  `csv:"Cache Id"`
 	Size4K                            float64 `csv:"Cache Size [4KiB Blocks]"`
@@ -118,16 +269,43 @@ type CacheStats struct {
 	TotalErrorsPercent                float64 `csv:"Total errors [%]"`
 }
 
-func GetCacheStats(ctx context.Context, cacheID uint16) (*CacheStats, error) {
-	b, err := exec.CommandContext(ctx, casaCmd, "--stats", "--cache-id", strconv.Itoa(int(cacheID)), "--output-format", "csv").CombinedOutput()
+// CacheStatsOutput returns the raw CSV output of the stats of a cache
+func (c *Client) CacheStatsOutput(ctx context.Context, cacheID uint16) ([]byte, error) {
+	b, err := c.runner.Run(ctx, c.schema.Args("--stats", "--cache-id", strconv.Itoa(int(cacheID)), "--output-format", "csv")...)
 	if err != nil {
-		return nil, fmt.Errorf("list caches: %w: '%s'", err, b)
+		return nil, classifyError(ctx, fmt.Errorf("cache stats: %w: '%s'", err, b), b)
 	}
 
+	return b, nil
+}
+
+func (c *Client) GetCacheStats(ctx context.Context, cacheID uint16) (*CacheStats, error) {
+	b, err := c.CacheStatsOutput(ctx, cacheID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ParseCacheStats(b)
+}
+
+// ParseCacheStats parses the raw CSV output of the stats of a cache
+func (c *Client) ParseCacheStats(b []byte) (*CacheStats, error) {
 	stats := []*CacheStats{}
 
-	if err := gocsv.UnmarshalBytes(b, &stats); err != nil {
-		return nil, fmt.Errorf("unmarshal cache stats csv: %w", err)
+	if err := decodeCSV(bytes.NewReader(b), &stats); err != nil {
+		// Caches that aren't running may report values that don't parse, keep the rest
+		s := &CacheStats{}
+		fields, perr := unmarshalPartial(b, s)
+		if perr != nil {
+			return nil, fmt.Errorf("unmarshal cache stats csv: %w", err)
+		}
+
+		// If all the fields parse, the CSV itself is wrong (e.g. the rows after the first)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("unmarshal cache stats csv: %w", err)
+		}
+
+		return s, &PartialStatsError{Fields: fields}
 	}
 
 	if len(stats) == 0 {
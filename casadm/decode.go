@@ -0,0 +1,120 @@
+package casadm
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// decodeCSV decodes the CSV rows into the slice of struct pointers as they're read, matching
+// the headers with the csv tags of the struct regardless of their capitalization and
+// spacing. Unlike gocsv, the whole CSV isn't read into memory before decoding it
+func decodeCSV(r io.Reader, out interface{}) error {
+	slice := reflect.ValueOf(out).Elem()
+	t := slice.Type().Elem().Elem()
+
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		return fmt.Errorf("read csv header: %w", err)
+	}
+	// The record is reused by the next reads
+	header = slices.Clone(header)
+
+	fields := map[string]int{}
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("csv"); tag != "" && tag != "-" {
+			fields[normalizeHeader(tag)] = i
+		}
+	}
+
+	// columns are the struct field of each column, or -1 if it has none
+	columns := make([]int, len(header))
+	for i, h := range header {
+		columns[i] = -1
+		if f, ok := fields[normalizeHeader(h)]; ok {
+			columns[i] = f
+		}
+	}
+
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return fmt.Errorf("read csv row: %w", err)
+		}
+
+		v := reflect.New(t)
+		for i, s := range record {
+			if columns[i] == -1 {
+				continue
+			}
+
+			if err := decodeField(v.Elem().Field(columns[i]), s); err != nil {
+				return fmt.Errorf("decode line %d column '%s': %w", line, header[i], err)
+			}
+		}
+
+		slice.Set(reflect.Append(slice, v))
+	}
+}
+
+// decodeField sets the field from the CSV value, both when decoding the whole CSV and the
+// partial stats. Empty numbers are 0 and the decimals of the integers are dropped, as
+// casadm formats some counters as floats
+func decodeField(f reflect.Value, s string) error {
+	if f.Kind() == reflect.String {
+		f.SetString(s)
+		return nil
+	}
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		f.SetZero()
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s, _, _ = strings.Cut(s, ".")
+		n, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s, _, _ = strings.Cut(s, ".")
+		n, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+
+	default:
+		return fmt.Errorf("unsupported field kind %s", f.Kind())
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package casadm
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/gocarina/gocsv"
+)
+
+// largeStats returns the CSV stats of the rows, with a value in every column
+func largeStats(rows int) []byte {
+	t := reflect.TypeOf(CacheStats{})
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+
+	header := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		header = append(header, t.Field(i).Tag.Get("csv"))
+	}
+	w.Write(header)
+
+	for r := 0; r < rows; r++ {
+		record := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			switch t.Field(i).Type.Kind() {
+			case reflect.String:
+				record = append(record, "Running")
+			case reflect.Float32, reflect.Float64:
+				record = append(record, strconv.Itoa(r%100)+".5")
+			case reflect.Uint16:
+				record = append(record, strconv.Itoa(r%65536))
+			default:
+				record = append(record, strconv.Itoa(r*1000))
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+
+	return buf.Bytes()
+}
+
+// BenchmarkDecodeStats compares decoding the stats as they're streamed with reading the
+// whole output and unmarshaling it with gocsv, like before the streaming decoder
+func BenchmarkDecodeStats(b *testing.B) {
+	dir := b.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "stats-1.csv"), largeStats(4096), 0o644); err != nil {
+		b.Fatalf("write stats: %v", err)
+	}
+
+	r := &FileRunner{Dir: dir}
+	args := []string{"--stats", "--cache-id", "1", "--output-format", "csv"}
+
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			o, err := Stream(context.Background(), r, args...)
+			if err != nil {
+				b.Fatalf("stream stats: %v", err)
+			}
+
+			stats := []*CacheStats{}
+			if err := decodeCSV(o, &stats); err != nil {
+				b.Fatalf("decode stats: %v", err)
+			}
+			o.Close()
+		}
+	})
+
+	b.Run("read_all", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			o, err := Stream(context.Background(), r, args...)
+			if err != nil {
+				b.Fatalf("stream stats: %v", err)
+			}
+
+			out, err := io.ReadAll(o)
+			if err != nil {
+				b.Fatalf("read stats: %v", err)
+			}
+			o.Close()
+
+			stats := []*CacheStats{}
+			if err := gocsv.UnmarshalBytes(out, &stats); err != nil {
+				b.Fatalf("unmarshal stats: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package casadm
+
+import "context"
+
+// defaultClient returns the client of the package-level functions, which runs the detected
+// binary. It's created on every call, like the binary was looked up by the functions
+func defaultClient() (*Client, error) {
+	return NewClient("")
+}
+
+// ListCaches lists the caches and their cores with the detected binary.
+//
+// Deprecated: Use [Client.ListCaches], with a client created by [NewClient].
+func ListCaches(ctx context.Context) ([]*Cache, error) {
+	c, err := defaultClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ListCaches(ctx)
+}
+
+// GetCacheStats returns the stats of the cache with the detected binary.
+//
+// Deprecated: Use [Client.GetCacheStats], with a client created by [NewClient].
+func GetCacheStats(ctx context.Context, cacheID uint16) (*CacheStats, error) {
+	c, err := defaultClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetCacheStats(ctx, cacheID)
+}
@@ -0,0 +1,73 @@
+package casadm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os/exec"
+)
+
+// Kinds of the errors returned by the client, which can be checked with errors.Is
+var (
+	// ErrCacheNotFound is returned when the cache doesn't exist (e.g. it has been stopped)
+	ErrCacheNotFound = errors.New("cache not found")
+	// ErrPermissionDenied is returned when the binary can't be run or can't access the
+	// caches, usually because the exporter isn't running as root
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrCasadmNotInstalled is returned when the CAS administration binary can't be found
+	ErrCasadmNotInstalled = errors.New("casadm not installed")
+	// ErrTimeout is returned when the command hasn't finished before the context deadline
+	ErrTimeout = errors.New("timeout")
+)
+
+// kindError is an error with its kind, keeping the message of the original error
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+func withKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+// classifyError adds the kind to the error of a command, based on the error and the output
+// of the binary
+func classifyError(ctx context.Context, err error, out []byte) error {
+	out = bytes.ToLower(out)
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return withKind(ErrTimeout, err)
+
+	// The binary is missing, rather than the cache
+	case errors.Is(err, exec.ErrNotFound), isStartError(err) && errors.Is(err, fs.ErrNotExist):
+		return withKind(ErrCasadmNotInstalled, err)
+
+	case errors.Is(err, fs.ErrPermission),
+		bytes.Contains(out, []byte("permission denied")),
+		bytes.Contains(out, []byte("must be run as root")),
+		bytes.Contains(out, []byte("only root")):
+		return withKind(ErrPermissionDenied, err)
+
+	case bytes.Contains(out, []byte("does not exist")), bytes.Contains(out, []byte("doesn't exist")):
+		return withKind(ErrCacheNotFound, err)
+	}
+
+	return err
+}
+
+// isStartError returns whether the error comes from starting the process, instead of
+// the process failing
+func isStartError(err error) bool {
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr) && pathErr.Op == "fork/exec"
+}
@@ -0,0 +1,119 @@
+package casadm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileRunner reads the output of the binary from the files in a directory, written by an
+// external job, for hosts where the exporter can't run it. The files are named after the
+// command:
+//   - list-caches.csv: casadm --list-caches --output-format csv
+//   - stats-<cache id>.csv: casadm --stats --cache-id <cache id> --output-format csv
+//   - io-classes-<cache id>.csv: casadm --io-class --list --cache-id <cache id> --output-format csv
+//   - io-class-stats-<cache id>.csv: casadm --stats --cache-id <cache id> --io-class-id --output-format csv
+type FileRunner struct {
+	Dir string
+}
+
+func (r *FileRunner) Run(ctx context.Context, args ...string) ([]byte, error) {
+	name, err := fileName(args)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(filepath.Join(r.Dir, name))
+	if err != nil {
+		return nil, r.openError(args, err)
+	}
+
+	return b, nil
+}
+
+func (r *FileRunner) openError(args []string, err error) error {
+	err = fmt.Errorf("read input file: %w", err)
+	// The external job doesn't write the files of the caches that don't exist
+	if errors.Is(err, fs.ErrNotExist) && ParseArgs(args).HasCacheID {
+		return withKind(ErrCacheNotFound, err)
+	}
+
+	return err
+}
+
+// FixtureRunner returns the output of the binary from files in memory, named like the ones
+// of FileRunner, to check how the exporter parses the output of a casadm version
+type FixtureRunner struct {
+	Files map[string][]byte
+}
+
+func (r *FixtureRunner) Run(ctx context.Context, args ...string) ([]byte, error) {
+	name, err := fileName(args)
+	if err != nil {
+		return nil, err
+	}
+
+	b, ok := r.Files[name]
+	if !ok {
+		err := fmt.Errorf("missing fixture file '%s'", name)
+		if ParseArgs(args).HasCacheID {
+			return nil, withKind(ErrCacheNotFound, err)
+		}
+
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// fileName returns the name of the file with the output of the command
+func fileName(args []string) (string, error) {
+	a := ParseArgs(args)
+	cacheID := strconv.Itoa(int(a.CacheID))
+
+	switch {
+	case a.Command == CommandListCaches:
+		return "list-caches.csv", nil
+
+	case a.Command == CommandIOClasses && a.HasCacheID:
+		return "io-classes-" + cacheID + ".csv", nil
+
+	case a.Command == CommandIOClassStats && a.HasCacheID:
+		return "io-class-stats-" + cacheID + ".csv", nil
+
+	case a.Command == CommandStats && a.HasCacheID:
+		return "stats-" + cacheID + ".csv", nil
+	}
+
+	return "", fmt.Errorf("no input file for command '%s'", strings.Join(args, " "))
+}
+
+// ModTimes returns the modification time of the input files, indexed by file name
+func (r *FileRunner) ModTimes() (map[string]time.Time, error) {
+	entries, err := os.ReadDir(r.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("read input directory: %w", err)
+	}
+
+	times := map[string]time.Time{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".csv" {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("stat input file: %w", err)
+		}
+
+		times[e.Name()] = info.ModTime()
+	}
+
+	return times, nil
+}
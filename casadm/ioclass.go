@@ -0,0 +1,84 @@
+package casadm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type IOClass struct {
+	ID               uint16 `csv:"IO class ID"`
+	Name             string `csv:"IO class name"`
+	EvictionPriority string `csv:"Eviction priority"`
+	// Allocation is the ratio of the cache the IO class can use (e.g. "0.50"). Older
+	// versions report it as "YES" or "NO"
+	Allocation string `csv:"Allocation"`
+}
+
+// PassThrough returns whether the IO class is pinned to pass-through, so its requests are
+// never cached
+func (i *IOClass) PassThrough() bool {
+	a := strings.TrimSpace(i.Allocation)
+	if strings.EqualFold(a, "NO") {
+		return true
+	}
+
+	v, err := strconv.ParseFloat(a, 64)
+	return err == nil && v == 0
+}
+
+func ioClassesArgs(cacheID uint16) []string {
+	return []string{"--io-class", "--list", "--cache-id", strconv.Itoa(int(cacheID)), "--output-format", "csv"}
+}
+
+// IOClassesOutput returns the raw CSV output of the IO classes configuration of a cache
+func (c *Client) IOClassesOutput(ctx context.Context, cacheID uint16) ([]byte, error) {
+	b, err := c.runner.Run(ctx, c.schema.Args(ioClassesArgs(cacheID)...)...)
+	if err != nil {
+		return nil, classifyError(ctx, fmt.Errorf("list io classes: %w: '%s'", err, b), b)
+	}
+
+	return b, nil
+}
+
+func (c *Client) ListIOClasses(ctx context.Context, cacheID uint16) ([]*IOClass, error) {
+	classes := []*IOClass{}
+
+	if err := c.decodeOutput(ctx, "list io classes", &classes, ioClassesArgs(cacheID)...); err != nil {
+		return nil, err
+	}
+
+	return classes, nil
+}
+
+type IOClassStats struct {
+	ID                        uint16 `csv:"IO class ID"`
+	Name                      string `csv:"IO class name"`
+	PassThroughReadsRequests  int    `csv:"Pass-Through reads [Requests]"`
+	PassThroughWritesRequests int    `csv:"Pass-Through writes [Requests]"`
+}
+
+func ioClassStatsArgs(cacheID uint16) []string {
+	return []string{"--stats", "--cache-id", strconv.Itoa(int(cacheID)), "--io-class-id", "--output-format", "csv"}
+}
+
+// IOClassStatsOutput returns the raw CSV output of the stats of all the IO classes of a cache
+func (c *Client) IOClassStatsOutput(ctx context.Context, cacheID uint16) ([]byte, error) {
+	b, err := c.runner.Run(ctx, c.schema.Args(ioClassStatsArgs(cacheID)...)...)
+	if err != nil {
+		return nil, classifyError(ctx, fmt.Errorf("io class stats: %w: '%s'", err, b), b)
+	}
+
+	return b, nil
+}
+
+func (c *Client) GetIOClassStats(ctx context.Context, cacheID uint16) ([]*IOClassStats, error) {
+	stats := []*IOClassStats{}
+
+	if err := c.decodeOutput(ctx, "io class stats", &stats, ioClassStatsArgs(cacheID)...); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,58 @@
+package casadm
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// PartialStatsError is returned along with the stats when some of their fields can't be
+// parsed, such as the ones of Incomplete or Stopping caches
+type PartialStatsError struct {
+	// Fields are the names of the CacheStats fields that couldn't be parsed
+	Fields []string
+}
+
+func (e *PartialStatsError) Error() string {
+	return fmt.Sprintf("partial cache stats, unparsable fields: %s", strings.Join(e.Fields, ", "))
+}
+
+// unmarshalPartial unmarshals the first row of the CSV into the struct field by field,
+// leaving the fields that can't be parsed empty. It returns the names of those fields
+func unmarshalPartial(b []byte, out interface{}) ([]string, error) {
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read csv: %w", err)
+	}
+
+	if len(records) < 2 {
+		return nil, errors.New("missing csv row")
+	}
+
+	v := reflect.ValueOf(out).Elem()
+	t := v.Type()
+
+	fields := map[string]int{}
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("csv"); tag != "" && tag != "-" {
+			fields[normalizeHeader(tag)] = i
+		}
+	}
+
+	unparsed := []string{}
+	for i, h := range records[0] {
+		f, ok := fields[normalizeHeader(h)]
+		if !ok || i >= len(records[1]) {
+			continue
+		}
+
+		if err := decodeField(v.Field(f), records[1][i]); err != nil {
+			unparsed = append(unparsed, t.Field(f).Name)
+		}
+	}
+
+	return unparsed, nil
+}
@@ -0,0 +1,131 @@
+package casadm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// StreamRunner is a Runner that can also return the output of the binary as it's written,
+// so large outputs are parsed without holding them whole in memory
+type StreamRunner interface {
+	Runner
+	// Stream runs the binary with the arguments, returning its output. Closing the output
+	// waits for the binary to exit, returning its error
+	Stream(ctx context.Context, args ...string) (io.ReadCloser, error)
+}
+
+// Stream returns the output of the binary run by the runner as it's written, if the runner
+// supports it. Otherwise, the whole output is read first
+func Stream(ctx context.Context, r Runner, args ...string) (io.ReadCloser, error) {
+	if sr, ok := r.(StreamRunner); ok {
+		return sr.Stream(ctx, args...)
+	}
+
+	b, err := r.Run(ctx, args...)
+
+	return &bufferedOutput{Reader: bytes.NewReader(b), err: err}, nil
+}
+
+// bufferedOutput is an output read whole, whose error is returned on Close
+type bufferedOutput struct {
+	*bytes.Reader
+
+	err error
+}
+
+func (o *bufferedOutput) Close() error {
+	return o.err
+}
+
+func (r *ExecRunner) Stream(ctx context.Context, args ...string) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+
+	cmd := exec.CommandContext(ctx, r.Binary, args...)
+	// The errors are written along with the output, like with Run
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		done <- err
+	}()
+
+	return &execOutput{PipeReader: pr, done: done}, nil
+}
+
+type execOutput struct {
+	*io.PipeReader
+
+	done chan error
+}
+
+// Close discards the rest of the output, so the binary doesn't block writing it, and waits
+// for it to exit
+func (o *execOutput) Close() error {
+	io.Copy(io.Discard, o.PipeReader)
+
+	return <-o.done
+}
+
+func (r *FileRunner) Stream(ctx context.Context, args ...string) (io.ReadCloser, error) {
+	name, err := fileName(args)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(r.Dir, name))
+	if err != nil {
+		return nil, r.openError(args, err)
+	}
+
+	return f, nil
+}
+
+// decodeOutput decodes the CSV output of the command (with the Open CAS flags) into out as
+// it's written, returning the command error, if any, along with the beginning of its output
+func (c *Client) decodeOutput(ctx context.Context, name string, out interface{}, args ...string) error {
+	o, err := Stream(ctx, c.runner, c.schema.Args(args...)...)
+	if err != nil {
+		return classifyError(ctx, fmt.Errorf("%s: %w", name, err), nil)
+	}
+
+	// Keep the beginning of the output for the error messages, which are short
+	head := &headBuffer{max: 4096}
+	derr := decodeCSV(io.TeeReader(o, head), out)
+
+	if err := o.Close(); err != nil {
+		return classifyError(ctx, fmt.Errorf("%s: %w: '%s'", name, err, head.Bytes()), head.Bytes())
+	}
+
+	if derr != nil {
+		return fmt.Errorf("unmarshal %s csv: %w", name, derr)
+	}
+
+	return nil
+}
+
+// headBuffer keeps the first max bytes written to it
+type headBuffer struct {
+	bytes.Buffer
+
+	max int
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if n := b.max - b.Len(); n > 0 {
+		b.Buffer.Write(p[:min(n, len(p))])
+	}
+
+	return len(p), nil
+}
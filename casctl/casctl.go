@@ -0,0 +1,123 @@
+package casctl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultConfig is the path of the configuration file casctl reads the caches from
+const DefaultConfig = "/etc/opencas/opencas.conf"
+
+type Config struct {
+	Version string
+	Caches  []*Cache
+	Cores   []*Core
+}
+
+type Cache struct {
+	ID     uint16
+	Device string
+	Mode   string
+	// Extra are the optional key=value fields (e.g. ioclass_file)
+	Extra map[string]string
+}
+
+type Core struct {
+	CacheID uint16
+	ID      uint16
+	Device  string
+}
+
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open casctl config: %w", err)
+	}
+	defer f.Close()
+
+	cfg := &Config{}
+	section := ""
+	line := 0
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line++
+
+		l, _, _ := strings.Cut(s.Text(), "#")
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+
+		if strings.HasPrefix(l, "[") && strings.HasSuffix(l, "]") {
+			section = l[1 : len(l)-1]
+			continue
+		}
+
+		fields := strings.Fields(l)
+
+		switch section {
+		case "":
+			if k, v, ok := strings.Cut(l, "="); ok && strings.TrimSpace(k) == "version" {
+				cfg.Version = strings.TrimSpace(v)
+			}
+
+		case "caches":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("parse casctl config line %d: invalid cache", line)
+			}
+
+			id, err := strconv.ParseUint(fields[0], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("parse casctl config line %d: invalid cache id: %w", line, err)
+			}
+
+			c := &Cache{
+				ID:     uint16(id),
+				Device: fields[1],
+				Mode:   strings.ToLower(fields[2]),
+				Extra:  map[string]string{},
+			}
+
+			if len(fields) > 3 {
+				for _, e := range strings.Split(strings.Join(fields[3:], ""), ",") {
+					if k, v, ok := strings.Cut(e, "="); ok {
+						c.Extra[k] = v
+					}
+				}
+			}
+
+			cfg.Caches = append(cfg.Caches, c)
+
+		case "cores":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("parse casctl config line %d: invalid core", line)
+			}
+
+			cacheID, err := strconv.ParseUint(fields[0], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("parse casctl config line %d: invalid cache id: %w", line, err)
+			}
+
+			id, err := strconv.ParseUint(fields[1], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("parse casctl config line %d: invalid core id: %w", line, err)
+			}
+
+			cfg.Cores = append(cfg.Cores, &Core{
+				CacheID: uint16(cacheID),
+				ID:      uint16(id),
+				Device:  fields[2],
+			})
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read casctl config: %w", err)
+	}
+
+	return cfg, nil
+}
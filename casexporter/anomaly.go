@@ -0,0 +1,154 @@
+package casexporter
+
+import (
+	"math"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+const (
+	AnomalyHitRatio  = "hit_ratio"
+	AnomalyErrorRate = "error_rate"
+)
+
+// anomalyMinSamples is the number of samples required before values are flagged, so
+// the baseline is meaningful
+const anomalyMinSamples = 10
+
+// window is a rolling window of samples
+type window struct {
+	samples []float64
+	next    int
+	full    bool
+}
+
+func newWindow(size int) *window {
+	return &window{
+		samples: make([]float64, size),
+	}
+}
+
+func (w *window) add(v float64) {
+	w.samples[w.next] = v
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+func (w *window) len() int {
+	if w.full {
+		return len(w.samples)
+	}
+
+	return w.next
+}
+
+func (w *window) meanStddev() (float64, float64) {
+	n := w.len()
+	if n == 0 {
+		return 0, 0
+	}
+
+	sum := 0.0
+	for _, v := range w.samples[:n] {
+		sum += v
+	}
+	mean := sum / float64(n)
+
+	variance := 0.0
+	for _, v := range w.samples[:n] {
+		variance += (v - mean) * (v - mean)
+	}
+
+	return mean, math.Sqrt(variance / float64(n))
+}
+
+// anomalyDetector tracks a rolling baseline of the hit ratio and error rate of each cache
+type anomalyDetector struct {
+	zscore float64
+	size   int
+	// windows are indexed by cache ID and anomaly type
+	windows map[uint16]map[string]*window
+}
+
+func newAnomalyDetector(zscore float64, size int) *anomalyDetector {
+	if size < anomalyMinSamples {
+		size = anomalyMinSamples
+	}
+
+	return &anomalyDetector{
+		zscore:  zscore,
+		size:    size,
+		windows: map[uint16]map[string]*window{},
+	}
+}
+
+// check adds the value to the baseline and returns whether it deviates from it
+func (d *anomalyDetector) check(cacheID uint16, typ string, v float64) bool {
+	if _, ok := d.windows[cacheID]; !ok {
+		d.windows[cacheID] = map[string]*window{}
+	}
+
+	w, ok := d.windows[cacheID][typ]
+	if !ok {
+		w = newWindow(d.size)
+		d.windows[cacheID][typ] = w
+	}
+
+	anomaly := false
+	if w.len() >= anomalyMinSamples {
+		mean, stddev := w.meanStddev()
+		if stddev == 0 {
+			anomaly = v != mean
+		} else {
+			anomaly = math.Abs(v-mean)/stddev > d.zscore
+		}
+	}
+
+	w.add(v)
+
+	return anomaly
+}
+
+// detectAnomalies exports whether the hit ratio and error rate of each cache since the
+// previous extraction deviate from their baseline
+func (e *CasExporter) detectAnomalies(prev, cur *snapshot) {
+	if e.anomalies == nil || prev == nil {
+		return
+	}
+
+	elapsed := cur.at.Sub(prev.at).Seconds()
+
+	for _, c := range cur.caches {
+		if c.Type != casadm.TypeCache {
+			continue
+		}
+
+		stats, ok := cur.stats[c.ID]
+		if !ok {
+			continue
+		}
+
+		prevStats, ok := prev.stats[c.ID]
+		if !ok {
+			continue
+		}
+
+		labels := e.labelSchema.cacheLabels(c.ID, c.Disk)
+
+		hits := float64(stats.ReadHitsRequests + stats.WriteHitsRequests - prevStats.ReadHitsRequests - prevStats.WriteHitsRequests)
+		requests := float64(stats.ReadTotalRequests + stats.WriteTotalRequests - prevStats.ReadTotalRequests - prevStats.WriteTotalRequests)
+		// Without requests (or after a stats reset) there's no hit ratio
+		if requests > 0 && hits >= 0 {
+			anomaly := e.anomalies.check(c.ID, AnomalyHitRatio, hits/requests)
+			e.set(e.ocfCacheAnomaly, withLabel(labels, "type", AnomalyHitRatio), boolFloat(anomaly))
+		}
+
+		errors := float64(stats.TotalErrorsRequests - prevStats.TotalErrorsRequests)
+		if elapsed > 0 && errors >= 0 {
+			anomaly := e.anomalies.check(c.ID, AnomalyErrorRate, errors/elapsed)
+			e.set(e.ocfCacheAnomaly, withLabel(labels, "type", AnomalyErrorRate), boolFloat(anomaly))
+		}
+	}
+}
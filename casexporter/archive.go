@@ -0,0 +1,96 @@
+package casexporter
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+// WriteSnapshot writes a tar.gz archive with the inventory, the raw casadm output and the
+// exporter configuration, to be attached to support tickets. The casadm output is
+// extracted when the archive is generated; failed commands are written with their error
+func (e *CasExporter) WriteSnapshot(ctx context.Context, w io.Writer, config any) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	add := func(name string, b []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    int64(len(b)),
+			ModTime: now,
+		}); err != nil {
+			return fmt.Errorf("write %s header: %w", name, err)
+		}
+
+		if _, err := tw.Write(b); err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+
+		return nil
+	}
+
+	addJSON := func(name string, v any) error {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal %s: %w", name, err)
+		}
+
+		return add(name, b)
+	}
+
+	if err := addJSON("inventory.json", e.Inventory()); err != nil {
+		return err
+	}
+
+	if err := addJSON("config.json", config); err != nil {
+		return err
+	}
+
+	// The caches are parsed from the listing in the archive, instead of listing them again
+	var caches []*casadm.Cache
+	list, err := e.cas.ListCachesOutput(ctx)
+	if err == nil {
+		caches, err = e.cas.ParseCaches(list)
+	} else {
+		list = []byte(err.Error())
+	}
+
+	if err := add("casadm/list-caches.csv", list); err != nil {
+		return err
+	}
+
+	if err == nil {
+		for _, c := range e.shard.filter(caches) {
+			if c.Type != casadm.TypeCache {
+				continue
+			}
+
+			stats, err := e.cas.CacheStatsOutput(ctx, c.ID)
+			if err != nil {
+				stats = []byte(err.Error())
+			}
+
+			if err := add(fmt.Sprintf("casadm/stats-%d.csv", c.ID), stats); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("close tar: %w", err)
+	}
+
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("close gzip: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package casexporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+// DefaultBaselineDir is the directory where the baselines are stored by default
+const DefaultBaselineDir = "/var/lib/cas-exporter/baselines"
+
+// baselineStats are the key stats captured in the baselines
+var baselineStats = map[string]func(s *casadm.CacheStats) float64{
+	"occupancy_percent":  func(s *casadm.CacheStats) float64 { return s.OccupancyPercent },
+	"dirty_percent":      func(s *casadm.CacheStats) float64 { return s.DirtyPercent },
+	"read_hits_percent":  func(s *casadm.CacheStats) float64 { return s.ReadHitsPercent },
+	"write_hits_percent": func(s *casadm.CacheStats) float64 { return s.WriteHitsPercent },
+	"serviced_percent":   func(s *casadm.CacheStats) float64 { return s.ServicedRequestsPercent },
+	"pt_reads_percent":   func(s *casadm.CacheStats) float64 { return s.PassThroughReadsPercent },
+	"pt_writes_percent":  func(s *casadm.CacheStats) float64 { return s.PassThroughWritesPercent },
+	"errors_percent":     func(s *casadm.CacheStats) float64 { return s.TotalErrorsPercent },
+}
+
+// Baseline is a named capture of the key stats of the caches, used to compare them
+// during tuning experiments
+type Baseline struct {
+	Name       string    `json:"name"`
+	CapturedAt time.Time `json:"captured_at"`
+	// Caches are the stats of each cache, indexed by cache ID and stat name
+	Caches map[string]map[string]float64 `json:"caches"`
+}
+
+func newBaseline(name string, at time.Time, stats map[uint16]*casadm.CacheStats) *Baseline {
+	b := &Baseline{
+		Name:       name,
+		CapturedAt: at,
+		Caches:     map[string]map[string]float64{},
+	}
+
+	for id, s := range stats {
+		values := map[string]float64{}
+		for name, f := range baselineStats {
+			values[name] = f(s)
+		}
+
+		b.Caches[strconv.Itoa(int(id))] = values
+	}
+
+	return b
+}
+
+// extractStats extracts the current stats of all the caches, indexed by cache ID
+func extractStats(ctx context.Context, cas *casadm.Client) (map[uint16]*casadm.CacheStats, error) {
+	caches, err := cas.ListCaches(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[uint16]*casadm.CacheStats{}
+	for _, c := range caches {
+		if c.Type != casadm.TypeCache {
+			continue
+		}
+
+		s, err := cas.GetCacheStats(ctx, c.ID)
+		if err != nil {
+			return nil, fmt.Errorf("cache %d: %w", c.ID, err)
+		}
+
+		stats[c.ID] = s
+	}
+
+	return stats, nil
+}
+
+// RecordBaseline extracts the current stats of all the caches as a baseline
+func RecordBaseline(ctx context.Context, cas *casadm.Client, name string) (*Baseline, error) {
+	stats, err := extractStats(ctx, cas)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBaseline(name, time.Now(), stats), nil
+}
+
+func baselinePath(dir, name string) string {
+	return filepath.Join(dir, name+".json")
+}
+
+func SaveBaseline(dir string, b *Baseline) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create baseline directory: %w", err)
+	}
+
+	f, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal baseline: %w", err)
+	}
+
+	if err := os.WriteFile(baselinePath(dir, b.Name), f, 0o644); err != nil {
+		return fmt.Errorf("write baseline: %w", err)
+	}
+
+	return nil
+}
+
+func LoadBaseline(dir, name string) (*Baseline, error) {
+	f, err := os.ReadFile(baselinePath(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("read baseline: %w", err)
+	}
+
+	b := &Baseline{}
+	if err := json.Unmarshal(f, b); err != nil {
+		return nil, fmt.Errorf("unmarshal baseline: %w", err)
+	}
+
+	return b, nil
+}
+
+// Deviation is the difference of a stat from its baseline
+type Deviation struct {
+	CacheID  uint16
+	Stat     string
+	Baseline float64
+	Current  float64
+}
+
+func (d Deviation) Value() float64 {
+	return d.Current - d.Baseline
+}
+
+// Deviations returns the deviations of the stats of the caches in the baseline, sorted by
+// cache and stat
+func (b *Baseline) Deviations(stats map[uint16]*casadm.CacheStats) []Deviation {
+	deviations := []Deviation{}
+	for id, s := range stats {
+		values, ok := b.Caches[strconv.Itoa(int(id))]
+		if !ok {
+			continue
+		}
+
+		for name, f := range baselineStats {
+			v, ok := values[name]
+			if !ok {
+				continue
+			}
+
+			deviations = append(deviations, Deviation{
+				CacheID:  id,
+				Stat:     name,
+				Baseline: v,
+				Current:  f(s),
+			})
+		}
+	}
+
+	sort.Slice(deviations, func(i, j int) bool {
+		if deviations[i].CacheID != deviations[j].CacheID {
+			return deviations[i].CacheID < deviations[j].CacheID
+		}
+
+		return deviations[i].Stat < deviations[j].Stat
+	})
+
+	return deviations
+}
+
+// CompareBaseline extracts the current stats of all the caches and returns their
+// deviations from the baseline
+func CompareBaseline(ctx context.Context, cas *casadm.Client, b *Baseline) ([]Deviation, error) {
+	stats, err := extractStats(ctx, cas)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Deviations(stats), nil
+}
+
+// collectBaselineDeviation exports the deviation of the stats from the configured baseline
+func (e *CasExporter) collectBaselineDeviation(s *snapshot) {
+	if e.baseline == nil {
+		return
+	}
+
+	devices := cacheDevices(s.caches)
+	for _, d := range e.baseline.Deviations(s.stats) {
+		labels := e.labelSchema.cacheLabels(d.CacheID, devices[d.CacheID])
+		labels["baseline"] = e.baseline.Name
+		labels["stat"] = d.Stat
+		e.set(e.ocfBaselineDeviation, labels, d.Value())
+	}
+}
@@ -0,0 +1,25 @@
+package casexporter
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SetCacheMode switches the caching mode of the cache with casadm, flushing the dirty data
+// first if flush is true, and extracts the stats right away, with a discovery, so the new
+// write policy is exported
+func (e *CasExporter) SetCacheMode(ctx context.Context, cacheID uint16, mode string, flush bool) error {
+	if err := e.cas.SetCacheMode(ctx, cacheID, mode, flush); err != nil {
+		return err
+	}
+
+	slog.Info("cache mode set",
+		slog.Int("cache_id", int(cacheID)),
+		slog.String("mode", mode),
+		slog.Bool("flush", flush),
+	)
+
+	e.wakeUp()
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package casexporter
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// OtherIdentity is the value of the identity labels of the series aggregated by
+// the cardinality guard
+const OtherIdentity = "other"
+
+// identityLabels are the labels that identify the cache objects (caches, cores, io
+// classes...) of a series, in both label schemas
+var identityLabels = map[string]bool{
+	"id":              true,
+	"device":          true,
+	"cache_id":        true,
+	"cache_device":    true,
+	"core_id":         true,
+	"exported_object": true,
+}
+
+// countFamilies are the gauge families whose values are counts, so their overflow can be
+// summed. The overflow of the rest of gauges (e.g. percentages or info metrics) is dropped
+var countFamilies = map[string]bool{
+	"ocf_count":                          true,
+	"ocf_pass_through_requests":          true,
+	"ocf_core_device_md_missing_devices": true,
+}
+
+// CardinalityGuard returns a gatherer that exposes at most limit distinct identity label
+// combinations. The counter, count and histogram series of the rest are aggregated into
+// series with the identity labels set to "other", the rest of them are dropped, and the
+// ocf_cardinality_limited metric is set. If limit is 0, there's no limit
+func CardinalityGuard(g prometheus.Gatherer, limit int) prometheus.Gatherer {
+	if limit <= 0 {
+		return g
+	}
+
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+
+		identities := map[string][]string{}
+		for _, mf := range mfs {
+			for _, m := range mf.Metric {
+				if key, values := identity(m); key != "" {
+					identities[key] = values
+				}
+			}
+		}
+
+		keys := make([]string, 0, len(identities))
+		for k := range identities {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return lessIdentity(identities[keys[i]], identities[keys[j]])
+		})
+
+		limited := len(keys) > limit
+		dropped := 0
+		if limited {
+			kept := map[string]bool{}
+			for _, k := range keys[:limit] {
+				kept[k] = true
+			}
+
+			for _, mf := range mfs {
+				dropped += aggregateOverflow(mf, kept)
+			}
+		}
+
+		mfs = append(mfs,
+			&dto.MetricFamily{
+				Name: proto.String("ocf_cardinality_limited"),
+				Help: proto.String("Whether the number of identity label combinations has exceeded the limit and the overflow has been aggregated"),
+				Type: dto.MetricType_GAUGE.Enum(),
+				Metric: []*dto.Metric{{
+					Gauge: &dto.Gauge{Value: proto.Float64(boolFloat(limited))},
+				}},
+			},
+			&dto.MetricFamily{
+				Name: proto.String("ocf_cardinality_label_combinations"),
+				Help: proto.String("Number of distinct identity label combinations before applying the limit"),
+				Type: dto.MetricType_GAUGE.Enum(),
+				Metric: []*dto.Metric{{
+					Gauge: &dto.Gauge{Value: proto.Float64(float64(len(keys)))},
+				}},
+			},
+			&dto.MetricFamily{
+				Name: proto.String("ocf_cardinality_dropped_series"),
+				Help: proto.String("Number of series beyond the limit that have been dropped, since they can't be aggregated"),
+				Type: dto.MetricType_GAUGE.Enum(),
+				Metric: []*dto.Metric{{
+					Gauge: &dto.Gauge{Value: proto.Float64(float64(dropped))},
+				}},
+			},
+		)
+
+		return mfs, err
+	})
+}
+
+// identity returns the key and values of the identity labels of the metric. If the metric
+// has no identity labels, the key is empty
+func identity(m *dto.Metric) (string, []string) {
+	values := []string{}
+	for _, l := range m.Label {
+		if identityLabels[l.GetName()] {
+			values = append(values, l.GetName()+"="+l.GetValue())
+		}
+	}
+
+	// Sort the cache id first, so identities are ordered by cache
+	sort.SliceStable(values, func(i, j int) bool {
+		return isCacheID(values[i]) && !isCacheID(values[j])
+	})
+
+	return strings.Join(values, ","), values
+}
+
+func isCacheID(v string) bool {
+	return strings.HasPrefix(v, "id=") || strings.HasPrefix(v, "cache_id=")
+}
+
+// lessIdentity sorts the identities numerically by id, so the lowest cache ids are kept
+func lessIdentity(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+
+		na, errA := strconv.Atoi(a[i][strings.Index(a[i], "=")+1:])
+		nb, errB := strconv.Atoi(b[i][strings.Index(b[i], "=")+1:])
+		if errA == nil && errB == nil {
+			return na < nb
+		}
+
+		return a[i] < b[i]
+	}
+
+	return len(a) < len(b)
+}
+
+// aggregateOverflow sums the series of the family whose identity isn't kept into series
+// with the identity labels set to "other", returning how many have been dropped instead
+// because they can't be summed
+func aggregateOverflow(mf *dto.MetricFamily, kept map[string]bool) int {
+	metrics := []*dto.Metric{}
+	others := map[string]*dto.Metric{}
+	dropped := 0
+
+	summable := mf.GetType() == dto.MetricType_COUNTER || mf.GetType() == dto.MetricType_HISTOGRAM ||
+		mf.GetType() == dto.MetricType_GAUGE && countFamilies[mf.GetName()]
+
+	for _, m := range mf.Metric {
+		key, _ := identity(m)
+		if key == "" || kept[key] {
+			metrics = append(metrics, m)
+			continue
+		}
+
+		// The native histograms have their own buckets, which can't be merged
+		if !summable || m.Histogram != nil && m.Histogram.Schema != nil {
+			dropped++
+			continue
+		}
+
+		labels := []string{}
+		for _, l := range m.Label {
+			if identityLabels[l.GetName()] {
+				l.Value = proto.String(OtherIdentity)
+			}
+
+			labels = append(labels, l.GetName()+"="+l.GetValue())
+		}
+		k := strings.Join(labels, ",")
+
+		o, ok := others[k]
+		if !ok {
+			others[k] = m
+			metrics = append(metrics, m)
+			continue
+		}
+
+		switch {
+		case o.Gauge != nil:
+			o.Gauge.Value = proto.Float64(o.Gauge.GetValue() + m.Gauge.GetValue())
+		case o.Counter != nil:
+			o.Counter.Value = proto.Float64(o.Counter.GetValue() + m.Counter.GetValue())
+		case o.Histogram != nil:
+			mergeHistogram(o.Histogram, m.Histogram)
+		}
+	}
+
+	mf.Metric = metrics
+
+	return dropped
+}
+
+// mergeHistogram adds the samples of h to o. The buckets are matched by their upper bound,
+// since the series of a family can have different ones
+func mergeHistogram(o, h *dto.Histogram) {
+	o.SampleCount = proto.Uint64(o.GetSampleCount() + h.GetSampleCount())
+	o.SampleSum = proto.Float64(o.GetSampleSum() + h.GetSampleSum())
+
+	// The counts are cumulative, so the buckets missing in a histogram have the count of
+	// the next lower bound it has
+	bounds := []float64{}
+	for _, b := range append(append([]*dto.Bucket{}, o.Bucket...), h.Bucket...) {
+		if i, found := slices.BinarySearch(bounds, b.GetUpperBound()); !found {
+			bounds = slices.Insert(bounds, i, b.GetUpperBound())
+		}
+	}
+
+	buckets := make([]*dto.Bucket, 0, len(bounds))
+	for _, ub := range bounds {
+		buckets = append(buckets, &dto.Bucket{
+			UpperBound:      proto.Float64(ub),
+			CumulativeCount: proto.Uint64(cumulativeCount(o.Bucket, ub) + cumulativeCount(h.Bucket, ub)),
+		})
+	}
+	o.Bucket = buckets
+}
+
+// cumulativeCount returns the number of samples of the buckets up to the upper bound
+func cumulativeCount(buckets []*dto.Bucket, upperBound float64) uint64 {
+	count := uint64(0)
+	for _, b := range buckets {
+		if b.GetUpperBound() > upperBound {
+			break
+		}
+		count = b.GetCumulativeCount()
+	}
+
+	return count
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
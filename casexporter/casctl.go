@@ -0,0 +1,61 @@
+package casexporter
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/casctl"
+)
+
+// StatusConfigured is the status of the caches that are configured in the casctl
+// configuration, but aren't running
+const StatusConfigured = "configured"
+
+// collectCacheInfo exports the status of the running caches, along with the ones
+// configured through casctl that aren't running
+func (e *CasExporter) collectCacheInfo(caches []*casadm.Cache) {
+	e.ocfCacheInfo.Reset()
+
+	running := map[uint16]bool{}
+	for _, c := range caches {
+		if c.Type != casadm.TypeCache {
+			continue
+		}
+
+		running[c.ID] = true
+
+		labels := e.labelSchema.cacheLabels(c.ID, c.Disk)
+		labels["status"] = c.Status
+		labels["write_policy"] = c.WritePolicy
+		e.ocfCacheInfo.With(labels).Set(1)
+	}
+
+	if e.casctlConfig == "" {
+		return
+	}
+
+	cfg, err := casctl.Load(e.casctlConfig)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("load casctl config",
+				slog.String("path", e.casctlConfig),
+				slog.String("err", err.Error()),
+			)
+		}
+
+		return
+	}
+
+	for _, c := range cfg.Caches {
+		if running[c.ID] || !e.shard.Owns(c.ID) {
+			continue
+		}
+
+		labels := e.labelSchema.cacheLabels(c.ID, c.Device)
+		labels["status"] = StatusConfigured
+		labels["write_policy"] = c.Mode
+		e.ocfCacheInfo.With(labels).Set(1)
+	}
+}
@@ -3,32 +3,160 @@ package casexporter
 import (
 	"context"
 	"log/slog"
-	"strconv"
+	"runtime"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/config"
+	"github.com/isard-vdi/CAS_Exporter/lockfile"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-func NewCasExporter(extractionInterval time.Duration) *CasExporter {
-	return &CasExporter{
-		extractionInterval: extractionInterval,
+type Config struct {
+	ExtractionInterval time.Duration
+	// DiscoveryInterval is the interval between caches discoveries, since the topology
+	// changes rarely. If it's 0, the caches are discovered on every extraction
+	DiscoveryInterval time.Duration
+	// NewCacheCheckInterval is the interval between checks for new exported objects, which
+	// trigger a discovery and an extraction right away. If it's 0, they're not checked
+	NewCacheCheckInterval time.Duration
+	// ConfigInterval is the interval between collections of the configuration data (kernel
+	// module, IO classes and systemd units), which changes rarely. If it's 0, it's collected
+	// on every extraction
+	ConfigInterval time.Duration
+	// IdleInterval is the interval between extractions while there are no caches, until
+	// a cache is discovered. If it's 0, ExtractionInterval is used
+	IdleInterval time.Duration
+	// CacheStatsTimeout is the maximum time the stats extraction of a single cache can take,
+	// so a cache that isn't responding doesn't delay the rest. If it's 0, there's no limit
+	CacheStatsTimeout time.Duration
+	// ExtractionDeadline is the maximum time the stats extraction of all the caches can
+	// take. The caches left when it's exceeded are skipped and marked as stale. If it's 0,
+	// there's no limit
+	ExtractionDeadline time.Duration
+	// CasctlConfig is the path of the casctl configuration. If it's empty, configured
+	// caches aren't reported
+	CasctlConfig string
+	// MetricTTL is the time after which the cache series that haven't been refreshed are
+	// dropped. If it's 0, they're never dropped
+	MetricTTL time.Duration
+	// HistorySize is the number of snapshots kept in memory for the history API
+	HistorySize int
+	// AnomalyZScore is the z-score above which the hit ratio and error rate of a cache
+	// are flagged as anomalies. If it's 0, anomaly detection is disabled
+	AnomalyZScore float64
+	// AnomalyWindow is the number of extractions used as the anomaly detection baseline
+	AnomalyWindow int
+	// Baseline is the baseline the stats deviation is exported against. If it's nil,
+	// no deviation is exported
+	Baseline *Baseline
+	// DirtyThreshold is the dirty percentage above which the caches are flagged. If it's 0,
+	// the caches without their own threshold aren't flagged
+	DirtyThreshold float64
+	// CacheDirtyThresholds are the dirty percentage thresholds of each cache, indexed by
+	// cache ID, which override DirtyThreshold
+	CacheDirtyThresholds map[uint16]float64
+	// StoragePools are the storage pools of the cache and core devices, indexed by device,
+	// added as labels to their series by PoolGatherer
+	StoragePools map[string]*config.StoragePool
+	// PressureWindow is the time constant of the moving average of the pressure signals. If
+	// it's 0, they aren't smoothed
+	PressureWindow time.Duration
+	// SystemdUnits are the systemd units whose state is reported. If it's empty, no state is
+	// reported
+	SystemdUnits []string
+	// Hooks are the commands run before and after every extraction
+	Hooks []*config.Hook
+	// LibvirtURI is the libvirt connection URI (e.g. qemu:///system) the running domains are
+	// queried from, to map their disks to the exported objects. If it's empty, they aren't
+	LibvirtURI string
+	// SampleTimestamps attaches to the cache series the time they were read from casadm
+	SampleTimestamps bool
+	// NativeHistograms enables the native (sparse) buckets of the duration histograms
+	NativeHistograms bool
+	// ConstLabels are added to all the exposed series
+	ConstLabels map[string]string
+	// LabelSchema is the set of identity labels of the series. If it's empty, the legacy
+	// schema is used
+	LabelSchema LabelSchema
+	// Shard is the subset of the caches handled by the exporter. If it's empty, all the
+	// caches are handled
+	Shard Shard
+	// Lock is the single instance lock held by the exporter. If it's nil, instance
+	// conflicts aren't reported
+	Lock *lockfile.Lock
+}
+
+func NewCasExporter(cfg Config, cas *casadm.Client) *CasExporter {
+	var anomalies *anomalyDetector
+	if cfg.AnomalyZScore > 0 {
+		anomalies = newAnomalyDetector(cfg.AnomalyZScore, cfg.AnomalyWindow)
+	}
+
+	schema := cfg.LabelSchema
+	if schema == "" {
+		schema = LabelSchemaLegacy
+	}
+
+	e := &CasExporter{
+		extractionInterval: cfg.ExtractionInterval,
+		idleInterval:       cfg.IdleInterval,
+		discoveryInterval:  cfg.DiscoveryInterval,
+		cacheStatsTimeout:  cfg.CacheStatsTimeout,
+		extractionDeadline: cfg.ExtractionDeadline,
+		cas:                cas,
+		casctlConfig:       cfg.CasctlConfig,
+		metricTTL:          cfg.MetricTTL,
+		series:             newSeriesTracker(),
+		history:            newHistory(cfg.HistorySize),
+		anomalies:          anomalies,
+		baseline:           cfg.Baseline,
+
+		dirtyThresholdDefault: cfg.DirtyThreshold,
+		cacheDirtyThresholds:  cfg.CacheDirtyThresholds,
+		storagePools:          cfg.StoragePools,
+		pressureWindow:        cfg.PressureWindow,
+		hooks:                 cfg.Hooks,
+		pressure:              map[uint16]*cachePressure{},
+
+		labelSchema:      schema,
+		nativeHistograms: cfg.NativeHistograms,
+		sampleTimestamps: cfg.SampleTimestamps,
+		constLabels:      cfg.ConstLabels,
+		lock:             cfg.Lock,
+		systemdUnits:     cfg.SystemdUnits,
+		libvirtURI:       cfg.LibvirtURI,
+		shard:            cfg.Shard,
+
+		newCacheCheckInterval: cfg.NewCacheCheckInterval,
+		wake:                  make(chan struct{}, 1),
+		extracted:             make(chan struct{}),
+
+		scheduler:       &scheduler{},
+		owners:          map[string]string{},
+		inflight:        newInflightCommands(),
+		pinnedIOClasses: map[uint16]map[uint16]bool{},
+		cacheDevices:    map[uint16]cacheDevice{},
+		statsOutputs:    map[uint16]statsOutput{},
+		lastErrors:      map[uint16]lastError{},
 
 		ocfStatCount: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "ocf_count",
 				Help: "OCF count value",
 			},
-			[]string{"device", "id", "category", "subcategory"},
+			schema.statsLabelNames("category", "subcategory"),
 		),
 		ocfStatPercentage: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "ocf_percentage",
 				Help: "OCF percentage value",
 			},
-			[]string{"device", "id", "category", "subcategory"},
+			schema.statsLabelNames("category", "subcategory"),
 		),
 		ocfStatDuration: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -37,6 +165,56 @@ func NewCasExporter(extractionInterval time.Duration) *CasExporter {
 			},
 			[]string{},
 		),
+		ocfExtractionDuration: prometheus.NewHistogramVec(
+			withNativeHistogram(prometheus.HistogramOpts{
+				Name:    "ocf_extraction_duration_seconds",
+				Help:    "OCF stats extraction duration distribution",
+				Buckets: prometheus.DefBuckets,
+			}, cfg.NativeHistograms),
+			[]string{},
+		),
+		ocfCasadmDuration: prometheus.NewHistogramVec(
+			withNativeHistogram(prometheus.HistogramOpts{
+				Name:    "ocf_casadm_duration_seconds",
+				Help:    "Duration of the casadm commands run by the exporter",
+				Buckets: prometheus.DefBuckets,
+			}, cfg.NativeHistograms),
+			[]string{"command"},
+		),
+		ocfGoMaxProcs: prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name: "ocf_exporter_gomaxprocs",
+				Help: "Maximum number of CPUs the exporter can use simultaneously (GOMAXPROCS)",
+			},
+			func() float64 { return float64(runtime.GOMAXPROCS(0)) },
+		),
+		ocfMemoryLimit: prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name: "ocf_exporter_memory_limit_bytes",
+				Help: "Soft memory limit of the exporter (GOMEMLIMIT)",
+			},
+			// A negative limit doesn't change it, only returns the current one
+			func() float64 { return float64(debug.SetMemoryLimit(-1)) },
+		),
+		ocfCacheLastUpdate: prometheus.NewDesc(
+			"ocf_cache_last_update_seconds",
+			"Seconds since the OCF cache stats were last read",
+			schema.cacheLabelNames(), nil,
+		),
+		ocfCacheStale: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_stale",
+				Help: "Whether the OCF cache stats have been skipped in the last extraction because its deadline was exceeded",
+			},
+			schema.cacheLabelNames(),
+		),
+		ocfCacheSuccess: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_success",
+				Help: "Whether the last OCF cache stats extraction has succeeded",
+			},
+			schema.cacheLabelNames(),
+		),
 		ocfStatSuccess: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "ocf_success",
@@ -44,34 +222,624 @@ func NewCasExporter(extractionInterval time.Duration) *CasExporter {
 			},
 			[]string{},
 		),
+		ocfExportedObjectInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_exported_object_info",
+				Help: "OCF exported object filesystem and LVM information",
+			},
+			schema.coreLabelNames("fs_type", "fs_label", "fs_uuid", "vg_name", "lv_name"),
+		),
+		ocfExportedObjectHolderInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_exported_object_holder_info",
+				Help: "OCF exported object block devices stacked on top of it",
+			},
+			schema.coreLabelNames("holder", "parent", "holder_type", "holder_name"),
+		),
+		ocfExportedObjectDomainInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_exported_object_domain_info",
+				Help: "libvirt domains with disks on the OCF exported object",
+			},
+			schema.coreLabelNames("domain", "disk", "path"),
+		),
+		ocfStorageTopologyInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_storage_topology_info",
+				Help: "OCF exported object full storage stacking, from the disks below the core device to the filesystem on top",
+			},
+			schema.coreLabelNames("stack", "fs_type"),
+		),
+		ocfCoreDeviceMDInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_core_device_md_info",
+				Help: "md arrays the OCF core device is or is stacked on, with their level, state and running sync",
+			},
+			schema.coreLabelNames("array", "level", "array_state", "sync_action"),
+		),
+		ocfCoreDeviceMDDegraded: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_core_device_md_degraded",
+				Help: "Whether the md array below the OCF core device is missing devices",
+			},
+			schema.coreLabelNames("array"),
+		),
+		ocfCoreDeviceMDMissingDevices: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_core_device_md_missing_devices",
+				Help: "Number of devices missing from the md array below the OCF core device",
+			},
+			schema.coreLabelNames("array"),
+		),
+		ocfCacheDeviceReplaced: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_device_replaced_timestamp_seconds",
+				Help: "Last time the device behind the OCF cache changed its path or serial",
+			},
+			schema.cacheLabelNames(),
+		),
+		ocfCacheDeviceTemperature: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_device_temperature_celsius",
+				Help: "OCF cache device temperature",
+			},
+			schema.cacheLabelNames(),
+		),
+		ocfCacheDevicePCIeSpeed: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_device_pcie_link_speed_gigatransfers_per_second",
+				Help: "OCF cache device PCIe link speed",
+			},
+			schema.cacheLabelNames("link"),
+		),
+		ocfCacheDevicePCIeWidth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_device_pcie_link_width_lanes",
+				Help: "OCF cache device PCIe link width",
+			},
+			schema.cacheLabelNames("link"),
+		),
+		ocfCacheDevicePCIeDegraded: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_device_pcie_link_degraded",
+				Help: "Whether the OCF cache device PCIe link has been negotiated below its maximum speed or width",
+			},
+			schema.cacheLabelNames(),
+		),
+		ocfModuleParameter: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_module_parameter",
+				Help: "OCF kernel module numeric parameter value",
+			},
+			[]string{"module", "parameter"},
+		),
+		ocfModuleParameterInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_module_parameter_info",
+				Help: "OCF kernel module non numeric parameter value",
+			},
+			[]string{"module", "parameter", "value"},
+		),
+		ocfKernelInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_kernel_info",
+				Help: "OCF running kernel and kernel module versions",
+			},
+			[]string{"kernel_version", "module_version", "module_taint"},
+		),
+		ocfModuleLoaded: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_module_loaded",
+				Help: "Whether the OCF kernel module is loaded",
+			},
+			[]string{},
+		),
+		ocfModuleCompatible: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_module_compatible",
+				Help: "Whether the OCF kernel module is loaded and compatible with the running kernel",
+			},
+			[]string{},
+		),
+		ocfSystemdUnitState: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_systemd_unit_state",
+				Help: "Whether the Open CAS systemd unit is in the state",
+			},
+			[]string{"unit", "state"},
+		),
+		ocfCacheInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_info",
+				Help: "OCF cache status and write policy",
+			},
+			schema.cacheLabelNames("status", "write_policy"),
+		),
+		ocfCacheAnomaly: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_anomaly",
+				Help: "Whether the OCF cache value deviates from its rolling baseline beyond the configured z-score",
+			},
+			schema.cacheLabelNames("type"),
+		),
+		ocfBaselineDeviation: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_baseline_deviation",
+				Help: "OCF cache stat difference from the value captured in the baseline",
+			},
+			schema.cacheLabelNames("baseline", "stat"),
+		),
+		ocfCacheWarmupFillRate: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_warmup_fill_rate_blocks_per_second",
+				Help: "OCF cache occupancy growth since the previous extraction, in 4KiB blocks per second",
+			},
+			schema.cacheLabelNames(),
+		),
+		ocfCacheWarmupTimeToFull: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_warmup_time_to_full_seconds",
+				Help: "OCF cache estimated time until it's full at the current fill rate",
+			},
+			schema.cacheLabelNames(),
+		),
+		ocfCacheBlockServingRatio: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_block_serving_ratio",
+				Help: "OCF cache ratio of the blocks read since the previous extraction that have been served from the cache",
+			},
+			schema.cacheLabelNames(),
+		),
+		ocfCacheDirtyThresholdExceeded: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_dirty_threshold_exceeded",
+				Help: "Whether the OCF cache dirty percentage exceeds its configured threshold",
+			},
+			schema.cacheLabelNames(),
+		),
+		ocfCachePressure: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_pressure",
+				Help: "OCF cache smoothed pressure, from 0 to 1, of each signal (occupancy, dirty or errors), for the external schedulers",
+			},
+			schema.cacheLabelNames("signal"),
+		),
+		ocfPassThroughRequests: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_pass_through_requests",
+				Help: "OCF cache pass-through requests by cause",
+			},
+			schema.cacheLabelNames("cause", "operation"),
+		),
+		ocfCollectionErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ocf_collection_errors_total",
+				Help: "Number of OCF stats extraction errors",
+			},
+			[]string{"stage"},
+		),
+		ocfHookDuration: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_hook_duration_seconds",
+				Help: "Duration of the last run of the extraction hooks",
+			},
+			[]string{"hook", "stage"},
+		),
+		ocfHookSuccess: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_hook_success",
+				Help: "Whether the last run of the extraction hooks has succeeded",
+			},
+			[]string{"hook", "stage"},
+		),
+		ocfHookFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ocf_hook_failures_total",
+				Help: "Number of failed runs of the extraction hooks, by reason (error or timeout)",
+			},
+			[]string{"hook", "stage", "reason"},
+		),
+		ocfCollectionLag: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "ocf_collection_lag_seconds",
+				Help: "Delay between the scheduled and the actual start of the last OCF stats extraction",
+			},
+		),
+		ocfCollectionPanics: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "ocf_collection_panics_total",
+				Help: "Number of OCF stats extractions that have panicked",
+			},
+		),
+		ocfCaches: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_caches",
+				Help: "Number of OCF caches running",
+			},
+			[]string{},
+		),
+		ocfDiscoveryIdle: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_discovery_idle",
+				Help: "Whether there are no OCF caches running and the exporter is waiting for them to be created",
+			},
+			[]string{},
+		),
+		ocfCacheLastErrorInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_cache_last_error_info",
+				Help: "Time of the most recent OCF cache extraction failure, with its stage and classified reason",
+			},
+			schema.cacheLabelNames("stage", "reason"),
+		),
+		ocfInputFileModified: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_input_file_modified_timestamp_seconds",
+				Help: "Modification time of the file the casadm output is read from",
+			},
+			[]string{"file"},
+		),
+		ocfShardInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_exporter_shard_info",
+				Help: "Shard of the caches handled by the exporter instance",
+			},
+			[]string{"shard", "shards"},
+		),
+		ocfInstanceConflict: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ocf_instance_conflict",
+				Help: "Whether another exporter instance has been started on the host while this one is running",
+			},
+			[]string{},
+		),
 	}
+
+	e.ocfPaused = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "ocf_paused",
+			Help: "Whether the extraction is paused for maintenance",
+		},
+		func() float64 {
+			if e.Paused() {
+				return 1
+			}
+			return 0
+		},
+	)
+
+	e.ocfFaultInjected = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "ocf_fault_injected",
+			Help: "Whether a fault is being injected in the casadm commands, to rehearse the alerting",
+		},
+		func() float64 {
+			if e.FaultState().Fault != "" {
+				return 1
+			}
+			return 0
+		},
+	)
+
+	e.registerBuiltinCollectors(cfg.ConfigInterval)
+	e.collectShard()
+
+	e.fileRunner, _ = cas.Runner().(*casadm.FileRunner)
+	e.cas = cas.WithRunner(&timedRunner{
+		Runner:   &faultRunner{Runner: cas.Runner(), e: e},
+		duration: e.ocfCasadmDuration,
+		inflight: e.inflight,
+	})
+
+	return e
 }
 
 type CasExporter struct {
 	extractionInterval time.Duration
+	idleInterval       time.Duration
+	discoveryInterval  time.Duration
+	cacheStatsTimeout  time.Duration
+	extractionDeadline time.Duration
+	cas                *casadm.Client
+	// fileRunner reads the casadm output from files, if it's the runner of the client. It's
+	// kept apart, as the runner of the client is wrapped
+	fileRunner   *casadm.FileRunner
+	casctlConfig string
+	metricTTL    time.Duration
+
+	// caches are the caches found in the last discovery
+	caches       []*casadm.Cache
+	discoveredAt time.Time
+	// rediscover forces a discovery on the next extraction
+	rediscover bool
+	// extraction is the one in progress, nil if the caches couldn't be discovered
+	extraction *extraction
+	// wake wakes the extraction before the interval is over, forcing a discovery (e.g.
+	// when new exported objects are found)
+	wake                  chan struct{}
+	newCacheCheckInterval time.Duration
+	// extracted is closed once the first extraction is over
+	extracted     chan struct{}
+	extractedOnce sync.Once
+	// idle is whether there were no caches running in the last discovery
+	idle bool
+	// extractionStart is when the running extraction started, in unix nanoseconds, or 0 if
+	// there's none running
+	extractionStart atomic.Int64
+	// extractedAt is when the last extraction finished, in unix nanoseconds
+	extractedAt atomic.Int64
+	// panicBackoff is the time waited after the last extraction panic. It's 0 if the last
+	// extraction didn't panic
+	panicBackoff time.Duration
+	// inflight are the casadm commands that are running
+	inflight *inflightCommands
+	// pause is the maintenance pause, during which casadm isn't run
+	pauseMu sync.Mutex
+	pause   PauseState
+	// fault is the fault injected in the casadm commands
+	faultMu sync.Mutex
+	fault   FaultState
+
+	// scheduler runs the collectors, each one on its interval
+	scheduler *scheduler
+	// collectors are the metrics of the registered collectors that expose their own. They're
+	// registered on their own, so they can be unchecked
+	collectors []prometheus.Collector
+	// owners are the collectors that own each metric family, selected with collect[]. The
+	// exporter own families are owned by ownerExporter
+	owners map[string]string
+	// pinnedIOClasses are the IO classes pinned to pass-through, indexed by cache ID
+	pinnedIOClasses map[uint16]map[uint16]bool
+	// moduleIncompatibility is why the module couldn't be used in the last collection, and
+	// moduleParametersMissing whether its parameters were missing, so they're only logged
+	// when they change
+	moduleIncompatibility   string
+	moduleParametersMissing bool
+	// nvmeNotInstalled is whether nvme-cli has been found missing, which is only warned
+	// about once
+	nvmeNotInstalled bool
+	// cacheDevices are the devices behind the caches in the previous extraction, indexed
+	// by cache ID
+	cacheDevices map[uint16]cacheDevice
+	// statsOutputs are the last stats outputs of the caches, indexed by cache ID, so the
+	// unchanged ones aren't parsed again
+	statsOutputs map[uint16]statsOutput
+	// lastErrors are the most recent extraction failures, indexed by cache ID
+	lastErrors map[uint16]lastError
+
+	series *seriesTracker
+
+	snapshotMu sync.RWMutex
+	snapshot   *snapshot
+	history    *history
+	// subscribers are notified of every new snapshot
+	subscribers []chan struct{}
+
+	anomalies *anomalyDetector
+	baseline  *Baseline
+
+	dirtyThresholdDefault float64
+	cacheDirtyThresholds  map[uint16]float64
+	storagePools          map[string]*config.StoragePool
+	pressureWindow        time.Duration
+	hooks                 []*config.Hook
+	// pressure are the smoothed pressure signals, indexed by cache ID
+	pressure map[uint16]*cachePressure
+
+	labelSchema      LabelSchema
+	nativeHistograms bool
+	sampleTimestamps bool
+	constLabels      map[string]string
+	lock             *lockfile.Lock
+	systemdUnits     []string
+	libvirtURI       string
+	shard            Shard
 
 	ocfStatCount      *prometheus.GaugeVec
 	ocfStatPercentage *prometheus.GaugeVec
 	ocfStatDuration   *prometheus.GaugeVec
 	ocfStatSuccess    *prometheus.GaugeVec
+	ocfCacheSuccess   *prometheus.GaugeVec
+	ocfCacheStale     *prometheus.GaugeVec
+
+	ocfCacheLastUpdate *prometheus.Desc
+
+	ocfGoMaxProcs    prometheus.GaugeFunc
+	ocfMemoryLimit   prometheus.GaugeFunc
+	ocfPaused        prometheus.GaugeFunc
+	ocfFaultInjected prometheus.GaugeFunc
+
+	ocfExtractionDuration *prometheus.HistogramVec
+	ocfCasadmDuration     *prometheus.HistogramVec
+
+	ocfCollectionErrors   *prometheus.CounterVec
+	ocfHookDuration       *prometheus.GaugeVec
+	ocfHookSuccess        *prometheus.GaugeVec
+	ocfHookFailures       *prometheus.CounterVec
+	ocfCollectionLag      prometheus.Gauge
+	ocfCollectionPanics   prometheus.Counter
+	ocfCacheLastErrorInfo *prometheus.GaugeVec
+
+	ocfCaches           *prometheus.GaugeVec
+	ocfDiscoveryIdle    *prometheus.GaugeVec
+	ocfInstanceConflict *prometheus.GaugeVec
+	ocfShardInfo        *prometheus.GaugeVec
+
+	ocfInputFileModified *prometheus.GaugeVec
+
+	ocfCacheInfo         *prometheus.GaugeVec
+	ocfCacheAnomaly      *prometheus.GaugeVec
+	ocfBaselineDeviation *prometheus.GaugeVec
+
+	ocfCacheWarmupFillRate   *prometheus.GaugeVec
+	ocfCacheWarmupTimeToFull *prometheus.GaugeVec
+
+	ocfCacheBlockServingRatio      *prometheus.GaugeVec
+	ocfCacheDirtyThresholdExceeded *prometheus.GaugeVec
+	ocfCachePressure               *prometheus.GaugeVec
+	ocfPassThroughRequests         *prometheus.GaugeVec
+
+	ocfExportedObjectInfo       *prometheus.GaugeVec
+	ocfExportedObjectHolderInfo *prometheus.GaugeVec
+	ocfExportedObjectDomainInfo *prometheus.GaugeVec
+	ocfStorageTopologyInfo      *prometheus.GaugeVec
+
+	ocfCoreDeviceMDInfo           *prometheus.GaugeVec
+	ocfCoreDeviceMDDegraded       *prometheus.GaugeVec
+	ocfCoreDeviceMDMissingDevices *prometheus.GaugeVec
+	ocfCacheDeviceTemperature     *prometheus.GaugeVec
+	ocfCacheDevicePCIeSpeed       *prometheus.GaugeVec
+	ocfCacheDevicePCIeWidth       *prometheus.GaugeVec
+	ocfCacheDevicePCIeDegraded    *prometheus.GaugeVec
+	ocfCacheDeviceReplaced        *prometheus.GaugeVec
+
+	ocfModuleParameter     *prometheus.GaugeVec
+	ocfModuleParameterInfo *prometheus.GaugeVec
+	ocfKernelInfo          *prometheus.GaugeVec
+	ocfModuleLoaded        *prometheus.GaugeVec
+	ocfModuleCompatible    *prometheus.GaugeVec
+
+	ocfSystemdUnitState *prometheus.GaugeVec
 }
 
 func (e *CasExporter) Describe(ch chan<- *prometheus.Desc) {
-	e.ocfStatCount.Describe(ch)
-	e.ocfStatPercentage.Describe(ch)
-	e.ocfStatDuration.Describe(ch)
-	e.ocfStatSuccess.Describe(ch)
+	for _, m := range e.ListMetrics() {
+		m.Describe(ch)
+	}
+	ch <- e.ocfCacheLastUpdate
+}
+
+// ListMetrics returns the metrics of the exporter, other than the last updates, which are
+// collected from the caches
+func (e *CasExporter) ListMetrics() []prometheus.Collector {
+	return []prometheus.Collector{
+		e.ocfStatCount,
+		e.ocfStatPercentage,
+		e.ocfStatDuration,
+		e.ocfStatSuccess,
+		e.ocfCacheSuccess,
+		e.ocfCacheStale,
+		e.ocfGoMaxProcs,
+		e.ocfMemoryLimit,
+		e.ocfPaused,
+		e.ocfFaultInjected,
+		e.ocfExtractionDuration,
+		e.ocfCasadmDuration,
+		e.ocfCollectionErrors,
+		e.ocfHookDuration,
+		e.ocfHookSuccess,
+		e.ocfHookFailures,
+		e.ocfCollectionLag,
+		e.ocfCollectionPanics,
+		e.ocfCacheLastErrorInfo,
+		e.ocfCaches,
+		e.ocfDiscoveryIdle,
+		e.ocfInstanceConflict,
+		e.ocfShardInfo,
+		e.ocfInputFileModified,
+		e.ocfCacheInfo,
+		e.ocfCacheAnomaly,
+		e.ocfBaselineDeviation,
+		e.ocfCacheWarmupFillRate,
+		e.ocfCacheWarmupTimeToFull,
+		e.ocfCacheBlockServingRatio,
+		e.ocfCacheDirtyThresholdExceeded,
+		e.ocfCachePressure,
+		e.ocfPassThroughRequests,
+		e.ocfExportedObjectInfo,
+		e.ocfExportedObjectHolderInfo,
+		e.ocfExportedObjectDomainInfo,
+		e.ocfStorageTopologyInfo,
+		e.ocfCoreDeviceMDInfo,
+		e.ocfCoreDeviceMDDegraded,
+		e.ocfCoreDeviceMDMissingDevices,
+		e.ocfCacheDeviceTemperature,
+		e.ocfCacheDevicePCIeSpeed,
+		e.ocfCacheDevicePCIeWidth,
+		e.ocfCacheDevicePCIeDegraded,
+		e.ocfCacheDeviceReplaced,
+		e.ocfModuleParameter,
+		e.ocfModuleParameterInfo,
+		e.ocfKernelInfo,
+		e.ocfModuleLoaded,
+		e.ocfModuleCompatible,
+		e.ocfSystemdUnitState,
+	}
 }
 
 func (e *CasExporter) Collect(ch chan<- prometheus.Metric) {
+	if e.sampleTimestamps {
+		var wait func()
+		ch, wait = e.withTimestamps(ch)
+		defer wait()
+	}
+
 	e.ocfStatCount.Collect(ch)
 	e.ocfStatPercentage.Collect(ch)
+	e.collectLastUpdates(ch)
 	e.ocfStatDuration.Collect(ch)
 	e.ocfStatSuccess.Collect(ch)
+	e.ocfCacheSuccess.Collect(ch)
+	e.ocfCacheStale.Collect(ch)
+	e.ocfGoMaxProcs.Collect(ch)
+	e.ocfMemoryLimit.Collect(ch)
+	e.ocfPaused.Collect(ch)
+	e.ocfFaultInjected.Collect(ch)
+	e.ocfExtractionDuration.Collect(ch)
+	e.ocfCasadmDuration.Collect(ch)
+	e.ocfCollectionErrors.Collect(ch)
+	e.ocfHookDuration.Collect(ch)
+	e.ocfHookSuccess.Collect(ch)
+	e.ocfHookFailures.Collect(ch)
+	e.ocfCollectionLag.Collect(ch)
+	e.ocfCollectionPanics.Collect(ch)
+	e.ocfCacheLastErrorInfo.Collect(ch)
+	e.ocfCaches.Collect(ch)
+	e.ocfDiscoveryIdle.Collect(ch)
+	e.ocfInstanceConflict.Collect(ch)
+	e.ocfShardInfo.Collect(ch)
+	e.ocfInputFileModified.Collect(ch)
+	e.ocfCacheInfo.Collect(ch)
+	e.ocfCacheAnomaly.Collect(ch)
+	e.ocfBaselineDeviation.Collect(ch)
+	e.ocfCacheWarmupFillRate.Collect(ch)
+	e.ocfCacheWarmupTimeToFull.Collect(ch)
+	e.ocfCacheBlockServingRatio.Collect(ch)
+	e.ocfCacheDirtyThresholdExceeded.Collect(ch)
+	e.ocfCachePressure.Collect(ch)
+	e.ocfPassThroughRequests.Collect(ch)
+	e.ocfExportedObjectInfo.Collect(ch)
+	e.ocfExportedObjectHolderInfo.Collect(ch)
+	e.ocfExportedObjectDomainInfo.Collect(ch)
+	e.ocfStorageTopologyInfo.Collect(ch)
+	e.ocfCoreDeviceMDInfo.Collect(ch)
+	e.ocfCoreDeviceMDDegraded.Collect(ch)
+	e.ocfCoreDeviceMDMissingDevices.Collect(ch)
+	e.ocfCacheDeviceTemperature.Collect(ch)
+	e.ocfCacheDevicePCIeSpeed.Collect(ch)
+	e.ocfCacheDevicePCIeWidth.Collect(ch)
+	e.ocfCacheDevicePCIeDegraded.Collect(ch)
+	e.ocfCacheDeviceReplaced.Collect(ch)
+	e.ocfModuleParameter.Collect(ch)
+	e.ocfModuleParameterInfo.Collect(ch)
+	e.ocfKernelInfo.Collect(ch)
+	e.ocfModuleLoaded.Collect(ch)
+	e.ocfModuleCompatible.Collect(ch)
+	e.ocfSystemdUnitState.Collect(ch)
 }
 
 // TODO: Do scraping and collection in two different threads?
 func (e *CasExporter) Start(ctx context.Context, wg *sync.WaitGroup) {
+	if e.newCacheCheckInterval != 0 {
+		go e.watchNewCaches(ctx)
+	}
+
+	if r, ok := e.inputFiles(); ok {
+		go e.watchInputFiles(ctx, r)
+	}
+
+	// scheduled is when the next extraction should start
+	scheduled := time.Now()
 	for {
 		select {
 		case <-ctx.Done():
@@ -79,456 +847,123 @@ func (e *CasExporter) Start(ctx context.Context, wg *sync.WaitGroup) {
 			return
 
 		default:
-			start := time.Now()
-
-			success := 1
-
-			caches, err := casadm.ListCaches(ctx)
-			if err != nil {
-				success = 0
-				slog.Error("list caches",
-					slog.String("err", err.Error()),
-				)
-
-			} else {
-				for _, c := range caches {
-					if c.Device == "-" {
-						continue
-					}
-
-					stats, err := casadm.GetCacheStats(ctx, c.ID)
-					if err != nil {
-						success = 0
-						slog.Error("get cache stats",
-							slog.Int("cache_id", int(c.ID)),
-							slog.String("err", err.Error()),
-						)
-
-						continue
-					}
-
-					//
-					// Count
-					//
-
-					// Usage
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "usage",
-						"subcategory": "occupancy",
-					}).Set(float64(stats.Occupancy4K))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "usage",
-						"subcategory": "free",
-					}).Set(float64(stats.Free4K))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "usage",
-						"subcategory": "clean",
-					}).Set(float64(stats.Clean4K))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "usage",
-						"subcategory": "dirty",
-					}).Set(float64(stats.Dirty4K))
-
-					// Requests
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "rd_hits",
-					}).Set(float64(stats.ReadHitsRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "rd_partial_misses",
-					}).Set(float64(stats.ReadPartialMissesRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "rd_full_misses",
-					}).Set(float64(stats.ReadFullMissesRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "rd_total",
-					}).Set(float64(stats.ReadTotalRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "wr_hits",
-					}).Set(float64(stats.WriteHitsRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "wr_partial_misses",
-					}).Set(float64(stats.WritePartialMissesRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "wr_full_misses",
-					}).Set(float64(stats.WriteFullMissesRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "wr_total",
-					}).Set(float64(stats.WriteTotalRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "rd_pt",
-					}).Set(stats.ReadTotalPercent)
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "wr_pt",
-					}).Set(stats.WriteTotalPercent)
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "serviced",
-					}).Set(float64(stats.ServicedRequestsRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "total",
-					}).Set(float64(stats.TotalRequestsRequests))
-
-					// Blocks
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "core_volume_rd",
-					}).Set(float64(stats.ReadsFromCores4K))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "core_volume_wr",
-					}).Set(float64(stats.WritesFromCores4K))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "core_volume_total",
-					}).Set(float64(stats.TotalToFromCores4K))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "cache_volume_rd",
-					}).Set(float64(stats.ReadsFromCache4K))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "cache_volume_wr",
-					}).Set(float64(stats.WritesToCachce4K))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "cache_volume_total",
-					}).Set(float64(stats.TotalToFromCache4K))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "volume_rd",
-					}).Set(float64(stats.ReadsFromExportedObjects4K))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "volume_wr",
-					}).Set(float64(stats.WritesToExportedObjects4K))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "volume_total",
-					}).Set(float64(stats.TotalToFromExportedObjects4K))
-
-					// Errors
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "cache_volume_rd",
-					}).Set(float64(stats.CacheReadErrorsRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "cache_volume_wr",
-					}).Set(float64(stats.CacheWriteErrorsRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "cache_volume_total",
-					}).Set(float64(stats.CacheTotalErrorsRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "core_volume_rd",
-					}).Set(float64(stats.CoreReadErrorsRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "core_volume_wr",
-					}).Set(float64(stats.CoreWriteErrorsRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "core_volume_total",
-					}).Set(float64(stats.CoreTotalErrorsRequests))
-					e.ocfStatCount.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "total",
-					}).Set(float64(stats.TotalErrorsRequests))
-
-					//
-					//  Percent
-					//
-
-					// Usage
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "usage",
-						"subcategory": "occupancy",
-					}).Set(stats.OccupancyPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "usage",
-						"subcategory": "free",
-					}).Set(stats.FreePercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "usage",
-						"subcategory": "clean",
-					}).Set(stats.CleanPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "usage",
-						"subcategory": "dirty",
-					}).Set(stats.DirtyPercent)
-
-					// Requests
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "rd_hits",
-					}).Set(stats.ReadHitsPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "rd_partial_misses",
-					}).Set(stats.ReadPartialMissesPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "rd_full_misses",
-					}).Set(stats.ReadFullMissesPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "rd_total",
-					}).Set(stats.ReadTotalPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "wr_hits",
-					}).Set(stats.WriteHitsPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "wr_partial_misses",
-					}).Set(stats.WritePartialMissesPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "wr_full_misses",
-					}).Set(stats.WriteFullMissesPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "wr_total",
-					}).Set(stats.WriteTotalPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "rd_pt",
-					}).Set(stats.ReadTotalPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "wr_pt",
-					}).Set(stats.WriteTotalPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "serviced",
-					}).Set(stats.ServicedRequestsPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "requests",
-						"subcategory": "total",
-					}).Set(stats.TotalRequestsPercent)
-
-					// Blocks
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "core_volume_rd",
-					}).Set(stats.ReadsFromCoresPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "core_volume_wr",
-					}).Set(stats.WritesFromCoresPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "core_volume_total",
-					}).Set(stats.TotalToFromCoresPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "cache_volume_rd",
-					}).Set(stats.ReadsFromCachePercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "cache_volume_wr",
-					}).Set(stats.WritesToCachcePercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "cache_volume_total",
-					}).Set(stats.TotalToFromCachePercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "volume_rd",
-					}).Set(stats.ReadsFromExportedObjectsPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "volume_wr",
-					}).Set(stats.WritesToExportedObjectsPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "blocks",
-						"subcategory": "volume_total",
-					}).Set(stats.TotalToFromExportedObjectsPercent)
-
-					// Errors
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "cache_volume_rd",
-					}).Set(stats.CacheReadErrorsPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "cache_volume_wr",
-					}).Set(stats.CacheWriteErrorsPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "cache_volume_total",
-					}).Set(stats.CacheTotalErrorsPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "core_volume_rd",
-					}).Set(stats.CoreReadErrorsPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "core_volume_wr",
-					}).Set(stats.CoreWriteErrorsPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "core_volume_total",
-					}).Set(stats.CoreTotalErrorsPercent)
-					e.ocfStatPercentage.With(prometheus.Labels{
-						"device":      c.Device,
-						"id":          strconv.Itoa(int(c.ID)),
-						"category":    "errors",
-						"subcategory": "total",
-					}).Set(stats.TotalErrorsPercent)
-
-				}
+			interval := e.extractionInterval
+			if e.idle && e.idleInterval != 0 {
+				interval = e.idleInterval
 			}
 
-			duration := time.Since(start)
+			if !e.Paused() {
+				e.ocfCollectionLag.Set(max(time.Since(scheduled), 0).Seconds())
+				interval = e.supervisedExtract(ctx, interval)
+			}
+			interval = e.pauseWait(interval)
+			scheduled = time.Now().Add(interval)
 
-			e.ocfStatDuration.With(prometheus.Labels{}).Set(duration.Seconds())
-			e.ocfStatSuccess.With(prometheus.Labels{}).Set(float64(success))
+			// The idle interval is long, so don't delay the shutdown until it's over
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			case <-e.wake:
+				e.rediscover = true
+				// The extractions woken up aren't late
+				scheduled = time.Now()
+			}
+		}
+	}
+}
 
-			slog.Info("extracted opencas stats",
-				slog.Duration("duration", duration),
-				slog.Bool("success", success == 1),
-			)
+// Extracted returns a channel that's closed once the first extraction is over, so there
+// are metrics to serve
+func (e *CasExporter) Extracted() <-chan struct{} {
+	return e.extracted
+}
+
+// ExtractedAt returns when the last extraction finished, even if it failed, since the
+// metrics don't change until the next one does. It's zero before the first one
+func (e *CasExporter) ExtractedAt() time.Time {
+	ns := e.extractedAt.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, ns)
+}
+
+// Extract extracts the stats once, updating the metrics. It must not be called while the
+// exporter is running
+func (e *CasExporter) Extract(ctx context.Context) {
+	// The hooks are run in lockstep with the extraction, but they aren't part of it
+	success := 1
+	// If the extraction panics, it has failed, even if success hasn't been unset. The panic is
+	// recovered by recoverExtract, after the post hooks have run
+	finished := false
+	e.runHooks(ctx, config.HookPre, false)
+	defer func() { e.runHooks(ctx, config.HookPost, finished && success == 1) }()
+
+	start := time.Now()
+	e.extractionStart.Store(start.UnixNano())
+	defer e.extractionStart.Store(0)
+	defer e.extractedOnce.Do(func() { close(e.extracted) })
+	defer func() { e.extractedAt.Store(time.Now().UnixNano()) }()
+
+	e.collectInstance()
+	e.collectInputFiles()
+
+	caches, err := e.discover(ctx)
+
+	// The stats collector reads the stats of the caches into the snapshot of the extraction
+	e.extraction = nil
+	if err == nil {
+		e.pruneLastErrors(caches)
+
+		e.extraction = &extraction{
+			listed: !e.discoveredAt.Before(start),
+			prev:   e.lastSnapshot(),
+			snap: &snapshot{
+				at:      start,
+				caches:  caches,
+				stats:   map[uint16]*casadm.CacheStats{},
+				updated: map[uint16]time.Time{},
+				success: true,
+			},
+		}
+	}
+
+	e.scheduler.run(ctx, start, e.collectorError)
+	if err != nil {
+		success = 0
+		e.ocfCollectionErrors.WithLabelValues(StageListCaches).Inc()
+		slog.Error("list caches",
+			slog.String("err", err.Error()),
+		)
 
-			time.Sleep(e.extractionInterval)
+	} else {
+		snap := e.extraction.snap
+		if !snap.success {
+			success = 0
 		}
+
+		e.setSnapshot(snap)
 	}
+
+	duration := time.Since(start)
+
+	e.ocfStatDuration.With(prometheus.Labels{}).Set(duration.Seconds())
+	e.ocfExtractionDuration.With(prometheus.Labels{}).Observe(duration.Seconds())
+	e.ocfStatSuccess.With(prometheus.Labels{}).Set(float64(success))
+
+	slog.Info("extracted opencas stats",
+		slog.Duration("duration", duration),
+		slog.Bool("success", success == 1),
+	)
+
+	if e.metricTTL != 0 {
+		if expired := e.series.expire(time.Now().Add(-e.metricTTL)); expired != 0 {
+			slog.Info("expired stale series",
+				slog.Int("count", expired),
+			)
+		}
+	}
+
+	finished = true
 }
@@ -0,0 +1,326 @@
+package casexporter
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricUnits are the units of the metrics, by the suffix of their names (without _total)
+var metricUnits = []struct {
+	suffix string
+	unit   string
+}{
+	{"_gigatransfers_per_second", "gigatransfers_per_second"},
+	{"_blocks_per_second", "blocks_per_second"},
+	{"_seconds", "seconds"},
+	{"_bytes", "bytes"},
+	{"_celsius", "celsius"},
+	{"_lanes", "lanes"},
+	{"_ratio", "ratio"},
+	{"_percentage", "percent"},
+}
+
+// statUnits are the units of the casadm columns, by the text between their brackets
+var statUnits = map[string]string{
+	"%":           "percent",
+	"Requests":    "requests",
+	"4KiB Blocks": "4KiB_blocks",
+}
+
+// MetricsCatalog describes the metrics the exporter can emit
+type MetricsCatalog struct {
+	// ConstLabels are the labels added to all the metrics
+	ConstLabels prometheus.Labels   `json:"const_labels,omitempty"`
+	Metrics     []MetricDescription `json:"metrics"`
+}
+
+// MetricDescription describes a metric the exporter can emit
+type MetricDescription struct {
+	Name string `json:"name"`
+	// Type is the Prometheus type of the metric. It's untyped if it can't be known until the
+	// metric has series
+	Type   string   `json:"type"`
+	Help   string   `json:"help"`
+	Labels []string `json:"labels"`
+	Unit   string   `json:"unit,omitempty"`
+	// Stats are the casadm stats exposed in the metric, by their category and subcategory
+	// labels
+	Stats []StatDescription `json:"stats,omitempty"`
+}
+
+// StatDescription describes a casadm stat exposed in the ocf_count or ocf_percentage metrics
+type StatDescription struct {
+	Category    string `json:"category"`
+	Subcategory string `json:"subcategory"`
+	// Field is the casadm column the stat is read from
+	Field string `json:"casadm_field"`
+	Unit  string `json:"unit,omitempty"`
+	// Headline is whether the stat is exposed in the light profile
+	Headline bool `json:"headline"`
+}
+
+// MetricsLister is a collector that lists its metrics, so the vectors have a type in the
+// catalog even if they have no series
+type MetricsLister interface {
+	prometheus.Collector
+	ListMetrics() []prometheus.Collector
+}
+
+// MetricsCatalog describes the metrics of the exporter and of the collectors registered
+// along with it, generated from their definitions. The metrics of the unchecked collectors
+// (e.g. the exec ones) are only known once they have been collected
+func (e *CasExporter) MetricsCatalog(collectors ...prometheus.Collector) MetricsCatalog {
+	collectors = append([]prometheus.Collector{e}, collectors...)
+
+	// The types of the metrics, by their descriptions
+	types := map[string]string{}
+	for _, c := range collectors {
+		metrics := []prometheus.Collector{c}
+		if l, ok := c.(MetricsLister); ok {
+			metrics = l.ListMetrics()
+		}
+
+		for _, m := range metrics {
+			for _, d := range describe(m) {
+				if typ := vecType(m); typ != "" {
+					types[d.String()] = typ
+				}
+			}
+		}
+	}
+
+	descs := []*prometheus.Desc{}
+	for _, c := range collectors {
+		descs = append(descs, describe(c)...)
+
+		for _, m := range collect(c) {
+			if typ := metricType(m); typ != "" {
+				types[m.Desc().String()] = typ
+			}
+
+			descs = append(descs, m.Desc())
+		}
+	}
+
+	count := describe(e.ocfStatCount)[0]
+	percentage := describe(e.ocfStatPercentage)[0]
+
+	catalog := MetricsCatalog{
+		ConstLabels: e.constLabels,
+		Metrics:     []MetricDescription{},
+	}
+	seen := map[string]bool{}
+	for _, d := range descs {
+		md := describeMetric(d)
+		if seen[md.Name] {
+			continue
+		}
+		seen[md.Name] = true
+
+		md.Type = types[d.String()]
+		if md.Type == "" {
+			md.Type = "untyped"
+		}
+
+		switch d {
+		case count:
+			md.Stats = describeStats(func(st cacheStat) func(s *casadm.CacheStats) float64 { return st.count })
+		case percentage:
+			md.Stats = describeStats(func(st cacheStat) func(s *casadm.CacheStats) float64 { return st.percentage })
+		}
+
+		catalog.Metrics = append(catalog.Metrics, md)
+	}
+
+	sort.Slice(catalog.Metrics, func(i, j int) bool {
+		return catalog.Metrics[i].Name < catalog.Metrics[j].Name
+	})
+
+	return catalog
+}
+
+func describe(c prometheus.Collector) []*prometheus.Desc {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		defer close(ch)
+		c.Describe(ch)
+	}()
+
+	descs := []*prometheus.Desc{}
+	for d := range ch {
+		descs = append(descs, d)
+	}
+
+	return descs
+}
+
+func collect(c prometheus.Collector) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		c.Collect(ch)
+	}()
+
+	metrics := []prometheus.Metric{}
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	return metrics
+}
+
+// vecType returns the type of the metric, if it's a vector or it can be written without
+// having series
+func vecType(c prometheus.Collector) string {
+	switch c := c.(type) {
+	case *prometheus.GaugeVec:
+		return "gauge"
+	case *prometheus.CounterVec:
+		return "counter"
+	case *prometheus.HistogramVec:
+		return "histogram"
+	case *prometheus.SummaryVec:
+		return "summary"
+	case prometheus.Metric:
+		return metricType(c)
+	}
+
+	return ""
+}
+
+// metricType returns the type of the metric, from the value it writes
+func metricType(m prometheus.Metric) string {
+	pb := &dto.Metric{}
+	if err := m.Write(pb); err != nil {
+		return ""
+	}
+
+	switch {
+	case pb.Gauge != nil:
+		return "gauge"
+	case pb.Counter != nil:
+		return "counter"
+	case pb.Histogram != nil:
+		return "histogram"
+	case pb.Summary != nil:
+		return "summary"
+	case pb.Untyped != nil:
+		return "untyped"
+	}
+
+	return ""
+}
+
+// describeMetric returns the name, help, labels and unit of the metric. client_golang doesn't
+// expose the fields of the descriptions, so they're parsed from their string
+func describeMetric(d *prometheus.Desc) MetricDescription {
+	s := strings.TrimPrefix(d.String(), "Desc{fqName: ")
+	name, s := unquotePrefix(s)
+	help, s := unquotePrefix(strings.TrimPrefix(s, ", help: "))
+
+	md := MetricDescription{
+		Name:   name,
+		Help:   help,
+		Labels: []string{},
+		Unit:   metricUnit(name),
+	}
+
+	// The label names can't have the separator, unlike the values of the const labels
+	if i := strings.LastIndex(s, "variableLabels: {"); i != -1 {
+		labels := strings.TrimSuffix(s[i+len("variableLabels: {"):], "}}")
+		for _, l := range strings.Split(labels, ",") {
+			// The constrained labels are wrapped in c()
+			if l = strings.TrimSuffix(strings.TrimPrefix(l, "c("), ")"); l != "" {
+				md.Labels = append(md.Labels, l)
+			}
+		}
+	}
+
+	return md
+}
+
+// unquotePrefix returns the quoted string at the start of s, and the rest of it
+func unquotePrefix(s string) (string, string) {
+	q, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return "", s
+	}
+
+	u, _ := strconv.Unquote(q)
+	return u, s[len(q):]
+}
+
+func metricUnit(name string) string {
+	name = strings.TrimSuffix(name, "_total")
+	for _, u := range metricUnits {
+		if strings.HasSuffix(name, u.suffix) {
+			return u.unit
+		}
+	}
+
+	return ""
+}
+
+// describeStats returns the stats of the table, with the casadm column each one is read
+// from. Like parsed, they're found by setting each field of the stats and looking for
+// changes in the stat value
+func describeStats(value func(st cacheStat) func(s *casadm.CacheStats) float64) []StatDescription {
+	t := reflect.TypeOf(casadm.CacheStats{})
+
+	stats := []StatDescription{}
+	for _, st := range cacheStats {
+		get := value(st)
+		base := get(&casadm.CacheStats{})
+
+		sd := StatDescription{
+			Category:    st.category,
+			Subcategory: st.subcategory,
+			Headline:    st.headline,
+		}
+		for i := 0; i < t.NumField(); i++ {
+			probe := casadm.CacheStats{}
+			if !bump(reflect.ValueOf(&probe).Elem().Field(i)) || get(&probe) == base {
+				continue
+			}
+
+			sd.Field = t.Field(i).Tag.Get("csv")
+			if _, u, ok := strings.Cut(strings.TrimSuffix(sd.Field, "]"), " ["); ok {
+				sd.Unit = statUnits[u]
+			}
+			break
+		}
+
+		stats = append(stats, sd)
+	}
+
+	return stats
+}
+
+// ExtractionFamilies returns the names of the metric families that only change with the
+// extractions (or when pausing or injecting faults), unlike the ones computed at scrape time
+// (see ScrapeTimeFamilies). The metrics of the unchecked collectors aren't known
+func (e *CasExporter) ExtractionFamilies() map[string]bool {
+	families := map[string]bool{}
+	for _, c := range append(e.ListMetrics(), e.collectors...) {
+		for _, d := range describe(c) {
+			families[describeMetric(d).Name] = true
+		}
+	}
+
+	return families
+}
+
+// ScrapeTimeFamilies returns the names of the metric families of the exporter computed at
+// scrape time, which keep changing between the extractions
+func (e *CasExporter) ScrapeTimeFamilies() map[string]bool {
+	return map[string]bool{
+		describeMetric(e.ocfCacheLastUpdate).Name: true,
+	}
+}
@@ -0,0 +1,247 @@
+package casexporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a source of stats, collected on each extraction after the caches have been
+// discovered. Collectors that also implement MetricsCollector have their metrics exposed
+// along with the rest
+type Collector interface {
+	// Name identifies the collector in the logs and in the collection errors stage
+	Name() string
+	Collect(ctx context.Context) error
+}
+
+// MetricsCollector is a collector that exposes its own metrics. They're registered along
+// with the exporter, and can be unchecked (not described)
+type MetricsCollector interface {
+	Collector
+	Metrics() prometheus.Collector
+}
+
+// familiesCollector is a collector that sets metrics registered elsewhere (e.g. the ones of
+// the exporter), which are exposed with its name in collect[]
+type familiesCollector interface {
+	Collector
+	Owned() []prometheus.Collector
+}
+
+type collectorFunc struct {
+	name    string
+	collect func(ctx context.Context) error
+	owned   []prometheus.Collector
+}
+
+// NewCollector returns a collector that runs the function, which sets the owned metrics
+func NewCollector(name string, collect func(ctx context.Context) error, owned ...prometheus.Collector) Collector {
+	return &collectorFunc{name: name, collect: collect, owned: owned}
+}
+
+func (c *collectorFunc) Name() string {
+	return c.name
+}
+
+func (c *collectorFunc) Collect(ctx context.Context) error {
+	return c.collect(ctx)
+}
+
+func (c *collectorFunc) Owned() []prometheus.Collector {
+	return c.owned
+}
+
+// RegisterCollector adds the collector to the extraction, running it every interval, or on
+// every extraction if it's 0. It has to be called before the exporter is started and
+// registered
+func (e *CasExporter) RegisterCollector(c Collector, interval time.Duration) {
+	e.scheduler.add(c, interval)
+
+	owned := []prometheus.Collector{}
+	if fc, ok := c.(familiesCollector); ok {
+		owned = append(owned, fc.Owned()...)
+	}
+	if mc, ok := c.(MetricsCollector); ok {
+		e.collectors = append(e.collectors, mc.Metrics())
+		owned = append(owned, mc.Metrics())
+	}
+
+	e.own(c.Name(), owned...)
+}
+
+// own sets the collector that owns the families of the metrics. It panics if a family
+// already has an owner, like registering it twice would
+func (e *CasExporter) own(collector string, metrics ...prometheus.Collector) {
+	for _, m := range metrics {
+		for _, d := range describe(m) {
+			name := describeMetric(d).Name
+			if c, ok := e.owners[name]; ok {
+				panic(fmt.Sprintf("metric family %s collected by %s and %s", name, ownerName(c), ownerName(collector)))
+			}
+
+			e.owners[name] = collector
+		}
+	}
+}
+
+// checkOwners panics if a family of the exporter isn't owned by a collector or the exporter,
+// since it wouldn't be selectable with collect[]
+func (e *CasExporter) checkOwners() {
+	for _, m := range append(e.ListMetrics(), lastUpdates{e}) {
+		for _, d := range describe(m) {
+			name := describeMetric(d).Name
+			if _, ok := e.owners[name]; !ok {
+				panic(fmt.Sprintf("metric family %s isn't collected by any collector", name))
+			}
+		}
+	}
+}
+
+func ownerName(collector string) string {
+	if collector == ownerExporter {
+		return "the exporter"
+	}
+
+	return "the " + collector + " collector"
+}
+
+// registerBuiltinCollectors registers the collections of the caches stats, the ones that
+// depend on them and the ones that don't
+func (e *CasExporter) registerBuiltinCollectors(configInterval time.Duration) {
+	// The exporter own metrics are always exposed
+	e.own(ownerExporter,
+		e.ocfStatDuration,
+		e.ocfStatSuccess,
+		e.ocfGoMaxProcs,
+		e.ocfMemoryLimit,
+		e.ocfPaused,
+		e.ocfFaultInjected,
+		e.ocfExtractionDuration,
+		e.ocfCasadmDuration,
+		e.ocfCollectionErrors,
+		e.ocfHookDuration,
+		e.ocfHookSuccess,
+		e.ocfHookFailures,
+		e.ocfCollectionLag,
+		e.ocfCollectionPanics,
+		e.ocfCaches,
+		e.ocfDiscoveryIdle,
+		e.ocfInstanceConflict,
+		e.ocfShardInfo,
+	)
+
+	// The stats go first, so the rest of the collectors don't eat their deadline
+	e.RegisterCollector(NewCollector("stats", func(ctx context.Context) error {
+		e.collectStats(ctx)
+		return nil
+	},
+		e.ocfStatCount,
+		e.ocfStatPercentage,
+		lastUpdates{e},
+		e.ocfCacheSuccess,
+		e.ocfCacheStale,
+		e.ocfCacheLastErrorInfo,
+		e.ocfInputFileModified,
+		e.ocfPassThroughRequests,
+	), 0)
+
+	// The discovery metrics are only refreshed when the caches are listed
+	e.RegisterCollector(NewCollector("cache_info", func(ctx context.Context) error {
+		if e.extraction != nil && e.extraction.listed {
+			e.collectCacheInfo(e.caches)
+		}
+		return nil
+	}, e.ocfCacheInfo), 0)
+	e.RegisterCollector(NewCollector("exported_objects", func(ctx context.Context) error {
+		if e.extraction != nil && e.extraction.listed {
+			e.collectExportedObjects(ctx, e.caches)
+			e.collectExportedObjectHolders(e.caches)
+			e.collectStorageTopology(ctx, e.caches)
+		}
+		return nil
+	}, e.ocfExportedObjectInfo, e.ocfExportedObjectHolderInfo, e.ocfStorageTopologyInfo), 0)
+
+	// The analyses of the stats compare the snapshot with the previous one
+	analysis := func(name string, analyze func(prev, snap *snapshot), owned ...prometheus.Collector) {
+		e.RegisterCollector(NewCollector(name, func(ctx context.Context) error {
+			if e.extraction != nil {
+				analyze(e.extraction.prev, e.extraction.snap)
+			}
+			return nil
+		}, owned...), 0)
+	}
+	analysis("anomalies", e.detectAnomalies, e.ocfCacheAnomaly)
+	analysis("warmup", e.collectWarmup, e.ocfCacheWarmupFillRate, e.ocfCacheWarmupTimeToFull)
+	analysis("serving_ratio", e.collectServingRatio, e.ocfCacheBlockServingRatio)
+	analysis("baseline", func(prev, snap *snapshot) { e.collectBaselineDeviation(snap) }, e.ocfBaselineDeviation)
+	analysis("dirty_threshold", func(prev, snap *snapshot) { e.collectDirtyThreshold(snap) }, e.ocfCacheDirtyThresholdExceeded)
+	analysis("pressure", e.collectPressure, e.ocfCachePressure)
+
+	e.RegisterCollector(NewCollector("module", func(ctx context.Context) error {
+		e.collectModule()
+		e.collectKernel()
+
+		return nil
+	},
+		e.ocfModuleParameter,
+		e.ocfModuleParameterInfo,
+		e.ocfKernelInfo,
+		e.ocfModuleLoaded,
+		e.ocfModuleCompatible,
+	), configInterval)
+	e.RegisterCollector(NewCollector("io_classes", func(ctx context.Context) error {
+		e.collectIOClasses(ctx)
+		return nil
+	}), configInterval)
+	e.RegisterCollector(NewCollector("systemd_units", func(ctx context.Context) error {
+		e.collectSystemdUnits(ctx)
+		return nil
+	}, e.ocfSystemdUnitState), configInterval)
+	e.RegisterCollector(NewCollector("cache_devices", func(ctx context.Context) error {
+		e.collectCacheDevices(ctx, e.caches)
+		return nil
+	},
+		e.ocfCacheDeviceTemperature,
+		e.ocfCacheDevicePCIeSpeed,
+		e.ocfCacheDevicePCIeWidth,
+		e.ocfCacheDevicePCIeDegraded,
+		e.ocfCacheDeviceReplaced,
+	), 0)
+	e.RegisterCollector(NewCollector("core_devices", func(ctx context.Context) error {
+		e.collectCoreDevices(e.caches)
+		return nil
+	},
+		e.ocfCoreDeviceMDInfo,
+		e.ocfCoreDeviceMDDegraded,
+		e.ocfCoreDeviceMDMissingDevices,
+	), 0)
+	e.RegisterCollector(NewCollector("domains", func(ctx context.Context) error {
+		return e.collectDomains(ctx, e.caches)
+	}, e.ocfExportedObjectDomainInfo), 0)
+
+	e.checkOwners()
+}
+
+// Collectors returns the metrics of the registered collectors that expose their own, which
+// have to be registered along with the exporter
+func (e *CasExporter) Collectors() []prometheus.Collector {
+	return e.collectors
+}
+
+// ConstLabels returns the labels that have to be added to all the exposed series
+func (e *CasExporter) ConstLabels() prometheus.Labels {
+	return e.constLabels
+}
+
+// collectorError reports the error of a collector
+func (e *CasExporter) collectorError(c Collector, err error) {
+	e.ocfCollectionErrors.WithLabelValues(c.Name()).Inc()
+	slog.Warn("collect",
+		slog.String("collector", c.Name()),
+		slog.String("err", err.Error()),
+	)
+}
@@ -0,0 +1,83 @@
+package casexporter
+
+import (
+	"log/slog"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/sysfs"
+)
+
+type coreArray struct {
+	name string
+	*sysfs.MDArray
+}
+
+// coreArrays returns the md arrays the core device is, or is stacked on (e.g. an LVM volume
+// on top of an array)
+func coreArrays(c *casadm.Cache) ([]coreArray, error) {
+	name, err := sysfs.BlockName(c.Disk)
+	if err != nil {
+		return nil, err
+	}
+
+	arrays := []coreArray{}
+	seen := map[string]bool{}
+	for _, stack := range stacksBelow(name, 0) {
+		for _, d := range stack {
+			if seen[d] {
+				continue
+			}
+			seen[d] = true
+
+			array, ok, err := sysfs.GetMDArray(d)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				arrays = append(arrays, coreArray{name: d, MDArray: array})
+			}
+		}
+	}
+
+	return arrays, nil
+}
+
+// collectCoreDevices exports the state of the md arrays below the core devices, since a
+// degraded array explains the core errors and is better seen along with them
+func (e *CasExporter) collectCoreDevices(caches []*casadm.Cache) {
+	e.ocfCoreDeviceMDInfo.Reset()
+	e.ocfCoreDeviceMDDegraded.Reset()
+	e.ocfCoreDeviceMDMissingDevices.Reset()
+
+	devices := cacheDevices(caches)
+	for _, c := range caches {
+		if c.Type != casadm.TypeCore {
+			continue
+		}
+
+		arrays, err := coreArrays(c)
+		if err != nil {
+			slog.Warn("get core device md arrays",
+				slog.String("device", c.Disk),
+				slog.String("err", err.Error()),
+			)
+
+			continue
+		}
+
+		labels := e.labelSchema.coreLabels(c, devices[c.CacheID])
+		for _, array := range arrays {
+			l := withLabel(labels, "array", array.name)
+
+			info := withLabel(l, "level", array.Level)
+			info["array_state"] = array.State
+			info["sync_action"] = array.SyncAction
+			e.ocfCoreDeviceMDInfo.With(info).Set(1)
+
+			if array.Redundant {
+				e.ocfCoreDeviceMDDegraded.With(l).Set(boolFloat(array.Degraded != 0))
+				e.ocfCoreDeviceMDMissingDevices.With(l).Set(float64(array.Degraded))
+			}
+		}
+	}
+}
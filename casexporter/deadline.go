@@ -0,0 +1,56 @@
+package casexporter
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// StageDeadline are the caches skipped because the extraction deadline was exceeded
+const StageDeadline = "deadline"
+
+// extractionContext returns the context of the stats extraction of all the caches, which
+// is cancelled when the extraction deadline is exceeded, if there's one
+func (e *CasExporter) extractionContext(ctx context.Context, start time.Time) (context.Context, context.CancelFunc) {
+	if e.extractionDeadline == 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithDeadline(ctx, start.Add(e.extractionDeadline))
+}
+
+// stalestFirst sorts the caches so the ones whose stats were read longest ago go first,
+// keeping the running ones before the rest. This way, the caches skipped when the deadline
+// is exceeded are different on every extraction, instead of always the last ones
+func stalestFirst(ids []uint16, running map[uint16]bool, prev *snapshot) {
+	updated := func(id uint16) time.Time {
+		if prev == nil {
+			return time.Time{}
+		}
+
+		return prev.updated[id]
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		if running[ids[i]] != running[ids[j]] {
+			return running[ids[i]]
+		}
+
+		return updated(ids[i]).Before(updated(ids[j]))
+	})
+}
+
+// skipStale marks the caches as stale, since their stats haven't been read because the
+// extraction deadline has been exceeded
+func (e *CasExporter) skipStale(ids []uint16, devices map[uint16]string) {
+	slog.Warn("extraction deadline exceeded, skipping the rest of the caches",
+		slog.Duration("deadline", e.extractionDeadline),
+		slog.Int("skipped", len(ids)),
+	)
+
+	for _, id := range ids {
+		e.ocfCollectionErrors.WithLabelValues(StageDeadline).Inc()
+		e.set(e.ocfCacheStale, e.labelSchema.cacheLabels(id, devices[id]), 1)
+	}
+}
@@ -0,0 +1,190 @@
+package casexporter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/isard-vdi/CAS_Exporter/blkid"
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/nvme"
+	"github.com/isard-vdi/CAS_Exporter/sysfs"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectExportedObjects exports the filesystem and LVM names of the exported objects,
+// since storage pools are identified by them rather than by the device paths
+func (e *CasExporter) collectExportedObjects(ctx context.Context, caches []*casadm.Cache) {
+	e.ocfExportedObjectInfo.Reset()
+
+	devices := cacheDevices(caches)
+	for _, c := range caches {
+		if c.Type != casadm.TypeCore || c.Device == "-" {
+			continue
+		}
+
+		labels := e.labelSchema.coreLabels(c, devices[c.CacheID])
+		for _, l := range []string{"fs_type", "fs_label", "fs_uuid", "vg_name", "lv_name"} {
+			labels[l] = ""
+		}
+
+		info, err := blkid.Probe(ctx, c.Device)
+		if err != nil {
+			slog.Warn("probe exported object",
+				slog.String("device", c.Device),
+				slog.String("err", err.Error()),
+			)
+		} else {
+			labels["fs_type"] = info.Type
+			labels["fs_label"] = info.Label
+			labels["fs_uuid"] = info.UUID
+		}
+
+		lvs := [][2]string{}
+
+		name, err := sysfs.BlockName(c.Device)
+		if err == nil {
+			var holders []string
+			holders, err = sysfs.Holders(name)
+			for _, h := range holders {
+				dm, dmErr := sysfs.DeviceMapper(h)
+				if dmErr != nil {
+					err = dmErr
+					continue
+				}
+
+				if dm == nil {
+					continue
+				}
+
+				if vg, lv, ok := dm.LVM(); ok {
+					lvs = append(lvs, [2]string{vg, lv})
+				}
+			}
+		}
+
+		if err != nil {
+			slog.Warn("resolve exported object logical volumes",
+				slog.String("device", c.Device),
+				slog.String("err", err.Error()),
+			)
+		}
+
+		if len(lvs) == 0 {
+			e.ocfExportedObjectInfo.With(labels).Set(1)
+			continue
+		}
+
+		for _, lv := range lvs {
+			labels["vg_name"] = lv[0]
+			labels["lv_name"] = lv[1]
+
+			e.ocfExportedObjectInfo.With(labels).Set(1)
+		}
+	}
+}
+
+// collectCacheDevices exports the hardware status of the cache devices
+func (e *CasExporter) collectCacheDevices(ctx context.Context, caches []*casadm.Cache) {
+	for _, c := range caches {
+		if c.Type != casadm.TypeCache {
+			continue
+		}
+
+		labels := e.labelSchema.cacheLabels(c.ID, c.Disk)
+
+		disk, err := cacheDisk(c)
+		e.checkCacheDeviceReplaced(c, disk)
+		if err != nil {
+			slog.Warn("resolve cache device disk",
+				slog.String("device", c.Disk),
+				slog.String("err", err.Error()),
+			)
+
+			continue
+		}
+
+		temp, ok, err := diskTemperature(ctx, disk)
+		if errors.Is(err, nvme.ErrNotInstalled) {
+			// It won't be installed by the next extraction, so only warn about it once
+			level := slog.LevelDebug
+			if !e.nvmeNotInstalled {
+				level = slog.LevelWarn
+				e.nvmeNotInstalled = true
+			}
+
+			slog.Log(ctx, level, "get cache device temperature",
+				slog.String("device", c.Disk),
+				slog.String("err", err.Error()),
+			)
+		} else if err != nil {
+			slog.Warn("get cache device temperature",
+				slog.String("device", c.Disk),
+				slog.String("err", err.Error()),
+			)
+		} else if ok {
+			e.set(e.ocfCacheDeviceTemperature, labels, temp)
+		}
+
+		link, ok, err := sysfs.GetPCIeLink(disk)
+		if err != nil {
+			slog.Warn("get cache device pcie link",
+				slog.String("device", c.Disk),
+				slog.String("err", err.Error()),
+			)
+		} else if ok {
+			e.set(e.ocfCacheDevicePCIeSpeed, withLabel(labels, "link", "current"), link.SpeedGTs)
+			e.set(e.ocfCacheDevicePCIeSpeed, withLabel(labels, "link", "max"), link.MaxSpeedGTs)
+			e.set(e.ocfCacheDevicePCIeWidth, withLabel(labels, "link", "current"), float64(link.Width))
+			e.set(e.ocfCacheDevicePCIeWidth, withLabel(labels, "link", "max"), float64(link.MaxWidth))
+
+			degraded := 0
+			if link.Degraded() {
+				degraded = 1
+			}
+			e.set(e.ocfCacheDevicePCIeDegraded, labels, float64(degraded))
+		}
+	}
+}
+
+func cacheDisk(c *casadm.Cache) (string, error) {
+	name, err := sysfs.BlockName(c.Disk)
+	if err != nil {
+		return "", err
+	}
+
+	return sysfs.Disk(name)
+}
+
+// diskTemperature reads the temperature from hwmon, falling back to the NVMe smart log
+// for kernels whose nvme driver doesn't register a hwmon device
+func diskTemperature(ctx context.Context, disk string) (float64, bool, error) {
+	temp, ok, err := sysfs.Temperature(disk)
+	if err != nil || ok {
+		return temp, ok, err
+	}
+
+	if !strings.HasPrefix(disk, "nvme") {
+		return 0, false, nil
+	}
+
+	log, err := nvme.GetSmartLog(ctx, "/dev/"+disk)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return log.TemperatureCelsius(), true, nil
+}
+
+// withLabel returns a copy of the labels with the label added
+func withLabel(labels prometheus.Labels, name, value string) prometheus.Labels {
+	l := make(prometheus.Labels, len(labels)+1)
+	for k, v := range labels {
+		l[k] = v
+	}
+	l[name] = value
+
+	return l
+}
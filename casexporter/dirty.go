@@ -0,0 +1,34 @@
+package casexporter
+
+import "github.com/isard-vdi/CAS_Exporter/casadm"
+
+// DirtyThreshold returns the dirty percentage threshold of the cache, or 0 if it has none
+func (e *CasExporter) DirtyThreshold(cacheID uint16) float64 {
+	if t, ok := e.cacheDirtyThresholds[cacheID]; ok {
+		return t
+	}
+
+	return e.dirtyThresholdDefault
+}
+
+// collectDirtyThreshold exports whether the dirty percentage of each cache exceeds its
+// configured threshold, so alerts don't need to encode the thresholds of every site
+func (e *CasExporter) collectDirtyThreshold(s *snapshot) {
+	for _, c := range s.caches {
+		if c.Type != casadm.TypeCache {
+			continue
+		}
+
+		stats, ok := s.stats[c.ID]
+		if !ok {
+			continue
+		}
+
+		threshold := e.DirtyThreshold(c.ID)
+		if threshold == 0 {
+			continue
+		}
+
+		e.set(e.ocfCacheDirtyThresholdExceeded, e.labelSchema.cacheLabels(c.ID, c.Disk), boolFloat(stats.DirtyPercent > threshold))
+	}
+}
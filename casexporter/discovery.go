@@ -0,0 +1,111 @@
+package casexporter
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/sysfs"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectDiscovery exports the number of caches running, and whether there are none, so the
+// exporter waits for them in the idle discovery mode
+func (e *CasExporter) collectDiscovery(caches []*casadm.Cache) {
+	running := 0
+	for _, c := range caches {
+		if c.Type == casadm.TypeCache {
+			running++
+		}
+	}
+	idle := running == 0
+
+	if idle != e.idle {
+		if idle {
+			slog.Info("no caches running, entering idle discovery mode")
+		} else {
+			slog.Info("caches discovered, leaving idle discovery mode",
+				slog.Int("caches", running),
+			)
+		}
+	}
+
+	e.idle = idle
+
+	e.ocfCaches.With(prometheus.Labels{}).Set(float64(running))
+	e.ocfDiscoveryIdle.With(prometheus.Labels{}).Set(boolFloat(idle))
+}
+
+// discover returns the caches, listing them again if the discovery interval has elapsed
+// since the last discovery. The topology collectors only refresh their metrics when listing
+// them
+func (e *CasExporter) discover(ctx context.Context) ([]*casadm.Cache, error) {
+	if e.caches != nil && !e.rediscover && !e.idle && time.Since(e.discoveredAt) < e.discoveryInterval {
+		return e.caches, nil
+	}
+
+	caches, err := e.cas.ListCaches(ctx)
+	if err != nil {
+		e.caches = nil
+		return nil, err
+	}
+	caches = e.shard.filter(caches)
+
+	e.caches = caches
+	e.discoveredAt = time.Now()
+	e.rediscover = false
+
+	e.collectDiscovery(caches)
+
+	return caches, nil
+}
+
+// watchNewCaches checks the exported objects in sysfs, which is much cheaper than running
+// casadm, and wakes the extraction when new ones appear, so new caches are collected right
+// away instead of waiting for the next discovery
+func (e *CasExporter) watchNewCaches(ctx context.Context) {
+	known := map[string]bool{}
+	first := true
+
+	t := time.NewTicker(e.newCacheCheckInterval)
+	defer t.Stop()
+
+	for {
+		objects, err := sysfs.ExportedObjects()
+		if err != nil {
+			slog.Warn("check new caches",
+				slog.String("err", err.Error()),
+			)
+		}
+
+		found := false
+		for _, o := range objects {
+			if !known[o] {
+				known[o] = true
+				found = true
+			}
+		}
+
+		if found && !first {
+			slog.Info("new exported objects found, collecting them")
+			e.wakeUp()
+		}
+		first = false
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// wakeUp starts the next extraction right away, with a discovery
+func (e *CasExporter) wakeUp() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
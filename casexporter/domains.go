@@ -0,0 +1,115 @@
+package casexporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/libvirt"
+	"github.com/isard-vdi/CAS_Exporter/sysfs"
+
+	"golang.org/x/sys/unix"
+)
+
+// pathDevNumber returns the device number, as major:minor, of the block device of the path:
+// the device itself, or the one of the filesystem of a file
+func pathDevNumber(path string) (string, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return "", fmt.Errorf("stat path: %w", err)
+	}
+
+	dev := st.Dev
+	if st.Mode&unix.S_IFMT == unix.S_IFBLK {
+		dev = st.Rdev
+	}
+
+	return fmt.Sprintf("%d:%d", unix.Major(dev), unix.Minor(dev)), nil
+}
+
+// stackedDevNumbers returns the device numbers of the block device and the ones stacked on
+// top of it (e.g. LVM)
+func stackedDevNumbers(name string) []string {
+	numbers := []string{}
+
+	visited := map[string]bool{name: true}
+	devices := []string{name}
+	for depth := 0; depth <= maxHolderDepth && len(devices) != 0; depth++ {
+		next := []string{}
+		for _, d := range devices {
+			if n, err := sysfs.DevNumber(d); err == nil {
+				numbers = append(numbers, n)
+			}
+
+			holders, _ := sysfs.Holders(d)
+			for _, h := range holders {
+				if !visited[h] {
+					visited[h] = true
+					next = append(next, h)
+				}
+			}
+		}
+
+		devices = next
+	}
+
+	return numbers
+}
+
+// collectDomains exports the running libvirt domains with disks on the exported objects,
+// either directly or through the devices and filesystems on top of them, so the impact of
+// each VM on the caches is visible without correlating the devices by hand
+func (e *CasExporter) collectDomains(ctx context.Context, caches []*casadm.Cache) error {
+	if e.libvirtURI == "" {
+		return nil
+	}
+
+	devices := cacheDevices(caches)
+	cores := map[string]*casadm.Cache{}
+	for _, c := range caches {
+		if c.Type != casadm.TypeCore || c.Device == "-" {
+			continue
+		}
+
+		name, err := sysfs.BlockName(c.Device)
+		if err != nil {
+			slog.Warn("resolve exported object domains",
+				slog.String("device", c.Device),
+				slog.String("err", err.Error()),
+			)
+
+			continue
+		}
+
+		for _, n := range stackedDevNumbers(name) {
+			cores[n] = c
+		}
+	}
+
+	disks, err := libvirt.Disks(ctx, e.libvirtURI)
+	if err != nil {
+		return err
+	}
+
+	e.ocfExportedObjectDomainInfo.Reset()
+	for _, d := range disks {
+		n, err := pathDevNumber(d.Path)
+		if err != nil {
+			// The disks on other storage (e.g. network) aren't on the caches
+			continue
+		}
+
+		c, ok := cores[n]
+		if !ok {
+			continue
+		}
+
+		l := withLabel(e.labelSchema.coreLabels(c, devices[c.CacheID]), "domain", d.Domain)
+		l["disk"] = d.Target
+		l["path"] = d.Path
+		e.ocfExportedObjectDomainInfo.With(l).Set(1)
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package casexporter
+
+import (
+	"bytes"
+	"log/slog"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inflightCommands are the casadm commands that are running
+type inflightCommands struct {
+	mu       sync.Mutex
+	next     uint64
+	commands map[uint64]inflightCommand
+}
+
+type inflightCommand struct {
+	args  []string
+	start time.Time
+}
+
+func newInflightCommands() *inflightCommands {
+	return &inflightCommands{
+		commands: map[uint64]inflightCommand{},
+	}
+}
+
+// start tracks the command until the returned function is called
+func (c *inflightCommands) start(args []string) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := c.next
+	c.next++
+	c.commands[id] = inflightCommand{
+		args:  args,
+		start: time.Now(),
+	}
+
+	return func() {
+		c.mu.Lock()
+		delete(c.commands, id)
+		c.mu.Unlock()
+	}
+}
+
+func (c *inflightCommands) list() []inflightCommand {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	commands := []inflightCommand{}
+	for _, cmd := range c.commands {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].start.Before(commands[j].start)
+	})
+
+	return commands
+}
+
+// Dump logs the internal state of the exporter (last extraction, tracked caches, running
+// casadm commands and goroutine stacks), to debug a stuck extraction in production
+func (e *CasExporter) Dump() {
+	now := time.Now()
+
+	extraction := []any{}
+	if start := e.extractionStart.Load(); start != 0 {
+		extraction = append(extraction,
+			slog.Time("running_since", time.Unix(0, start)),
+			slog.Duration("running_for", now.Sub(time.Unix(0, start))),
+		)
+	}
+
+	inv := e.Inventory()
+	if inv.UpdatedAt != nil {
+		extraction = append(extraction,
+			slog.Time("last_snapshot", *inv.UpdatedAt),
+			slog.Bool("last_success", inv.Success),
+		)
+	}
+
+	caches := []any{}
+	for _, c := range inv.Caches {
+		caches = append(caches, slog.Group(strconv.Itoa(int(c.ID)),
+			slog.String("device", c.Device),
+			slog.String("status", c.Status),
+			slog.Int("cores", len(c.Cores)),
+			slog.Bool("stats", c.Stats != nil),
+		))
+	}
+
+	commands := []any{}
+	for i, cmd := range e.inflight.list() {
+		commands = append(commands, slog.Group(strconv.Itoa(i),
+			slog.String("command", strings.Join(cmd.args, " ")),
+			slog.Duration("running_for", now.Sub(cmd.start)),
+		))
+	}
+
+	var goroutines bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&goroutines, 2); err != nil {
+		slog.Error("dump goroutine stacks",
+			slog.String("err", err.Error()),
+		)
+	}
+
+	slog.Info("diagnostic dump",
+		slog.Group("extraction", extraction...),
+		slog.Bool("paused", e.Paused()),
+		slog.Group("caches", caches...),
+		slog.Group("casadm_commands", commands...),
+		slog.String("goroutines", goroutines.String()),
+	)
+}
@@ -0,0 +1,259 @@
+package casexporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// ExecCollector runs an external command and exposes the metrics in its standard output,
+// in the Prometheus text format or CSV, prefixed, so sites can add their own metrics
+type ExecCollector struct {
+	cfg *config.ExecCollector
+
+	metrics *execMetrics
+}
+
+// execMetrics are the metrics of the last run of the command. It's an unchecked collector,
+// since the metrics aren't known until the command runs
+type execMetrics struct {
+	mu      sync.Mutex
+	metrics []prometheus.Metric
+}
+
+func NewExecCollector(cfg *config.ExecCollector) *ExecCollector {
+	return &ExecCollector{
+		cfg:     cfg,
+		metrics: &execMetrics{},
+	}
+}
+
+func (c *ExecCollector) Name() string {
+	return "exec_" + c.cfg.Name
+}
+
+func (c *ExecCollector) Metrics() prometheus.Collector {
+	return c.metrics
+}
+
+// Collect runs the command and parses its output. If it fails, the metrics of the previous
+// run are dropped, so stale values aren't exposed
+func (c *ExecCollector) Collect(ctx context.Context) error {
+	metrics, err := c.collect(ctx)
+
+	c.metrics.mu.Lock()
+	c.metrics.metrics = metrics
+	c.metrics.mu.Unlock()
+
+	return err
+}
+
+func (c *ExecCollector) collect(ctx context.Context) ([]prometheus.Metric, error) {
+	if c.cfg.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+
+	out, err := exec.CommandContext(ctx, c.cfg.Command[0], c.cfg.Command[1:]...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) != 0 {
+			return nil, fmt.Errorf("run command: %w: '%s'", err, bytes.TrimSpace(exitErr.Stderr))
+		}
+
+		return nil, fmt.Errorf("run command: %w", err)
+	}
+
+	prefix := c.cfg.Prefix
+	if prefix == "" {
+		prefix = config.DefaultExecPrefix
+	}
+
+	if c.cfg.Format == config.ExecFormatCSV {
+		return parseExecCSV(out, prefix, c.cfg.Labels, c.cfg.Values)
+	}
+
+	return parseExecPrometheus(out, prefix)
+}
+
+// parseExecPrometheus parses the metrics in the Prometheus text format
+func parseExecPrometheus(b []byte, prefix string) ([]prometheus.Metric, error) {
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("parse prometheus text output: %w", err)
+	}
+
+	names := make([]string, 0, len(mfs))
+	for name := range mfs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	metrics := []prometheus.Metric{}
+	for _, name := range names {
+		mf := mfs[name]
+		for _, m := range mf.GetMetric() {
+			metric, err := constMetric(prefix+name, mf.GetHelp(), mf.GetType(), m)
+			if err != nil {
+				return nil, fmt.Errorf("convert metric '%s': %w", name, err)
+			}
+
+			if m.TimestampMs != nil {
+				metric = prometheus.NewMetricWithTimestamp(time.UnixMilli(m.GetTimestampMs()), metric)
+			}
+
+			metrics = append(metrics, metric)
+		}
+	}
+
+	return metrics, nil
+}
+
+func constMetric(name, help string, t dto.MetricType, m *dto.Metric) (prometheus.Metric, error) {
+	labels := []string{}
+	values := []string{}
+	for _, l := range m.GetLabel() {
+		labels = append(labels, l.GetName())
+		values = append(values, l.GetValue())
+	}
+
+	desc := prometheus.NewDesc(name, help, labels, nil)
+
+	switch t {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, m.GetCounter().GetValue(), values...)
+
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), values...)
+
+	case dto.MetricType_SUMMARY:
+		quantiles := map[float64]float64{}
+		for _, q := range m.GetSummary().GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+
+		return prometheus.NewConstSummary(desc, m.GetSummary().GetSampleCount(), m.GetSummary().GetSampleSum(), quantiles, values...)
+
+	case dto.MetricType_HISTOGRAM:
+		buckets := map[float64]uint64{}
+		for _, b := range m.GetHistogram().GetBucket() {
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+
+		return prometheus.NewConstHistogram(desc, m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum(), buckets, values...)
+
+	default:
+		return prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), values...)
+	}
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// labelName returns the CSV column as a label name (e.g. "Core Device" is core_device)
+func labelName(column string) string {
+	name := strings.Trim(invalidLabelChars.ReplaceAllString(strings.ToLower(column), "_"), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+
+	return name
+}
+
+// parseExecCSV parses the CSV, exporting each of the values columns of each row as a gauge,
+// labeled with the labels columns. The values that don't parse are skipped
+func parseExecCSV(b []byte, prefix string, labels []string, values map[string]string) ([]prometheus.Metric, error) {
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parse csv output: %w", err)
+	}
+
+	if len(records) == 0 {
+		return []prometheus.Metric{}, nil
+	}
+
+	columns := map[string]int{}
+	for i, h := range records[0] {
+		columns[strings.TrimSpace(h)] = i
+	}
+
+	labelNames := []string{}
+	labelColumns := []int{}
+	for _, l := range labels {
+		i, ok := columns[l]
+		if !ok {
+			return nil, fmt.Errorf("missing labels column '%s'", l)
+		}
+
+		// Different columns may have the same label name (e.g. "Core ID" and "core_id")
+		name := labelName(l)
+		if j := slices.Index(labelNames, name); j != -1 {
+			return nil, fmt.Errorf("labels columns '%s' and '%s' have the same label name '%s'", labels[j], l, name)
+		}
+
+		labelNames = append(labelNames, name)
+		labelColumns = append(labelColumns, i)
+	}
+
+	valueColumns := make([]string, 0, len(values))
+	for col := range values {
+		if _, ok := columns[col]; !ok {
+			return nil, fmt.Errorf("missing values column '%s'", col)
+		}
+
+		valueColumns = append(valueColumns, col)
+	}
+	slices.Sort(valueColumns)
+
+	metrics := []prometheus.Metric{}
+	for _, row := range records[1:] {
+		labelValues := make([]string, len(labelColumns))
+		for i, col := range labelColumns {
+			labelValues[i] = strings.TrimSpace(row[col])
+		}
+
+		for _, col := range valueColumns {
+			v, err := strconv.ParseFloat(strings.TrimSpace(row[columns[col]]), 64)
+			if err != nil {
+				continue
+			}
+
+			desc := prometheus.NewDesc(prefix+values[col], fmt.Sprintf("Column '%s' of the exec collector output", col), labelNames, nil)
+			m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, v, labelValues...)
+			if err != nil {
+				return nil, fmt.Errorf("export values column '%s': %w", col, err)
+			}
+
+			metrics = append(metrics, m)
+		}
+	}
+
+	return metrics, nil
+}
+
+func (m *execMetrics) Describe(ch chan<- *prometheus.Desc) {}
+
+func (m *execMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, metric := range m.metrics {
+		ch <- metric
+	}
+}
@@ -0,0 +1,175 @@
+package casexporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+// Faults injected in the casadm commands, to rehearse the alerting
+const (
+	// FaultTimeout makes the commands hang until their deadline
+	FaultTimeout = "timeout"
+	// FaultParseError makes the commands return output that can't be parsed
+	FaultParseError = "parse_error"
+	// FaultMissingCache makes the commands of the caches fail as if they didn't exist
+	FaultMissingCache = "missing_cache"
+)
+
+var Faults = []string{FaultTimeout, FaultParseError, FaultMissingCache}
+
+// errInjectedFault is the error of the commands failed by the fault injection
+var errInjectedFault = errors.New("injected fault")
+
+// FaultState is the fault injected in the casadm commands
+type FaultState struct {
+	Fault string `json:"fault,omitempty"`
+	// CacheID is the cache whose commands fail. If it's 0, all the commands fail
+	CacheID uint16     `json:"cache_id,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+	// Until is when the fault stops by itself. If it's nil, it's injected until it's
+	// cleared
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// InjectFault makes the casadm commands (of the cache, if cacheID isn't 0) fail with the
+// fault until ClearFault is called or the duration is over, so the alerting can be
+// validated end to end. If the duration is 0, it's injected until cleared. The stats are
+// extracted right away, so the alerts fire sooner
+func (e *CasExporter) InjectFault(fault string, cacheID uint16, d time.Duration) FaultState {
+	e.faultMu.Lock()
+	defer e.faultMu.Unlock()
+
+	now := time.Now()
+	e.fault = FaultState{Fault: fault, CacheID: cacheID, Since: &now}
+	if d > 0 {
+		until := now.Add(d)
+		e.fault.Until = &until
+	}
+
+	slog.Warn("fault injected",
+		slog.String("fault", fault),
+		slog.Int("cache_id", int(cacheID)),
+		slog.Duration("duration", d),
+	)
+
+	e.wakeUp()
+
+	return e.fault
+}
+
+// ClearFault stops the fault injected with InjectFault, extracting the stats right away
+func (e *CasExporter) ClearFault() FaultState {
+	e.faultMu.Lock()
+	defer e.faultMu.Unlock()
+
+	if e.fault.Fault != "" {
+		slog.Info("fault cleared",
+			slog.String("fault", e.fault.Fault),
+			slog.Duration("injected", time.Since(*e.fault.Since)),
+		)
+
+		e.fault = FaultState{}
+		e.wakeUp()
+	}
+
+	return e.fault
+}
+
+// FaultState returns the fault injected in the casadm commands
+func (e *CasExporter) FaultState() FaultState {
+	e.faultMu.Lock()
+	defer e.faultMu.Unlock()
+
+	if e.fault.Until != nil && !time.Now().Before(*e.fault.Until) {
+		slog.Info("fault cleared, injection expired",
+			slog.String("fault", e.fault.Fault),
+			slog.Duration("injected", time.Since(*e.fault.Since)),
+		)
+
+		e.fault = FaultState{}
+	}
+
+	return e.fault
+}
+
+// injectedFault returns the output and the error of the command with the fault injected,
+// or false if the command isn't affected. The cache mode changes are never affected
+func (e *CasExporter) injectedFault(ctx context.Context, args []string) ([]byte, error, bool) {
+	f := e.FaultState()
+	if f.Fault == "" || casadmCommand(args) == casadm.CommandSetCacheMode {
+		return nil, nil, false
+	}
+
+	id, ok := argsCacheID(args)
+	if f.CacheID != 0 && (!ok || id != f.CacheID) {
+		return nil, nil, false
+	}
+
+	switch f.Fault {
+	case FaultTimeout:
+		if _, ok := ctx.Deadline(); ok {
+			<-ctx.Done()
+			return nil, ctx.Err(), true
+		}
+
+		return nil, fmt.Errorf("%w: %w", errInjectedFault, context.DeadlineExceeded), true
+
+	case FaultParseError:
+		return []byte("\"injected fault\n"), nil, true
+
+	case FaultMissingCache:
+		// The listing of the caches isn't of a cache
+		if !ok {
+			return nil, nil, false
+		}
+
+		return []byte(fmt.Sprintf("Cache with ID %d does not exist\n", id)), errInjectedFault, true
+	}
+
+	return nil, nil, false
+}
+
+// argsCacheID returns the cache of the command, if it's of a cache
+func argsCacheID(args []string) (uint16, bool) {
+	a := casadm.ParseArgs(args)
+	return a.CacheID, a.HasCacheID
+}
+
+// faultRunner injects the faults in the casadm commands
+type faultRunner struct {
+	casadm.Runner
+
+	e *CasExporter
+}
+
+func (r *faultRunner) Run(ctx context.Context, args ...string) ([]byte, error) {
+	if b, err, ok := r.e.injectedFault(ctx, args); ok {
+		return b, err
+	}
+
+	return r.Runner.Run(ctx, args...)
+}
+
+func (r *faultRunner) Stream(ctx context.Context, args ...string) (io.ReadCloser, error) {
+	if b, err, ok := r.e.injectedFault(ctx, args); ok {
+		return casadm.Stream(ctx, &injectedRunner{out: b, err: err}, args...)
+	}
+
+	return casadm.Stream(ctx, r.Runner, args...)
+}
+
+// injectedRunner returns the output of a command with a fault injected
+type injectedRunner struct {
+	out []byte
+	err error
+}
+
+func (r *injectedRunner) Run(ctx context.Context, args ...string) ([]byte, error) {
+	return r.out, r.err
+}
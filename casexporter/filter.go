@@ -0,0 +1,182 @@
+package casexporter
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// filterGatherer returns a gatherer that only exposes the metrics for which keep returns true.
+// Families left without metrics are dropped
+func filterGatherer(g prometheus.Gatherer, keep func(mf *dto.MetricFamily, m *dto.Metric) bool) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+
+		result := []*dto.MetricFamily{}
+		for _, mf := range mfs {
+			metrics := []*dto.Metric{}
+			for _, m := range mf.Metric {
+				if keep(mf, m) {
+					metrics = append(metrics, m)
+				}
+			}
+
+			if len(metrics) != 0 {
+				mf.Metric = metrics
+				result = append(result, mf)
+			}
+		}
+
+		return result, err
+	})
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.Label {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+
+	return ""
+}
+
+// ownerExporter is the owner of the exporter own metric families, which can't be selected
+const ownerExporter = ""
+
+// statsCollector is the collector of the stats, whose ocf_count and ocf_percentage series
+// can also be selected by their category
+const statsCollector = "stats"
+
+// CollectorsGatherer returns a gatherer that only exposes the metrics of the collectors:
+// the registered ones and the categories of the stats, which select part of the stats
+// collector. The exporter own metrics (e.g. ocf_success) and the ones that aren't the
+// exporter's are always exposed. If no collectors are passed, all the metrics are exposed
+func (e *CasExporter) CollectorsGatherer(g prometheus.Gatherer, collectors []string) (prometheus.Gatherer, error) {
+	if len(collectors) == 0 {
+		return g, nil
+	}
+
+	if err := e.ValidateCollectors(collectors); err != nil {
+		return nil, err
+	}
+
+	selected := map[string]bool{}
+	for _, c := range collectors {
+		selected[c] = true
+	}
+
+	// The categories select the rest of the stats collector families along with their series
+	stats := selected[statsCollector]
+	for _, c := range statsCategories() {
+		stats = stats || selected[c]
+	}
+
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		// The families of the unchecked collectors are only known once they're collected
+		owners := e.familyOwners()
+
+		return filterGatherer(g, func(mf *dto.MetricFamily, m *dto.Metric) bool {
+			c, ok := owners[mf.GetName()]
+			switch {
+			case !ok || c == ownerExporter:
+				return true
+			case mf.GetName() == "ocf_count" || mf.GetName() == "ocf_percentage":
+				return selected[statsCollector] || selected[labelValue(m, "category")]
+			case c == statsCollector:
+				return stats
+			}
+
+			return selected[c]
+		}).Gather()
+	}), nil
+}
+
+// familyOwners returns the collectors that own each metric family, including the ones of
+// the unchecked collectors exposed at the moment
+func (e *CasExporter) familyOwners() map[string]string {
+	owners := maps.Clone(e.owners)
+	for _, t := range e.scheduler.tasks {
+		mc, ok := t.collector.(MetricsCollector)
+		if !ok || len(describe(mc.Metrics())) != 0 {
+			continue
+		}
+
+		for _, m := range collect(mc.Metrics()) {
+			name := describeMetric(m.Desc()).Name
+			if _, ok := owners[name]; !ok {
+				owners[name] = mc.Name()
+			}
+		}
+	}
+
+	return owners
+}
+
+// ValidateCollectors checks that the collectors exist
+func (e *CasExporter) ValidateCollectors(collectors []string) error {
+	names := e.CollectorNames()
+	for _, c := range collectors {
+		if !slices.Contains(names, c) {
+			return fmt.Errorf("unknown collector '%s', available collectors: %s", c, strings.Join(names, ", "))
+		}
+	}
+
+	return nil
+}
+
+// CollectorNames returns the names of the collectors that can be selected with collect[]
+func (e *CasExporter) CollectorNames() []string {
+	names := statsCategories()
+	for _, t := range e.scheduler.tasks {
+		names = append(names, t.collector.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// statsCategories returns the categories of the stats
+func statsCategories() []string {
+	categories := []string{}
+	for _, st := range cacheStats {
+		if !slices.Contains(categories, st.category) {
+			categories = append(categories, st.category)
+		}
+	}
+
+	return categories
+}
+
+// CacheGatherer returns a gatherer that only exposes the series of the cache. Series that
+// don't belong to any cache (e.g. ocf_success) are always exposed. If cacheID is empty,
+// the series of all the caches are exposed
+func CacheGatherer(g prometheus.Gatherer, cacheID string) (prometheus.Gatherer, error) {
+	if cacheID == "" {
+		return g, nil
+	}
+
+	n, err := strconv.ParseUint(cacheID, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache id '%s'", cacheID)
+	}
+
+	// The labels have the ids without leading zeros, unlike what may be requested (e.g. 02)
+	id := strconv.FormatUint(n, 10)
+
+	return filterGatherer(g, func(mf *dto.MetricFamily, m *dto.Metric) bool {
+		for _, l := range m.Label {
+			if l.GetName() == "id" || l.GetName() == "cache_id" {
+				return l.GetValue() == id
+			}
+		}
+
+		return true
+	}), nil
+}
@@ -0,0 +1,16 @@
+package casexporter
+
+import "github.com/isard-vdi/CAS_Exporter/casadm"
+
+// FixtureExporter returns an exporter that reads the casadm output from the files, named
+// like the ones of the input directory (list-caches.csv, stats-<cache id>.csv...), with the
+// same labels as this one. It isn't started, its stats are extracted with Extract
+func (e *CasExporter) FixtureExporter(files map[string][]byte) *CasExporter {
+	return NewCasExporter(Config{
+		LabelSchema:      e.labelSchema,
+		ConstLabels:      e.constLabels,
+		NativeHistograms: e.nativeHistograms,
+		Shard:            e.shard,
+		StoragePools:     e.storagePools,
+	}, casadm.NewClientWithRunner(&casadm.FixtureRunner{Files: files}, e.cas.Schema()))
+}
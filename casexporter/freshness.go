@@ -0,0 +1,57 @@
+package casexporter
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// updatedAt returns when the stats of the cache were last read from casadm: now if they've
+// been read in this extraction, or when they were last read before if they've failed
+func updatedAt(prev *snapshot, cacheID uint16, ok bool, now time.Time) (time.Time, bool) {
+	if ok {
+		return now, true
+	}
+
+	if prev == nil {
+		return time.Time{}, false
+	}
+
+	at, found := prev.updated[cacheID]
+	return at, found
+}
+
+// lastUpdates are the last updates of the caches, as a collector
+type lastUpdates struct {
+	e *CasExporter
+}
+
+func (l lastUpdates) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.e.ocfCacheLastUpdate
+}
+
+func (l lastUpdates) Collect(ch chan<- prometheus.Metric) {
+	l.e.collectLastUpdates(ch)
+}
+
+// collectLastUpdates exports the time since the stats of each cache were last read,
+// computed at scrape time so it keeps growing while they fail to be read
+func (e *CasExporter) collectLastUpdates(ch chan<- prometheus.Metric) {
+	s := e.lastSnapshot()
+	if s == nil {
+		return
+	}
+
+	now := time.Now()
+	devices := cacheDevices(s.caches)
+	for id, at := range s.updated {
+		labels := e.labelSchema.cacheLabels(id, devices[id])
+
+		values := make([]string, 0, len(labels))
+		for _, name := range e.labelSchema.cacheLabelNames() {
+			values = append(values, labels[name])
+		}
+
+		ch <- prometheus.MustNewConstMetric(e.ocfCacheLastUpdate, prometheus.GaugeValue, now.Sub(at).Seconds(), values...)
+	}
+}
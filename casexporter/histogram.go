@@ -0,0 +1,83 @@
+package casexporter
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// withNativeHistogram enables the native (sparse) buckets of the histogram, with a ~10%
+// resolution. The classic buckets are kept for the scrapers that don't support them
+func withNativeHistogram(opts prometheus.HistogramOpts, native bool) prometheus.HistogramOpts {
+	if native {
+		opts.NativeHistogramBucketFactor = 1.1
+		opts.NativeHistogramMaxBucketNumber = 100
+		opts.NativeHistogramMinResetDuration = time.Hour
+	}
+
+	return opts
+}
+
+// HistogramOpts returns the options with the native histograms enabled, if they're enabled
+// in the exporter
+func (e *CasExporter) HistogramOpts(opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	return withNativeHistogram(opts, e.nativeHistograms)
+}
+
+// timedRunner records the duration of the casadm commands, and tracks the running ones
+type timedRunner struct {
+	casadm.Runner
+
+	duration *prometheus.HistogramVec
+	inflight *inflightCommands
+}
+
+func (r *timedRunner) Run(ctx context.Context, args ...string) ([]byte, error) {
+	start := time.Now()
+	done := r.inflight.start(args)
+	defer func() {
+		done()
+		r.duration.WithLabelValues(casadmCommand(args)).Observe(time.Since(start).Seconds())
+	}()
+
+	return r.Runner.Run(ctx, args...)
+}
+
+func (r *timedRunner) Stream(ctx context.Context, args ...string) (io.ReadCloser, error) {
+	start := time.Now()
+	done := r.inflight.start(args)
+
+	o, err := casadm.Stream(ctx, r.Runner, args...)
+	if err != nil {
+		done()
+		return nil, err
+	}
+
+	return &timedOutput{ReadCloser: o, done: func() {
+		done()
+		r.duration.WithLabelValues(casadmCommand(args)).Observe(time.Since(start).Seconds())
+	}}, nil
+}
+
+// timedOutput is the output of a streamed command, which runs until it's closed
+type timedOutput struct {
+	io.ReadCloser
+
+	done func()
+}
+
+func (o *timedOutput) Close() error {
+	defer o.done()
+
+	return o.ReadCloser.Close()
+}
+
+// casadmCommand returns the name of the command run with the arguments (e.g. list-caches).
+// The IO class stats are apart, as they're much slower than the cache ones
+func casadmCommand(args []string) string {
+	return casadm.ParseArgs(args).Command
+}
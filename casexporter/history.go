@@ -0,0 +1,104 @@
+package casexporter
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// history is a ring buffer with the last extracted snapshots
+type history struct {
+	mu        sync.RWMutex
+	snapshots []*snapshot
+	next      int
+}
+
+// newHistory returns a history of the last size snapshots. If size isn't positive, no
+// snapshots are kept
+func newHistory(size int) *history {
+	return &history{
+		snapshots: make([]*snapshot, max(size, 0)),
+	}
+}
+
+func (h *history) add(s *snapshot) {
+	if len(h.snapshots) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.snapshots[h.next] = s
+	h.next = (h.next + 1) % len(h.snapshots)
+}
+
+// since returns the snapshots extracted after t, from oldest to newest
+func (h *history) since(t time.Time) []*snapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	result := []*snapshot{}
+	for i := range h.snapshots {
+		s := h.snapshots[(h.next+i)%len(h.snapshots)]
+		if s != nil && s.at.After(t) {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+// HistorySchemaVersion is the version of the History JSON schema. It's increased
+// every time there's a breaking change
+const HistorySchemaVersion = 1
+
+type History struct {
+	SchemaVersion int             `json:"schema_version"`
+	Entries       []*HistoryEntry `json:"entries"`
+}
+
+type HistoryEntry struct {
+	Time   time.Time       `json:"time"`
+	Caches []*HistoryCache `json:"caches"`
+}
+
+type HistoryCache struct {
+	ID    uint16          `json:"id"`
+	Stats *InventoryStats `json:"stats"`
+}
+
+// History returns the stats of the snapshots kept in memory extracted after since. If
+// cacheID isn't nil, only the stats of that cache are returned
+func (e *CasExporter) History(cacheID *uint16, since time.Time) *History {
+	h := &History{
+		SchemaVersion: HistorySchemaVersion,
+		Entries:       []*HistoryEntry{},
+	}
+
+	for _, s := range e.history.since(since) {
+		entry := &HistoryEntry{
+			Time:   s.at,
+			Caches: []*HistoryCache{},
+		}
+
+		for id, stats := range s.stats {
+			if cacheID != nil && *cacheID != id {
+				continue
+			}
+
+			entry.Caches = append(entry.Caches, &HistoryCache{
+				ID:    id,
+				Stats: inventoryStats(stats),
+			})
+		}
+
+		sort.Slice(entry.Caches, func(i, j int) bool {
+			return entry.Caches[i].ID < entry.Caches[j].ID
+		})
+
+		h.Entries = append(h.Entries, entry)
+	}
+
+	return h
+}
@@ -0,0 +1,117 @@
+package casexporter
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/sysfs"
+)
+
+// Types of the block devices stacked on top of the exported objects
+const (
+	HolderLVM   = "lvm"
+	HolderDM    = "dm"
+	HolderMD    = "md"
+	HolderLoop  = "loop"
+	HolderOther = "other"
+)
+
+// maxHolderDepth limits how deep the holders are walked
+const maxHolderDepth = 8
+
+// holderType returns the type and the name of the block device
+func holderType(name string) (string, string, error) {
+	dm, err := sysfs.DeviceMapper(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case dm != nil:
+		if vg, lv, ok := dm.LVM(); ok {
+			return HolderLVM, vg + "/" + lv, nil
+		}
+
+		return HolderDM, dm.Name, nil
+
+	case strings.HasPrefix(name, "md"):
+		return HolderMD, name, nil
+
+	case strings.HasPrefix(name, "loop"):
+		return HolderLoop, name, nil
+	}
+
+	return HolderOther, name, nil
+}
+
+// collectExportedObjectHolders exports the block devices stacked on top of the exported
+// objects (device-mapper, LVM, md...), walking the holders recursively, so stacked setups
+// (e.g. LVM-thin on top of CAS) can be traced end to end
+func (e *CasExporter) collectExportedObjectHolders(caches []*casadm.Cache) {
+	e.ocfExportedObjectHolderInfo.Reset()
+
+	devices := cacheDevices(caches)
+	for _, c := range caches {
+		if c.Type != casadm.TypeCore || c.Device == "-" {
+			continue
+		}
+
+		name, err := sysfs.BlockName(c.Device)
+		if err != nil {
+			slog.Warn("resolve exported object holders",
+				slog.String("device", c.Device),
+				slog.String("err", err.Error()),
+			)
+
+			continue
+		}
+
+		labels := e.labelSchema.coreLabels(c, devices[c.CacheID])
+
+		visited := map[string]bool{name: true}
+		parents := []string{name}
+		for depth := 0; depth < maxHolderDepth && len(parents) != 0; depth++ {
+			next := []string{}
+			for _, parent := range parents {
+				holders, err := sysfs.Holders(parent)
+				if err != nil {
+					slog.Warn("resolve exported object holders",
+						slog.String("device", c.Device),
+						slog.String("err", err.Error()),
+					)
+
+					continue
+				}
+
+				for _, h := range holders {
+					if visited[h] {
+						continue
+					}
+					visited[h] = true
+
+					typ, holderName, err := holderType(h)
+					if err != nil {
+						slog.Warn("resolve exported object holder type",
+							slog.String("device", c.Device),
+							slog.String("holder", h),
+							slog.String("err", err.Error()),
+						)
+
+						typ, holderName = HolderOther, h
+					}
+
+					l := withLabel(labels, "holder", h)
+					l["parent"] = parent
+					l["holder_type"] = typ
+					l["holder_name"] = holderName
+					e.ocfExportedObjectHolderInfo.With(l).Set(1)
+
+					next = append(next, h)
+				}
+			}
+
+			parents = next
+		}
+	}
+}
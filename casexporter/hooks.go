@@ -0,0 +1,91 @@
+package casexporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/config"
+)
+
+// Reasons of the hook failures
+const (
+	HookFailureError   = "error"
+	HookFailureTimeout = "timeout"
+)
+
+// runHooks runs the hooks of the stage, one after the other. Their failures are logged and
+// counted, but they don't fail the extraction. The post hooks get whether the extraction
+// has succeeded in CAS_EXPORTER_SUCCESS
+func (e *CasExporter) runHooks(ctx context.Context, stage string, success bool) {
+	for _, h := range e.hooks {
+		if h.Stage != stage {
+			continue
+		}
+
+		env := []string{"CAS_EXPORTER_STAGE=" + stage}
+		if stage == config.HookPost {
+			env = append(env, "CAS_EXPORTER_SUCCESS="+strconv.FormatBool(success))
+		}
+
+		start := time.Now()
+		err := runHook(ctx, h, e.hookTimeout(h), env)
+		e.ocfHookDuration.WithLabelValues(h.Name, stage).Set(time.Since(start).Seconds())
+		e.ocfHookSuccess.WithLabelValues(h.Name, stage).Set(boolFloat(err == nil))
+
+		if err != nil {
+			reason := HookFailureError
+			if errors.Is(err, context.DeadlineExceeded) {
+				reason = HookFailureTimeout
+			}
+			e.ocfHookFailures.WithLabelValues(h.Name, stage, reason).Inc()
+
+			slog.Warn("run hook",
+				slog.String("hook", h.Name),
+				slog.String("stage", stage),
+				slog.String("reason", reason),
+				slog.String("err", err.Error()),
+			)
+		}
+	}
+}
+
+// hookTimeout returns the timeout of the hook, which is the extraction deadline, or the
+// extraction interval if there's none, unless it has its own
+func (e *CasExporter) hookTimeout(h *config.Hook) time.Duration {
+	switch {
+	case h.Timeout != nil:
+		return *h.Timeout
+	case e.extractionDeadline != 0:
+		return e.extractionDeadline
+	}
+
+	return e.extractionInterval
+}
+
+func runHook(ctx context.Context, h *config.Hook, timeout time.Duration, env []string) error {
+	if timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("run command: %w", ctx.Err())
+	}
+	if err != nil {
+		return fmt.Errorf("run command: %w: '%s'", err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
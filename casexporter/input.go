@@ -0,0 +1,71 @@
+package casexporter
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// inputWatchInterval is the interval between checks for changes of the input files
+const inputWatchInterval = time.Second
+
+// inputFiles returns the file runner of the client, if the casadm output is read from files
+func (e *CasExporter) inputFiles() (*casadm.FileRunner, bool) {
+	return e.fileRunner, e.fileRunner != nil
+}
+
+// collectInputFiles exports the modification time of the input files, so it's known how
+// fresh the stats are
+func (e *CasExporter) collectInputFiles() {
+	r, ok := e.inputFiles()
+	if !ok {
+		return
+	}
+
+	times, err := r.ModTimes()
+	if err != nil {
+		slog.Warn("get input files modification time",
+			slog.String("err", err.Error()),
+		)
+
+		return
+	}
+
+	e.ocfInputFileModified.Reset()
+	for name, t := range times {
+		e.ocfInputFileModified.With(prometheus.Labels{
+			"file": name,
+		}).Set(float64(t.Unix()))
+	}
+}
+
+// watchInputFiles wakes the extraction when the input files change, so they're exported as
+// soon as the external job writes them
+func (e *CasExporter) watchInputFiles(ctx context.Context, r *casadm.FileRunner) {
+	var prev map[string]time.Time
+
+	t := time.NewTicker(inputWatchInterval)
+	defer t.Stop()
+
+	for {
+		times, err := r.ModTimes()
+		if err == nil {
+			if prev != nil && !maps.Equal(prev, times) {
+				e.wakeUp()
+			}
+
+			prev = times
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
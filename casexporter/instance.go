@@ -0,0 +1,26 @@
+package casexporter
+
+import (
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectInstance exports whether another exporter instance has been started on the host
+// while this one holds the lock
+func (e *CasExporter) collectInstance() {
+	if e.lock == nil {
+		return
+	}
+
+	conflicted, err := e.lock.Conflicted()
+	if err != nil {
+		slog.Warn("check instance conflict",
+			slog.String("err", err.Error()),
+		)
+
+		return
+	}
+
+	e.ocfInstanceConflict.With(prometheus.Labels{}).Set(boolFloat(conflicted))
+}
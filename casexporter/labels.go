@@ -0,0 +1,113 @@
+package casexporter
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LabelSchema is the set of identity labels of the cache and core series
+type LabelSchema string
+
+const (
+	// LabelSchemaLegacy identifies the series with the "id" (cache ID) and "device" labels,
+	// which is the cache device for the cache series, and the exported object for the core
+	// series and for ocf_count and ocf_percentage
+	LabelSchemaLegacy LabelSchema = "legacy"
+	// LabelSchemaV2 identifies all the series with the "cache_id" and "cache_device" labels,
+	// and the core series also with "core_id" and "exported_object"
+	LabelSchemaV2 LabelSchema = "v2"
+)
+
+func ParseLabelSchema(s string) (LabelSchema, error) {
+	switch LabelSchema(s) {
+	case "", LabelSchemaLegacy:
+		return LabelSchemaLegacy, nil
+	case LabelSchemaV2:
+		return LabelSchemaV2, nil
+	}
+
+	return "", fmt.Errorf("unknown label schema '%s', available schemas: %s, %s", s, LabelSchemaLegacy, LabelSchemaV2)
+}
+
+// cacheLabelNames returns the identity label names of the cache series, followed by extra
+func (s LabelSchema) cacheLabelNames(extra ...string) []string {
+	if s == LabelSchemaV2 {
+		return append([]string{"cache_id", "cache_device"}, extra...)
+	}
+
+	return append([]string{"device", "id"}, extra...)
+}
+
+// coreLabelNames returns the identity label names of the core series, followed by extra
+func (s LabelSchema) coreLabelNames(extra ...string) []string {
+	if s == LabelSchemaV2 {
+		return append([]string{"cache_id", "cache_device", "core_id", "exported_object"}, extra...)
+	}
+
+	return append([]string{"device", "id"}, extra...)
+}
+
+// statsLabelNames returns the identity label names of the stats, followed by extra. The
+// stats are extracted per cache, but the legacy schema labels them by exported object
+func (s LabelSchema) statsLabelNames(extra ...string) []string {
+	if s == LabelSchemaV2 {
+		return s.cacheLabelNames(extra...)
+	}
+
+	return s.coreLabelNames(extra...)
+}
+
+func (s LabelSchema) cacheLabels(cacheID uint16, cacheDevice string) prometheus.Labels {
+	if s == LabelSchemaV2 {
+		return prometheus.Labels{
+			"cache_id":     strconv.Itoa(int(cacheID)),
+			"cache_device": cacheDevice,
+		}
+	}
+
+	return prometheus.Labels{
+		"device": cacheDevice,
+		"id":     strconv.Itoa(int(cacheID)),
+	}
+}
+
+// coreLabels returns the identity labels of the core series. cacheDevice is the device
+// of the cache the core belongs to
+func (s LabelSchema) coreLabels(core *casadm.Cache, cacheDevice string) prometheus.Labels {
+	if s == LabelSchemaV2 {
+		l := s.cacheLabels(core.CacheID, cacheDevice)
+		l["core_id"] = strconv.Itoa(int(core.ID))
+		l["exported_object"] = core.Device
+
+		return l
+	}
+
+	return prometheus.Labels{
+		"device": core.Device,
+		"id":     strconv.Itoa(int(core.CacheID)),
+	}
+}
+
+func (s LabelSchema) statsLabels(core *casadm.Cache, cacheDevice string) prometheus.Labels {
+	if s == LabelSchemaV2 {
+		return s.cacheLabels(core.CacheID, cacheDevice)
+	}
+
+	return s.coreLabels(core, cacheDevice)
+}
+
+// cacheDevices returns the devices of the caches, indexed by cache ID
+func cacheDevices(caches []*casadm.Cache) map[uint16]string {
+	devices := map[uint16]string{}
+	for _, c := range caches {
+		if c.Type == casadm.TypeCache {
+			devices[c.ID] = c.Disk
+		}
+	}
+
+	return devices
+}
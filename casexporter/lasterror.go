@@ -0,0 +1,107 @@
+package casexporter
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+// Classes of the extraction failures, used as the prefix of the last error reason
+const (
+	ErrorClassTimeout          = "timeout"
+	ErrorClassNotInstalled     = "not_installed"
+	ErrorClassCacheNotFound    = "cache_not_found"
+	ErrorClassNotFound         = "not_found"
+	ErrorClassPermissionDenied = "permission_denied"
+	ErrorClassCommandFailed    = "command_failed"
+	ErrorClassPartial          = "partial"
+	ErrorClassOther            = "other"
+)
+
+// maxErrorReasonLength is the maximum length of the last error reason, so the label
+// stays readable on dashboards
+const maxErrorReasonLength = 120
+
+type lastError struct {
+	device string
+	stage  string
+	reason string
+	at     time.Time
+}
+
+// recordCacheError records the most recent failure of the cache and exports it
+func (e *CasExporter) recordCacheError(ctx context.Context, cacheID uint16, device, stage string, err error) {
+	e.lastErrors[cacheID] = lastError{
+		device: device,
+		stage:  stage,
+		reason: errorReason(ctx, err),
+		at:     time.Now(),
+	}
+
+	e.collectLastErrors()
+}
+
+// pruneLastErrors drops the last errors of the caches that aren't running anymore
+func (e *CasExporter) pruneLastErrors(caches []*casadm.Cache) {
+	devices := cacheDevices(caches)
+	for id := range e.lastErrors {
+		if _, ok := devices[id]; !ok {
+			delete(e.lastErrors, id)
+		}
+	}
+
+	e.collectLastErrors()
+}
+
+func (e *CasExporter) collectLastErrors() {
+	e.ocfCacheLastErrorInfo.Reset()
+
+	for id, l := range e.lastErrors {
+		labels := e.labelSchema.cacheLabels(id, l.device)
+		labels["stage"] = l.stage
+		labels["reason"] = l.reason
+
+		e.ocfCacheLastErrorInfo.With(labels).Set(float64(l.at.Unix()))
+	}
+}
+
+// errorReason returns the class of the error followed by its description, in a single
+// line and truncated
+func errorReason(ctx context.Context, err error) string {
+	reason := errorClass(ctx, err) + ": " + strings.Join(strings.Fields(err.Error()), " ")
+	if r := []rune(reason); len(r) > maxErrorReasonLength {
+		reason = string(r[:maxErrorReasonLength-3]) + "..."
+	}
+
+	return reason
+}
+
+func errorClass(ctx context.Context, err error) string {
+	var exitErr *exec.ExitError
+	var partial *casadm.PartialStatsError
+
+	switch {
+	// The timeouts of the exporter checks (e.g. sysfs) aren't classified by casadm
+	case errors.Is(err, casadm.ErrTimeout), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, casadm.ErrCasadmNotInstalled):
+		return ErrorClassNotInstalled
+	case errors.Is(err, casadm.ErrCacheNotFound):
+		return ErrorClassCacheNotFound
+	case errors.Is(err, casadm.ErrPermissionDenied), errors.Is(err, fs.ErrPermission):
+		return ErrorClassPermissionDenied
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrorClassNotFound
+	case errors.As(err, &partial):
+		return ErrorClassPartial
+	case errors.As(err, &exitErr):
+		return ErrorClassCommandFailed
+	default:
+		return ErrorClassOther
+	}
+}
@@ -0,0 +1,89 @@
+package casexporter
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil/promlint"
+)
+
+// lintExceptions are the problems of the metrics that are kept for backwards compatibility
+var lintExceptions = map[string]string{
+	"ocf_count": `non-histogram and non-summary metrics should not have "_count" suffix`,
+}
+
+// Lint gathers the metrics and checks them like promtool, along with the consistency of the
+// identity labels across families, so schema regressions are caught before a release
+func Lint(g prometheus.Gatherer) ([]promlint.Problem, error) {
+	mfs, err := g.Gather()
+	if err != nil {
+		return nil, fmt.Errorf("gather metrics: %w", err)
+	}
+
+	problems, err := promlint.NewWithMetricFamilies(mfs).Lint()
+	if err != nil {
+		return nil, fmt.Errorf("lint metrics: %w", err)
+	}
+
+	problems = slices.DeleteFunc(problems, func(p promlint.Problem) bool {
+		return lintExceptions[p.Metric] == p.Text
+	})
+
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			labels := map[string]bool{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = true
+			}
+
+			for _, text := range identityProblems(labels) {
+				problems = append(problems, promlint.Problem{
+					Metric: mf.GetName(),
+					Text:   text,
+				})
+			}
+
+			break
+		}
+	}
+
+	sort.Slice(problems, func(i, j int) bool {
+		if problems[i].Metric != problems[j].Metric {
+			return problems[i].Metric < problems[j].Metric
+		}
+
+		return problems[i].Text < problems[j].Text
+	})
+
+	return problems, nil
+}
+
+// identityProblems checks that the identity labels of a series are complete for the label
+// schema they belong to
+func identityProblems(labels map[string]bool) []string {
+	problems := []string{}
+
+	// The legacy series are identified by both the id and the device
+	if labels["id"] != labels["device"] {
+		problems = append(problems, "identity labels should include both id and device")
+	}
+
+	// The v2 series are identified by the cache, and the core series also by the core
+	if labels["cache_id"] != labels["cache_device"] {
+		problems = append(problems, "identity labels should include both cache_id and cache_device")
+	}
+	if labels["core_id"] != labels["exported_object"] {
+		problems = append(problems, "identity labels should include both core_id and exported_object")
+	}
+	if labels["core_id"] && !labels["cache_id"] {
+		problems = append(problems, "core identity labels should include cache_id")
+	}
+
+	if (labels["id"] || labels["device"]) && (labels["cache_id"] || labels["core_id"]) {
+		problems = append(problems, "identity labels should not mix the legacy and v2 schemas")
+	}
+
+	return problems
+}
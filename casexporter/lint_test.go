@@ -0,0 +1,61 @@
+package casexporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lintFixtures are the casadm output of a host with two caches, each one with a core
+var lintFixtures = map[string][]byte{
+	"list-caches.csv": []byte(`type,id,disk,status,write policy,device
+cache,1,/dev/nvme0n1,Running,wt,-
+core,1,/dev/sda,Active,-,/dev/cas1-1
+cache,2,/dev/nvme1n1,Running,wb,-
+core,1,/dev/sdb,Active,-,/dev/cas2-1
+`),
+	"stats-1.csv": []byte(`Cache Id,Cache Size [4KiB Blocks],Occupancy [4KiB Blocks],Occupancy [%],Dirty [%],Read hits [Requests],Read hits [%],Write hits [%],Total errors [Requests],Write Policy,Status,Reads from cache [4KiB Blocks],Reads from core(s) [4KiB Blocks],Free [4KiB Blocks],Read total [Requests],Write total [Requests],Pass-Through reads [Requests],Pass-Through writes [Requests]
+1,1000,410,50.0,10.0,1410,90.0,80.0,0,wt,Running,1410,100,500,2000,1000,10,5
+`),
+	"stats-2.csv": []byte(`Cache Id,Cache Size [4KiB Blocks],Occupancy [4KiB Blocks],Occupancy [%],Dirty [%],Read hits [Requests],Read hits [%],Write hits [%],Total errors [Requests],Write Policy,Status,Reads from cache [4KiB Blocks],Reads from core(s) [4KiB Blocks],Free [4KiB Blocks],Read total [Requests],Write total [Requests],Pass-Through reads [Requests],Pass-Through writes [Requests]
+2,2000,1000,50.0,25.0,700,70.0,60.0,3,wb,Running,700,300,1000,1000,500,0,0
+`),
+	"io-classes-1.csv": []byte(`IO class ID,IO class name,Eviction priority,Allocation
+0,unclassified,22,1.00
+1,direct,1,0.00
+`),
+	"io-classes-2.csv": []byte(`IO class ID,IO class name,Eviction priority,Allocation
+0,unclassified,22,1.00
+`),
+	"io-class-stats-1.csv": []byte(`IO class ID,IO class name,Pass-Through reads [Requests],Pass-Through writes [Requests]
+0,unclassified,0,0
+1,direct,10,5
+`),
+}
+
+func TestLintFixtures(t *testing.T) {
+	for _, schema := range []LabelSchema{LabelSchemaLegacy, LabelSchemaV2} {
+		t.Run(string(schema), func(t *testing.T) {
+			cas := casadm.NewClientWithRunner(&casadm.FixtureRunner{Files: lintFixtures}, casadm.SchemaOpenCAS)
+			e := NewCasExporter(Config{ExtractionInterval: time.Minute, LabelSchema: schema}, cas)
+			e.Extract(context.Background())
+
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(e)
+			reg.MustRegister(e.Collectors()...)
+
+			problems, err := Lint(reg)
+			if err != nil {
+				t.Fatalf("lint: %v", err)
+			}
+
+			for _, p := range problems {
+				t.Errorf("%s: %s", p.Metric, p.Text)
+			}
+		})
+	}
+}
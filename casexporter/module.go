@@ -0,0 +1,189 @@
+package casexporter
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/isard-vdi/CAS_Exporter/sysfs"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const casModule = "cas_cache"
+
+// collectModule exports the cas_cache module parameters, so tuning differences between
+// hosts are visible
+func (e *CasExporter) collectModule() {
+	e.ocfModuleParameter.Reset()
+	e.ocfModuleParameterInfo.Reset()
+
+	params, err := sysfs.ModuleParameters(casModule)
+	// The parameters are missing while the module isn't loaded, which is reported by the
+	// kernel collection, so it's only logged when it starts
+	missing := errors.Is(err, fs.ErrNotExist)
+	if missing && e.moduleParametersMissing {
+		slog.Debug("get module parameters",
+			slog.String("module", casModule),
+			slog.String("err", err.Error()),
+		)
+
+		return
+	}
+	e.moduleParametersMissing = missing
+
+	if err != nil {
+		slog.Warn("get module parameters",
+			slog.String("module", casModule),
+			slog.String("err", err.Error()),
+		)
+
+		return
+	}
+
+	for name, v := range params {
+		labels := prometheus.Labels{
+			"module":    casModule,
+			"parameter": name,
+		}
+
+		switch v {
+		// Boolean parameters
+		case "Y":
+			e.ocfModuleParameter.With(labels).Set(1)
+		case "N":
+			e.ocfModuleParameter.With(labels).Set(0)
+
+		default:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				e.ocfModuleParameter.With(labels).Set(f)
+				continue
+			}
+
+			labels["value"] = v
+			e.ocfModuleParameterInfo.With(labels).Set(1)
+		}
+	}
+}
+
+// incompatibility is a known broken combination of cas_cache and kernel versions
+type incompatibility struct {
+	// module is the cas_cache version prefix
+	module string
+	// kernel is the minimum kernel version (major, minor) the module is broken with
+	kernel [2]int
+	reason string
+}
+
+var incompatibilities = []incompatibility{
+	{module: "20.", kernel: [2]int{6, 0}, reason: "Open CAS 20.x predates the 6.x block layer API changes"},
+}
+
+// collectKernel exports the running kernel and cas_cache versions, and whether they are
+// compatible, so upgrades that leave the module unloaded are detected
+func (e *CasExporter) collectKernel() {
+	e.ocfKernelInfo.Reset()
+
+	kernel, err := sysfs.KernelRelease()
+	if err != nil {
+		slog.Warn("get kernel release",
+			slog.String("err", err.Error()),
+		)
+	}
+
+	mod, err := sysfs.GetModule(casModule)
+	if err != nil {
+		slog.Warn("get module",
+			slog.String("module", casModule),
+			slog.String("err", err.Error()),
+		)
+
+		return
+	}
+
+	e.ocfKernelInfo.With(prometheus.Labels{
+		"kernel_version": kernel,
+		"module_version": mod.Version,
+		"module_taint":   mod.Taint,
+	}).Set(1)
+
+	loaded := 0
+	if mod.Loaded {
+		loaded = 1
+	}
+	e.ocfModuleLoaded.With(prometheus.Labels{}).Set(float64(loaded))
+
+	// The incompatibility is only logged when it changes, instead of on every collection
+	compatible := 1
+	reason := incompatible(kernel, mod)
+	switch {
+	case reason != "" && reason != e.moduleIncompatibility:
+		slog.Warn("kernel and module incompatibility",
+			slog.String("kernel_version", kernel),
+			slog.String("module_version", mod.Version),
+			slog.String("reason", reason),
+		)
+	case reason == "" && e.moduleIncompatibility != "":
+		slog.Info("kernel and module compatible",
+			slog.String("kernel_version", kernel),
+			slog.String("module_version", mod.Version),
+		)
+	}
+	if reason != "" {
+		compatible = 0
+	}
+	e.moduleIncompatibility = reason
+	e.ocfModuleCompatible.With(prometheus.Labels{}).Set(float64(compatible))
+}
+
+// incompatible returns why the module can't be used with the kernel, or an empty
+// string if it can
+func incompatible(kernel string, mod *sysfs.Module) string {
+	if !mod.Loaded {
+		return "module not loaded"
+	}
+
+	// The module has been loaded with a mismatching version magic
+	if strings.Contains(mod.Taint, "F") {
+		return "module force loaded"
+	}
+
+	major, minor, ok := kernelVersion(kernel)
+	if !ok {
+		return ""
+	}
+
+	for _, i := range incompatibilities {
+		if !strings.HasPrefix(mod.Version, i.module) {
+			continue
+		}
+
+		if major > i.kernel[0] || (major == i.kernel[0] && minor >= i.kernel[1]) {
+			return i.reason
+		}
+	}
+
+	return ""
+}
+
+// kernelVersion parses the major and minor versions of a kernel release (e.g. 5.14.0-362.el9.x86_64)
+func kernelVersion(release string) (major, minor int, ok bool) {
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+
+	minor, err = strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return major, minor, true
+}
@@ -0,0 +1,160 @@
+package casexporter
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+// cacheStatsResult are the stats extracted from a cache. Partial stats (some fields
+// couldn't be parsed) are exported, but they aren't used for the derived metrics
+type cacheStatsResult struct {
+	stats    *casadm.CacheStats
+	unparsed []string
+	// unchanged is whether the output is the same as in the previous extraction, so the
+	// metrics don't need to be set again
+	unchanged bool
+}
+
+// statsOutput is the last successfully parsed stats output of a cache
+type statsOutput struct {
+	sum   outputSum
+	stats *casadm.CacheStats
+}
+
+// outputSum identifies the stats output. The discovery time is included so the stats of
+// the new cores are set even if the cache output doesn't change
+type outputSum struct {
+	hash         uint64
+	discoveredAt time.Time
+}
+
+func fnv64a(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+
+	return h.Sum64()
+}
+
+// runningCaches returns whether each cache is running, indexed by cache ID
+func runningCaches(caches []*casadm.Cache) map[uint16]bool {
+	running := map[uint16]bool{}
+	for _, c := range caches {
+		if c.Type == casadm.TypeCache {
+			running[c.ID] = c.Status == casadm.StatusRunning
+		}
+	}
+
+	return running
+}
+
+// statsCacheIDs returns the IDs of the caches with exported objects, the running ones
+// first, so the caches that aren't running don't delay the rest
+func statsCacheIDs(caches []*casadm.Cache) []uint16 {
+	running := runningCaches(caches)
+
+	ids := []uint16{}
+	seen := map[uint16]bool{}
+	for _, c := range caches {
+		if c.Device == "-" || seen[c.CacheID] {
+			continue
+		}
+
+		seen[c.CacheID] = true
+		ids = append(ids, c.CacheID)
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		return running[ids[i]] && !running[ids[j]]
+	})
+
+	return ids
+}
+
+// extractCacheStats gets the stats of the cache, bounded by the cache stats timeout. It
+// returns whether the extraction has succeeded, and the stats that have been extracted,
+// if any. If the output hasn't changed since the previous extraction, the previous stats
+// are returned without parsing them again
+func (e *CasExporter) extractCacheStats(ctx context.Context, cacheID uint16, device string) (*cacheStatsResult, bool) {
+	if e.cacheStatsTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.cacheStatsTimeout)
+		defer cancel()
+	}
+
+	b, err := e.cas.CacheStatsOutput(ctx, cacheID)
+	var stats *casadm.CacheStats
+	if err == nil {
+		sum := outputSum{hash: fnv64a(b), discoveredAt: e.discoveredAt}
+		if prev, ok := e.statsOutputs[cacheID]; ok && prev.sum == sum {
+			return &cacheStatsResult{stats: prev.stats, unchanged: true}, true
+		}
+
+		stats, err = e.cas.ParseCacheStats(b)
+		if err == nil {
+			e.statsOutputs[cacheID] = statsOutput{sum: sum, stats: stats}
+			return &cacheStatsResult{stats: stats}, true
+		}
+	}
+
+	delete(e.statsOutputs, cacheID)
+	e.ocfCollectionErrors.WithLabelValues(StageCacheStats).Inc()
+	e.recordCacheError(ctx, cacheID, device, StageCacheStats, err)
+
+	var partial *casadm.PartialStatsError
+	if errors.As(err, &partial) {
+		slog.Warn("get cache stats",
+			slog.Int("cache_id", int(cacheID)),
+			slog.String("err", err.Error()),
+		)
+
+		return &cacheStatsResult{stats: stats, unparsed: partial.Fields}, false
+	}
+
+	// The cache may have been stopped
+	e.rediscover = true
+	slog.Error("get cache stats",
+		slog.Int("cache_id", int(cacheID)),
+		slog.String("err", err.Error()),
+	)
+
+	return nil, false
+}
+
+// parsed returns whether the stat doesn't depend on any of the fields that couldn't be
+// parsed. It's checked by setting those fields and looking for changes in the stat values
+func (r *cacheStatsResult) parsed(st cacheStat) bool {
+	if len(r.unparsed) == 0 {
+		return true
+	}
+
+	probe := *r.stats
+	v := reflect.ValueOf(&probe).Elem()
+	for _, name := range r.unparsed {
+		bump(v.FieldByName(name))
+	}
+
+	return st.count(r.stats) == st.count(&probe) && st.percentage(r.stats) == st.percentage(&probe)
+}
+
+// bump increases the numeric field, returning false if it isn't numeric
+func bump(f reflect.Value) bool {
+	switch {
+	case f.CanInt():
+		f.SetInt(f.Int() + 1)
+	case f.CanUint():
+		f.SetUint(f.Uint() + 1)
+	case f.CanFloat():
+		f.SetFloat(f.Float() + 1)
+	default:
+		return false
+	}
+
+	return true
+}
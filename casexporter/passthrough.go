@@ -0,0 +1,160 @@
+package casexporter
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+const (
+	// PassThroughCacheMode are the requests passed through because the cache is in
+	// pass-through mode
+	PassThroughCacheMode = "cache_mode"
+	// PassThroughIOClass are the requests of the IO classes pinned to pass-through
+	PassThroughIOClass = "io_class"
+	// PassThroughOther are the rest of the requests (e.g. sequential cutoff or cache full),
+	// which casadm doesn't tell apart
+	PassThroughOther = "other"
+)
+
+// writePolicyPassThrough is the write policy of the caches in pass-through mode
+const writePolicyPassThrough = "pt"
+
+// passThroughRequests are the reads and writes passed through
+type passThroughRequests struct {
+	reads  int
+	writes int
+}
+
+// listPinnedIOClasses stores the IO classes of the cache that are pinned to pass-through
+func (e *CasExporter) listPinnedIOClasses(ctx context.Context, cacheID uint16) error {
+	classes, err := e.cas.ListIOClasses(ctx, cacheID)
+	if err != nil {
+		delete(e.pinnedIOClasses, cacheID)
+		return err
+	}
+
+	pinned := map[uint16]bool{}
+	for _, class := range classes {
+		if class.PassThrough() {
+			pinned[class.ID] = true
+		}
+	}
+
+	e.pinnedIOClasses[cacheID] = pinned
+
+	return nil
+}
+
+// collectIOClasses refreshes the IO classes configuration of the caches. It's part of the
+// configuration tier, since it changes rarely
+func (e *CasExporter) collectIOClasses(ctx context.Context) {
+	for _, c := range e.caches {
+		if c.Type != casadm.TypeCache || c.WritePolicy == writePolicyPassThrough {
+			continue
+		}
+
+		if err := e.listPinnedIOClasses(ctx, c.ID); err != nil {
+			e.recordCacheError(ctx, c.ID, c.Disk, StageIOClasses, err)
+			slog.Warn("list io classes",
+				slog.Int("cache_id", int(c.ID)),
+				slog.String("err", err.Error()),
+			)
+		}
+	}
+}
+
+// ioClassPassThrough returns the requests passed through by the IO classes of the cache
+// that are pinned to pass-through
+func (e *CasExporter) ioClassPassThrough(ctx context.Context, cacheID uint16) (passThroughRequests, error) {
+	pt := passThroughRequests{}
+
+	// Caches discovered after the last configuration collection
+	if _, ok := e.pinnedIOClasses[cacheID]; !ok {
+		if err := e.listPinnedIOClasses(ctx, cacheID); err != nil {
+			return pt, err
+		}
+	}
+
+	pinned := e.pinnedIOClasses[cacheID]
+	if len(pinned) == 0 {
+		return pt, nil
+	}
+
+	stats, err := e.cas.GetIOClassStats(ctx, cacheID)
+	if err != nil {
+		return pt, err
+	}
+
+	for _, s := range stats {
+		if pinned[s.ID] {
+			pt.reads += s.PassThroughReadsRequests
+			pt.writes += s.PassThroughWritesRequests
+		}
+	}
+
+	return pt, nil
+}
+
+// collectPassThrough exports the pass-through requests of each cache broken down by cause,
+// as far as casadm allows telling them apart
+func (e *CasExporter) collectPassThrough(ctx context.Context, s *snapshot) {
+	for _, c := range s.caches {
+		if c.Type != casadm.TypeCache {
+			continue
+		}
+
+		// The extraction deadline has been exceeded, the rest are kept as they are
+		if ctx.Err() != nil {
+			return
+		}
+
+		stats, ok := s.stats[c.ID]
+		if !ok {
+			continue
+		}
+
+		total := passThroughRequests{
+			reads:  stats.PassThroughReadsRequests,
+			writes: stats.PassThroughWritesRequests,
+		}
+		causes := map[string]passThroughRequests{
+			PassThroughCacheMode: {},
+			PassThroughIOClass:   {},
+			PassThroughOther:     {},
+		}
+
+		if c.WritePolicy == writePolicyPassThrough {
+			causes[PassThroughCacheMode] = total
+
+		} else {
+			ioClass, err := e.ioClassPassThrough(ctx, c.ID)
+			if err != nil {
+				e.recordCacheError(ctx, c.ID, c.Disk, StageIOClasses, err)
+				slog.Warn("get io class pass-through requests",
+					slog.Int("cache_id", int(c.ID)),
+					slog.String("err", err.Error()),
+				)
+
+				// Without the IO classes, all the requests would be exported as other
+				// causes, so the previous values are kept
+				continue
+			}
+
+			causes[PassThroughIOClass] = ioClass
+			causes[PassThroughOther] = passThroughRequests{
+				reads:  max(total.reads-ioClass.reads, 0),
+				writes: max(total.writes-ioClass.writes, 0),
+			}
+		}
+
+		labels := e.labelSchema.cacheLabels(c.ID, c.Disk)
+
+		for cause, pt := range causes {
+			l := withLabel(labels, "cause", cause)
+			e.set(e.ocfPassThroughRequests, withLabel(l, "operation", "read"), float64(pt.reads))
+			e.set(e.ocfPassThroughRequests, withLabel(l, "operation", "write"), float64(pt.writes))
+		}
+	}
+}
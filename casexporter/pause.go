@@ -0,0 +1,90 @@
+package casexporter
+
+import (
+	"log/slog"
+	"time"
+)
+
+// PauseState is the state of the maintenance pause
+type PauseState struct {
+	Paused bool       `json:"paused"`
+	Since  *time.Time `json:"since,omitempty"`
+	// Until is when the extraction resumes by itself. If it's nil, it's paused until
+	// it's resumed
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// Pause stops running casadm until Resume is called or the duration is over, so the
+// caches can be stopped and started during maintenance without the exporter getting in
+// the way. If the duration is 0, it's paused until resumed. The metrics of the last
+// extraction are kept
+func (e *CasExporter) Pause(d time.Duration) PauseState {
+	e.pauseMu.Lock()
+	defer e.pauseMu.Unlock()
+
+	now := time.Now()
+	if !e.pause.Paused {
+		e.pause.Since = &now
+	}
+	e.pause.Paused = true
+	e.pause.Until = nil
+	if d > 0 {
+		until := now.Add(d)
+		e.pause.Until = &until
+	}
+
+	slog.Info("extraction paused",
+		slog.Duration("duration", d),
+	)
+
+	return e.pause
+}
+
+// Resume resumes the extraction paused with Pause, extracting the stats right away
+func (e *CasExporter) Resume() PauseState {
+	e.pauseMu.Lock()
+	defer e.pauseMu.Unlock()
+
+	if e.pause.Paused {
+		slog.Info("extraction resumed",
+			slog.Duration("paused", time.Since(*e.pause.Since)),
+		)
+
+		e.pause = PauseState{}
+		e.wakeUp()
+	}
+
+	return e.pause
+}
+
+// PauseState returns the state of the maintenance pause
+func (e *CasExporter) PauseState() PauseState {
+	e.pauseMu.Lock()
+	defer e.pauseMu.Unlock()
+
+	if e.pause.Until != nil && !time.Now().Before(*e.pause.Until) {
+		slog.Info("extraction resumed, pause expired",
+			slog.Duration("paused", time.Since(*e.pause.Since)),
+		)
+
+		e.pause = PauseState{}
+	}
+
+	return e.pause
+}
+
+// Paused returns whether the extraction is paused
+func (e *CasExporter) Paused() bool {
+	return e.PauseState().Paused
+}
+
+// pauseWait returns the time to wait until the next extraction, which is shortened if a
+// pause expires before
+func (e *CasExporter) pauseWait(interval time.Duration) time.Duration {
+	p := e.PauseState()
+	if p.Until == nil {
+		return interval
+	}
+
+	return min(interval, time.Until(*p.Until))
+}
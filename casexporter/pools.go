@@ -0,0 +1,123 @@
+package casexporter
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// seriesPools are the storage pools of the series, after resolving the devices of the
+// last extraction
+type seriesPools struct {
+	// devices are indexed by cache device and exported object
+	devices map[string]*config.StoragePool
+	// caches are indexed by cache ID
+	caches map[string]*config.StoragePool
+}
+
+// seriesPools resolves the storage pools of the caches and cores of the last extraction.
+// The cores get the pool of their core device, or of their cache device if it isn't mapped
+func (e *CasExporter) seriesPools() *seriesPools {
+	pools := &seriesPools{
+		devices: map[string]*config.StoragePool{},
+		caches:  map[string]*config.StoragePool{},
+	}
+
+	s := e.lastSnapshot()
+	if s == nil {
+		return pools
+	}
+
+	devices := cacheDevices(s.caches)
+	for _, c := range s.caches {
+		switch c.Type {
+		case casadm.TypeCache:
+			if p := e.storagePools[c.Disk]; p != nil {
+				pools.devices[c.Disk] = p
+				pools.caches[strconv.Itoa(int(c.ID))] = p
+			}
+
+		case casadm.TypeCore:
+			p := e.storagePools[c.Disk]
+			if p == nil {
+				p = e.storagePools[devices[c.CacheID]]
+			}
+			if p != nil {
+				pools.devices[c.Device] = p
+			}
+		}
+	}
+
+	return pools
+}
+
+// lookup returns the storage pool of the series, by its most specific identity label
+func (p *seriesPools) lookup(m *dto.Metric) *config.StoragePool {
+	for _, name := range []string{"exported_object", "device", "cache_device"} {
+		if v := labelValue(m, name); v != "" {
+			if pool, ok := p.devices[v]; ok {
+				return pool
+			}
+		}
+	}
+
+	for _, name := range []string{"id", "cache_id"} {
+		if v := labelValue(m, name); v != "" {
+			if pool, ok := p.caches[v]; ok {
+				return pool
+			}
+		}
+	}
+
+	return nil
+}
+
+// PoolGatherer returns a gatherer that adds the pool and tenant labels to the series of the
+// devices of the storage pools, so the consumption of each tenant can be reported. If there
+// are no storage pools, the gatherer is returned as is
+func (e *CasExporter) PoolGatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	if len(e.storagePools) == 0 {
+		return g
+	}
+
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+
+		pools := e.seriesPools()
+		for _, mf := range mfs {
+			for _, m := range mf.Metric {
+				pool := pools.lookup(m)
+				if pool == nil {
+					continue
+				}
+
+				addLabel(m, "pool", pool.Pool)
+				addLabel(m, "tenant", pool.Tenant)
+			}
+		}
+
+		return mfs, err
+	})
+}
+
+// addLabel adds the label to the metric, keeping them sorted. The empty values and the
+// labels the metric already has are ignored
+func addLabel(m *dto.Metric, name, value string) {
+	if value == "" || labelValue(m, name) != "" {
+		return
+	}
+
+	m.Label = append(m.Label, &dto.LabelPair{
+		Name:  proto.String(name),
+		Value: proto.String(value),
+	})
+	sort.Slice(m.Label, func(i, j int) bool {
+		return m.Label[i].GetName() < m.Label[j].GetName()
+	})
+}
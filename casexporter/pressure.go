@@ -0,0 +1,119 @@
+package casexporter
+
+import (
+	"math"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+// Signals of the cache pressure
+const (
+	PressureOccupancy = "occupancy"
+	PressureDirty     = "dirty"
+	PressureErrors    = "errors"
+)
+
+// errorRateFullPressure is the ratio of failed requests that is full error pressure
+const errorRateFullPressure = 0.01
+
+// cachePressure are the smoothed pressure signals of a cache
+type cachePressure struct {
+	at      time.Time
+	signals map[string]float64
+}
+
+// clamp limits the value to the 0-1 range
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// rawPressure returns the pressure signals of the cache since the previous extraction,
+// before smoothing them
+func (e *CasExporter) rawPressure(id uint16, stats, prevStats *casadm.CacheStats) map[string]float64 {
+	signals := map[string]float64{
+		PressureOccupancy: clamp(stats.OccupancyPercent / 100),
+	}
+
+	// The dirty backlog is relative to the threshold of the cache, if it has one
+	if t := e.DirtyThreshold(id); t != 0 {
+		signals[PressureDirty] = clamp(stats.DirtyPercent / t)
+	} else {
+		signals[PressureDirty] = clamp(stats.DirtyPercent / 100)
+	}
+
+	// The errors can only be rated with the previous extraction. The counters are reset when
+	// the cache is restarted
+	if prevStats != nil {
+		errors := stats.CacheTotalErrorsRequests + stats.CoreTotalErrorsRequests -
+			prevStats.CacheTotalErrorsRequests - prevStats.CoreTotalErrorsRequests
+		requests := stats.TotalRequestsRequests - prevStats.TotalRequestsRequests
+
+		switch {
+		case errors <= 0:
+			signals[PressureErrors] = 0
+		case requests <= 0:
+			signals[PressureErrors] = 1
+		default:
+			signals[PressureErrors] = clamp(float64(errors) / float64(requests) / errorRateFullPressure)
+		}
+	}
+
+	return signals
+}
+
+// collectPressure exports the normalized (0 to 1) pressure signals of each cache, smoothed
+// with an exponential moving average over the pressure window, for the schedulers that
+// place the VMs depending on the load of the caches
+func (e *CasExporter) collectPressure(prev, cur *snapshot) {
+	found := map[uint16]bool{}
+	for _, c := range cur.caches {
+		if c.Type != casadm.TypeCache {
+			continue
+		}
+
+		// The caches whose stats have failed keep their pressure
+		found[c.ID] = true
+
+		stats, ok := cur.stats[c.ID]
+		if !ok {
+			continue
+		}
+
+		var prevStats *casadm.CacheStats
+		if prev != nil {
+			prevStats = prev.stats[c.ID]
+		}
+		raw := e.rawPressure(c.ID, stats, prevStats)
+
+		p, ok := e.pressure[c.ID]
+		if !ok {
+			p = &cachePressure{signals: map[string]float64{}}
+			e.pressure[c.ID] = p
+		}
+
+		// The weight of the new value depends on the time since the previous one, since
+		// the extractions may not be evenly spaced (e.g. idle discovery)
+		alpha := 1.0
+		if e.pressureWindow > 0 && !p.at.IsZero() {
+			alpha = 1 - math.Exp(-cur.at.Sub(p.at).Seconds()/e.pressureWindow.Seconds())
+		}
+		p.at = cur.at
+
+		labels := e.labelSchema.cacheLabels(c.ID, c.Disk)
+		for signal, v := range raw {
+			if prevV, ok := p.signals[signal]; ok {
+				v = prevV + alpha*(v-prevV)
+			}
+			p.signals[signal] = v
+
+			e.set(e.ocfCachePressure, withLabel(labels, "signal", signal), v)
+		}
+	}
+
+	for id := range e.pressure {
+		if !found[id] {
+			delete(e.pressure, id)
+		}
+	}
+}
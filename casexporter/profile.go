@@ -0,0 +1,61 @@
+package casexporter
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Profile is the level of detail of the exposed metrics
+type Profile string
+
+const (
+	// ProfileFull exposes all the metrics
+	ProfileFull Profile = "full"
+	// ProfileLight only exposes the headline series: occupancy, dirty, hit ratio and errors
+	ProfileLight Profile = "light"
+)
+
+func ParseProfile(s string) (Profile, error) {
+	switch p := Profile(s); p {
+	case "":
+		return ProfileFull, nil
+
+	case ProfileFull, ProfileLight:
+		return p, nil
+
+	default:
+		return "", fmt.Errorf("unknown profile '%s'", s)
+	}
+}
+
+// lightFamilies are the metric families exposed in the light profile, besides the
+// headline cache stats
+var lightFamilies = map[string]bool{
+	"ocf_success": true,
+}
+
+// Gatherer returns a gatherer that only exposes the profile metrics
+func (p Profile) Gatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	if p != ProfileLight {
+		return g
+	}
+
+	headline := map[[2]string]bool{}
+	for _, st := range cacheStats {
+		if st.headline {
+			headline[[2]string{st.category, st.subcategory}] = true
+		}
+	}
+
+	return filterGatherer(g, func(mf *dto.MetricFamily, m *dto.Metric) bool {
+		switch mf.GetName() {
+		case "ocf_count", "ocf_percentage":
+			return headline[[2]string{labelValue(m, "category"), labelValue(m, "subcategory")}]
+
+		default:
+			return lightFamilies[mf.GetName()]
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package casexporter
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/sysfs"
+)
+
+// cacheDevice identifies the device behind a cache
+type cacheDevice struct {
+	path   string
+	serial string
+}
+
+// checkCacheDeviceReplaced exports when the device behind the cache has changed (a different
+// path or serial) since the previous extraction, so silent hardware swaps are recorded. The
+// disk is empty if it couldn't be resolved
+func (e *CasExporter) checkCacheDeviceReplaced(c *casadm.Cache, disk string) {
+	cur := cacheDevice{path: c.Disk}
+	if disk != "" {
+		serial, err := sysfs.Serial(disk)
+		if err != nil {
+			slog.Warn("get cache device serial",
+				slog.String("device", c.Disk),
+				slog.String("err", err.Error()),
+			)
+		}
+
+		cur.serial = serial
+	}
+
+	prev, ok := e.cacheDevices[c.ID]
+	e.cacheDevices[c.ID] = cur
+
+	if !ok || prev == cur {
+		return
+	}
+
+	// The serial can be unreadable in some extractions, which isn't a replacement
+	if prev.path == cur.path && (prev.serial == "" || cur.serial == "") {
+		if cur.serial == "" {
+			e.cacheDevices[c.ID] = prev
+		}
+
+		return
+	}
+
+	slog.Warn("cache device replaced",
+		slog.Int("cache_id", int(c.ID)),
+		slog.String("previous_device", prev.path),
+		slog.String("previous_serial", prev.serial),
+		slog.String("device", cur.path),
+		slog.String("serial", cur.serial),
+	)
+
+	e.set(e.ocfCacheDeviceReplaced, e.labelSchema.cacheLabels(c.ID, c.Disk), float64(time.Now().Unix()))
+}
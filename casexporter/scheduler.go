@@ -0,0 +1,42 @@
+package casexporter
+
+import (
+	"context"
+	"time"
+)
+
+// task is a collector that runs on its own interval, instead of on every extraction
+type task struct {
+	collector Collector
+	interval  time.Duration
+
+	last time.Time
+}
+
+// scheduler runs the tasks that are due on each extraction, so the slow or expensive
+// collections (e.g. configuration data) can run less often than the stats
+type scheduler struct {
+	tasks []*task
+}
+
+func (s *scheduler) add(c Collector, interval time.Duration) {
+	s.tasks = append(s.tasks, &task{
+		collector: c,
+		interval:  interval,
+	})
+}
+
+// run runs the tasks whose interval has elapsed since their last run, passing their errors
+// to onError. Tasks with an interval of 0 run every time
+func (s *scheduler) run(ctx context.Context, now time.Time, onError func(c Collector, err error)) {
+	for _, t := range s.tasks {
+		if !t.last.IsZero() && now.Sub(t.last) < t.interval {
+			continue
+		}
+
+		if err := t.collector.Collect(ctx); err != nil {
+			onError(t.collector, err)
+		}
+		t.last = now
+	}
+}
@@ -0,0 +1,127 @@
+package casexporter
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// seriesTracker keeps when each cache series was last set, so the ones that stop being
+// refreshed can be dropped
+type seriesTracker struct {
+	mu     sync.Mutex
+	series map[string]*trackedSeries
+}
+
+type trackedSeries struct {
+	vec    *prometheus.GaugeVec
+	labels prometheus.Labels
+	at     time.Time
+}
+
+func newSeriesTracker() *seriesTracker {
+	return &seriesTracker{
+		series: map[string]*trackedSeries{},
+	}
+}
+
+// seriesKey identifies the series by the description of its family, so it can be looked
+// up from the collected metrics
+func seriesKey(desc *prometheus.Desc, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(desc.String())
+	for _, k := range names {
+		b.WriteString("," + k + "=" + labels[k])
+	}
+
+	return b.String()
+}
+
+func (t *seriesTracker) touch(vec *prometheus.GaugeVec, desc *prometheus.Desc, labels prometheus.Labels, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := seriesKey(desc, labels)
+	if s, ok := t.series[key]; ok {
+		s.at = at
+		return
+	}
+
+	t.series[key] = &trackedSeries{
+		vec:    vec,
+		labels: labels,
+		at:     at,
+	}
+}
+
+// lastSet returns when the series of the metric was last set, if it's tracked
+func (t *seriesTracker) lastSet(m prometheus.Metric) (time.Time, bool) {
+	pb := &dto.Metric{}
+	if err := m.Write(pb); err != nil {
+		return time.Time{}, false
+	}
+
+	labels := map[string]string{}
+	for _, l := range pb.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.series[seriesKey(m.Desc(), labels)]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return s.at, true
+}
+
+// expire deletes the series that haven't been set since the deadline, and returns how many
+func (t *seriesTracker) expire(deadline time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expired := 0
+	for key, s := range t.series {
+		if s.at.Before(deadline) {
+			s.vec.Delete(s.labels)
+			delete(t.series, key)
+			expired++
+		}
+	}
+
+	return expired
+}
+
+// set sets the value of a cache series, keeping track of when it has been refreshed
+func (e *CasExporter) set(vec *prometheus.GaugeVec, labels prometheus.Labels, v float64) {
+	g := vec.With(labels)
+	g.Set(v)
+	if e.tracksSeries() {
+		e.series.touch(vec, g.Desc(), labels, time.Now())
+	}
+}
+
+// touch refreshes a cache series without changing its value
+func (e *CasExporter) touch(vec *prometheus.GaugeVec, labels prometheus.Labels) {
+	if e.tracksSeries() {
+		e.series.touch(vec, vec.With(labels).Desc(), labels, time.Now())
+	}
+}
+
+// tracksSeries returns whether the refreshes of the cache series are tracked. Without a TTL
+// or sample timestamps, nothing uses them
+func (e *CasExporter) tracksSeries() bool {
+	return e.metricTTL != 0 || e.sampleTimestamps
+}
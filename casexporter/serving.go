@@ -0,0 +1,41 @@
+package casexporter
+
+import "github.com/isard-vdi/CAS_Exporter/casadm"
+
+// collectServingRatio exports the ratio of the blocks read since the previous extraction
+// that have been served from the cache instead of the core devices, which represents the
+// bandwidth offload better than the requests hit ratio
+func (e *CasExporter) collectServingRatio(prev, cur *snapshot) {
+	if prev == nil {
+		return
+	}
+
+	for _, c := range cur.caches {
+		if c.Type != casadm.TypeCache {
+			continue
+		}
+
+		stats, ok := cur.stats[c.ID]
+		if !ok {
+			continue
+		}
+
+		prevStats, ok := prev.stats[c.ID]
+		if !ok {
+			continue
+		}
+
+		labels := e.labelSchema.cacheLabels(c.ID, c.Disk)
+
+		cache := float64(stats.ReadsFromCache4K - prevStats.ReadsFromCache4K)
+		core := float64(stats.ReadsFromCores4K - prevStats.ReadsFromCores4K)
+
+		// Without reads (or after a stats reset) there's no ratio
+		if cache < 0 || core < 0 || cache+core == 0 {
+			e.ocfCacheBlockServingRatio.Delete(labels)
+			continue
+		}
+
+		e.set(e.ocfCacheBlockServingRatio, labels, cache/(cache+core))
+	}
+}
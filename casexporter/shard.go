@@ -0,0 +1,102 @@
+package casexporter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Shard is the subset of the caches handled by an exporter instance, when several of them
+// share a host. Each instance handles the caches whose ID modulo Count is Index - 1
+type Shard struct {
+	// Index is the number of the shard, from 1 to Count
+	Index int
+	// Count is the number of shards. If it's 0, all the caches are handled
+	Count int
+}
+
+// ParseShard parses a shard in the N/M format (e.g. 2/4, the second of four shards). If s
+// is empty, all the caches are handled
+func ParseShard(s string) (Shard, error) {
+	if s == "" {
+		return Shard{}, nil
+	}
+
+	index, count, ok := strings.Cut(s, "/")
+	if !ok {
+		return Shard{}, fmt.Errorf("invalid shard '%s': must be N/M (e.g. 1/2)", s)
+	}
+
+	n, err := strconv.Atoi(index)
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard '%s': invalid index: %w", s, err)
+	}
+
+	m, err := strconv.Atoi(count)
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard '%s': invalid count: %w", s, err)
+	}
+
+	if m < 1 || n < 1 || n > m {
+		return Shard{}, fmt.Errorf("invalid shard '%s': the index must be between 1 and the count", s)
+	}
+
+	return Shard{Index: n, Count: m}, nil
+}
+
+func (s Shard) String() string {
+	if s.Count == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// Owns returns whether the cache belongs to the shard
+func (s Shard) Owns(cacheID uint16) bool {
+	return s.Count <= 1 || int(cacheID)%s.Count == s.Index-1
+}
+
+// LockPath returns the path of the lock file of the shard, so the instances of the host
+// don't conflict with each other, only with another instance of the same shard
+func (s Shard) LockPath(path string) string {
+	if s.Count == 0 || path == "" {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.shard-%d-of-%d%s", strings.TrimSuffix(path, ext), s.Index, s.Count, ext)
+}
+
+// filter returns the caches, and their cores, that belong to the shard
+func (s Shard) filter(caches []*casadm.Cache) []*casadm.Cache {
+	if s.Count <= 1 {
+		return caches
+	}
+
+	owned := []*casadm.Cache{}
+	for _, c := range caches {
+		if s.Owns(c.CacheID) {
+			owned = append(owned, c)
+		}
+	}
+
+	return owned
+}
+
+// collectShard exports the shard handled by the instance
+func (e *CasExporter) collectShard() {
+	if e.shard.Count == 0 {
+		return
+	}
+
+	e.ocfShardInfo.With(prometheus.Labels{
+		"shard":  strconv.Itoa(e.shard.Index),
+		"shards": strconv.Itoa(e.shard.Count),
+	}).Set(1)
+}
@@ -0,0 +1,199 @@
+package casexporter
+
+import (
+	"sort"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+// snapshot is the result of an extraction cycle
+type snapshot struct {
+	at     time.Time
+	caches []*casadm.Cache
+	// stats are indexed by cache ID
+	stats map[uint16]*casadm.CacheStats
+	// updated is when the stats of each cache were last read, indexed by cache ID. The
+	// caches whose stats have failed keep the time of the previous snapshots
+	updated map[uint16]time.Time
+	success bool
+}
+
+func (e *CasExporter) setSnapshot(s *snapshot) {
+	e.snapshotMu.Lock()
+	e.snapshot = s
+	e.snapshotMu.Unlock()
+
+	e.history.add(s)
+
+	for _, ch := range e.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel that receives a value every time there's a new snapshot
+// (e.g. to publish the Inventory after every extraction). The subscribers that are still
+// busy with the previous one miss it. It has to be called before the exporter is started
+func (e *CasExporter) Subscribe() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	e.subscribers = append(e.subscribers, ch)
+
+	return ch
+}
+
+// lastSnapshot returns the last extracted snapshot, or nil if no extraction has
+// succeeded yet
+func (e *CasExporter) lastSnapshot() *snapshot {
+	e.snapshotMu.RLock()
+	defer e.snapshotMu.RUnlock()
+
+	return e.snapshot
+}
+
+// InventorySchemaVersion is the version of the Inventory JSON schema. It's increased
+// every time there's a breaking change
+const InventorySchemaVersion = 1
+
+// Inventory is the discovered cache topology
+type Inventory struct {
+	SchemaVersion int               `json:"schema_version"`
+	UpdatedAt     *time.Time        `json:"updated_at"`
+	Success       bool              `json:"success"`
+	Caches        []*InventoryCache `json:"caches"`
+}
+
+type InventoryCache struct {
+	ID     uint16 `json:"id"`
+	Device string `json:"device"`
+	Status string `json:"status"`
+
+	WritePolicy      string  `json:"write_policy"`
+	CleaningPolicy   string  `json:"cleaning_policy,omitempty"`
+	PromotionPolicy  string  `json:"promotion_policy,omitempty"`
+	CacheLineSizeKiB float64 `json:"cache_line_size_kib,omitempty"`
+	SizeBlocks4KiB   float64 `json:"size_4kib_blocks,omitempty"`
+
+	// UpdatedAt is when the stats of the cache were last read, which is older than the
+	// inventory when they've failed since
+	UpdatedAt *time.Time       `json:"updated_at,omitempty"`
+	Stats     *InventoryStats  `json:"stats,omitempty"`
+	Cores     []*InventoryCore `json:"cores"`
+}
+
+type InventoryStats struct {
+	OccupancyPercent float64 `json:"occupancy_percent"`
+	DirtyPercent     float64 `json:"dirty_percent"`
+	ReadHitsPercent  float64 `json:"read_hits_percent"`
+	WriteHitsPercent float64 `json:"write_hits_percent"`
+	CacheErrors      int     `json:"cache_errors"`
+	CoreErrors       int     `json:"core_errors"`
+	TotalRequests    int     `json:"total_requests"`
+	ServicedRequests int     `json:"serviced_requests"`
+	DirtyForSeconds  int     `json:"dirty_for_seconds"`
+	InactiveCores    int     `json:"inactive_cores"`
+}
+
+type InventoryCore struct {
+	ID             uint16 `json:"id"`
+	Device         string `json:"device"`
+	Status         string `json:"status"`
+	ExportedObject string `json:"exported_object"`
+}
+
+func inventoryStats(stats *casadm.CacheStats) *InventoryStats {
+	return &InventoryStats{
+		OccupancyPercent: stats.OccupancyPercent,
+		DirtyPercent:     stats.DirtyPercent,
+		ReadHitsPercent:  stats.ReadHitsPercent,
+		WriteHitsPercent: stats.WriteHitsPercent,
+		CacheErrors:      stats.CacheTotalErrorsRequests,
+		CoreErrors:       stats.CoreTotalErrorsRequests,
+		TotalRequests:    stats.TotalRequestsRequests,
+		ServicedRequests: stats.ServicedRequestsRequests,
+		DirtyForSeconds:  stats.DirtyForS,
+		InactiveCores:    stats.InactiveCoreDevices,
+	}
+}
+
+// Inventory returns the topology discovered in the last extraction
+func (e *CasExporter) Inventory() *Inventory {
+	inv := &Inventory{
+		SchemaVersion: InventorySchemaVersion,
+		Caches:        []*InventoryCache{},
+	}
+
+	s := e.lastSnapshot()
+	if s == nil {
+		return inv
+	}
+
+	inv.UpdatedAt = &s.at
+	inv.Success = s.success
+
+	caches := map[uint16]*InventoryCache{}
+	for _, c := range s.caches {
+		switch c.Type {
+		case casadm.TypeCache:
+			cache := &InventoryCache{
+				ID:          c.ID,
+				Device:      c.Disk,
+				Status:      c.Status,
+				WritePolicy: c.WritePolicy,
+				Cores:       []*InventoryCore{},
+			}
+
+			if at, ok := s.updated[c.ID]; ok {
+				cache.UpdatedAt = &at
+			}
+
+			if stats, ok := s.stats[c.ID]; ok {
+				cache.CleaningPolicy = stats.CleaningPolicy
+				cache.PromotionPolicy = stats.PromotionPolicy
+				cache.CacheLineSizeKiB = stats.CacheLineSizeKB
+				cache.SizeBlocks4KiB = stats.Size4K
+				cache.Stats = inventoryStats(stats)
+			}
+
+			caches[c.ID] = cache
+			inv.Caches = append(inv.Caches, cache)
+
+		case casadm.TypeCore:
+			cache, ok := caches[c.CacheID]
+			if !ok {
+				continue
+			}
+
+			cache.Cores = append(cache.Cores, &InventoryCore{
+				ID:             c.ID,
+				Device:         c.Disk,
+				Status:         c.Status,
+				ExportedObject: c.Device,
+			})
+		}
+	}
+
+	return inv
+}
+
+// Stats returns the stats of the last extraction, sorted by cache ID, and when were
+// they extracted
+func (e *CasExporter) Stats() ([]*casadm.CacheStats, time.Time) {
+	stats := []*casadm.CacheStats{}
+
+	s := e.lastSnapshot()
+	if s == nil {
+		return stats, time.Time{}
+	}
+
+	for _, st := range s.stats {
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ID < stats[j].ID
+	})
+
+	return stats, s.at
+}
@@ -0,0 +1,328 @@
+package casexporter
+
+import (
+	"context"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+// cacheStat is a stat exported in the ocf_count and ocf_percentage metrics
+type cacheStat struct {
+	category    string
+	subcategory string
+	// headline stats are the cheap, most relevant ones, exposed in the light profile
+	headline   bool
+	count      func(s *casadm.CacheStats) float64
+	percentage func(s *casadm.CacheStats) float64
+}
+
+var cacheStats = []cacheStat{
+	// Usage
+	{
+		category:    "usage",
+		subcategory: "occupancy",
+		headline:    true,
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.Occupancy4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.OccupancyPercent },
+	},
+	{
+		category:    "usage",
+		subcategory: "free",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.Free4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.FreePercent },
+	},
+	{
+		category:    "usage",
+		subcategory: "clean",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.Clean4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.CleanPercent },
+	},
+	{
+		category:    "usage",
+		subcategory: "dirty",
+		headline:    true,
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.Dirty4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.DirtyPercent },
+	},
+
+	// Requests
+	{
+		category:    "requests",
+		subcategory: "rd_hits",
+		headline:    true,
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.ReadHitsRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.ReadHitsPercent },
+	},
+	{
+		category:    "requests",
+		subcategory: "rd_partial_misses",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.ReadPartialMissesRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.ReadPartialMissesPercent },
+	},
+	{
+		category:    "requests",
+		subcategory: "rd_full_misses",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.ReadFullMissesRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.ReadFullMissesPercent },
+	},
+	{
+		category:    "requests",
+		subcategory: "rd_total",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.ReadTotalRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.ReadTotalPercent },
+	},
+	{
+		category:    "requests",
+		subcategory: "wr_hits",
+		headline:    true,
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.WriteHitsRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.WriteHitsPercent },
+	},
+	{
+		category:    "requests",
+		subcategory: "wr_partial_misses",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.WritePartialMissesRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.WritePartialMissesPercent },
+	},
+	{
+		category:    "requests",
+		subcategory: "wr_full_misses",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.WriteFullMissesRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.WriteFullMissesPercent },
+	},
+	{
+		category:    "requests",
+		subcategory: "wr_total",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.WriteTotalRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.WriteTotalPercent },
+	},
+	{
+		category:    "requests",
+		subcategory: "rd_pt",
+		count:       func(s *casadm.CacheStats) float64 { return s.ReadTotalPercent },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.ReadTotalPercent },
+	},
+	{
+		category:    "requests",
+		subcategory: "wr_pt",
+		count:       func(s *casadm.CacheStats) float64 { return s.WriteTotalPercent },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.WriteTotalPercent },
+	},
+	{
+		category:    "requests",
+		subcategory: "serviced",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.ServicedRequestsRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.ServicedRequestsPercent },
+	},
+	{
+		category:    "requests",
+		subcategory: "total",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.TotalRequestsRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.TotalRequestsPercent },
+	},
+
+	// Blocks
+	{
+		category:    "blocks",
+		subcategory: "core_volume_rd",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.ReadsFromCores4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.ReadsFromCoresPercent },
+	},
+	{
+		category:    "blocks",
+		subcategory: "core_volume_wr",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.WritesFromCores4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.WritesFromCoresPercent },
+	},
+	{
+		category:    "blocks",
+		subcategory: "core_volume_total",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.TotalToFromCores4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.TotalToFromCoresPercent },
+	},
+	{
+		category:    "blocks",
+		subcategory: "cache_volume_rd",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.ReadsFromCache4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.ReadsFromCachePercent },
+	},
+	{
+		category:    "blocks",
+		subcategory: "cache_volume_wr",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.WritesToCachce4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.WritesToCachcePercent },
+	},
+	{
+		category:    "blocks",
+		subcategory: "cache_volume_total",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.TotalToFromCache4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.TotalToFromCachePercent },
+	},
+	{
+		category:    "blocks",
+		subcategory: "volume_rd",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.ReadsFromExportedObjects4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.ReadsFromExportedObjectsPercent },
+	},
+	{
+		category:    "blocks",
+		subcategory: "volume_wr",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.WritesToExportedObjects4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.WritesToExportedObjectsPercent },
+	},
+	{
+		category:    "blocks",
+		subcategory: "volume_total",
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.TotalToFromExportedObjects4K) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.TotalToFromExportedObjectsPercent },
+	},
+
+	// Errors
+	{
+		category:    "errors",
+		subcategory: "cache_volume_rd",
+		headline:    true,
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.CacheReadErrorsRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.CacheReadErrorsPercent },
+	},
+	{
+		category:    "errors",
+		subcategory: "cache_volume_wr",
+		headline:    true,
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.CacheWriteErrorsRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.CacheWriteErrorsPercent },
+	},
+	{
+		category:    "errors",
+		subcategory: "cache_volume_total",
+		headline:    true,
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.CacheTotalErrorsRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.CacheTotalErrorsPercent },
+	},
+	{
+		category:    "errors",
+		subcategory: "core_volume_rd",
+		headline:    true,
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.CoreReadErrorsRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.CoreReadErrorsPercent },
+	},
+	{
+		category:    "errors",
+		subcategory: "core_volume_wr",
+		headline:    true,
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.CoreWriteErrorsRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.CoreWriteErrorsPercent },
+	},
+	{
+		category:    "errors",
+		subcategory: "core_volume_total",
+		headline:    true,
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.CoreTotalErrorsRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.CoreTotalErrorsPercent },
+	},
+	{
+		category:    "errors",
+		subcategory: "total",
+		headline:    true,
+		count:       func(s *casadm.CacheStats) float64 { return float64(s.TotalErrorsRequests) },
+		percentage:  func(s *casadm.CacheStats) float64 { return s.TotalErrorsPercent },
+	},
+}
+
+// extraction is the extraction in progress, whose snapshot the stats collector fills
+type extraction struct {
+	// listed is whether the caches have been listed in the extraction, instead of reusing
+	// the ones of the last discovery
+	listed bool
+	prev   *snapshot
+	snap   *snapshot
+}
+
+// collectStats reads the stats of the caches discovered in the extraction, exporting them
+// in ocf_count and ocf_percentage and adding them to its snapshot. If a running cache fails,
+// the extraction fails
+func (e *CasExporter) collectStats(ctx context.Context) {
+	if e.extraction == nil {
+		return
+	}
+	prev, snap := e.extraction.prev, e.extraction.snap
+	caches := snap.caches
+
+	// The deadline is shared by all the caches, so the extraction doesn't overrun
+	// into the next one
+	statsCtx, cancel := e.extractionContext(ctx, snap.at)
+	defer cancel()
+
+	devices := cacheDevices(caches)
+	running := runningCaches(caches)
+	results := map[uint16]*cacheStatsResult{}
+	ids := statsCacheIDs(caches)
+	if e.extractionDeadline != 0 {
+		stalestFirst(ids, running, prev)
+	}
+	for i, id := range ids {
+		if statsCtx.Err() != nil && ctx.Err() == nil {
+			e.skipStale(ids[i:], devices)
+
+			// The skipped caches keep when they were last read, so their last update
+			// keeps growing
+			for _, id := range ids[i:] {
+				if at, found := updatedAt(prev, id, false, snap.at); found {
+					snap.updated[id] = at
+				}
+			}
+			break
+		}
+		e.set(e.ocfCacheStale, e.labelSchema.cacheLabels(id, devices[id]), 0)
+
+		r, ok := e.extractCacheStats(statsCtx, id, devices[id])
+		// The failures of the caches that aren't running only mark that cache
+		if !ok && running[id] {
+			snap.success = false
+		}
+
+		e.set(e.ocfCacheSuccess, e.labelSchema.cacheLabels(id, devices[id]), boolFloat(ok))
+		if at, found := updatedAt(prev, id, ok, time.Now()); found {
+			snap.updated[id] = at
+		}
+
+		if r != nil {
+			results[id] = r
+			if ok {
+				snap.stats[id] = r.stats
+			}
+		}
+	}
+
+	for _, c := range caches {
+		r, ok := results[c.CacheID]
+		if c.Device == "-" || !ok {
+			continue
+		}
+
+		for _, st := range cacheStats {
+			if !r.parsed(st) {
+				continue
+			}
+
+			labels := e.labelSchema.statsLabels(c, devices[c.CacheID])
+			labels["category"] = st.category
+			labels["subcategory"] = st.subcategory
+
+			// The values are the same, they only need to be kept from expiring
+			if r.unchanged {
+				e.touch(e.ocfStatCount, labels)
+				e.touch(e.ocfStatPercentage, labels)
+				continue
+			}
+
+			e.set(e.ocfStatCount, labels, st.count(r.stats))
+			e.set(e.ocfStatPercentage, labels, st.percentage(r.stats))
+		}
+	}
+
+	// The pass-through causes need more casadm commands, so they're bound by the
+	// deadline too. If it has been exceeded, their series are kept as they are
+	e.collectPassThrough(statsCtx, snap)
+}
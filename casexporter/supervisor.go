@@ -0,0 +1,59 @@
+package casexporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// minPanicBackoff is the time waited before the extraction is retried after the first
+	// panic, if it's longer than the interval. It's doubled on every consecutive panic, up
+	// to maxPanicBackoff
+	minPanicBackoff = time.Second
+	maxPanicBackoff = 5 * time.Minute
+)
+
+// supervisedExtract extracts the stats, recovering the panics (e.g. from unexpected casadm
+// output) so they don't take the whole exporter down. It returns the time to wait before
+// the next extraction, backing off while the extractions keep panicking
+func (e *CasExporter) supervisedExtract(ctx context.Context, interval time.Duration) time.Duration {
+	panicked := e.recoverExtract(ctx)
+	if !panicked {
+		e.panicBackoff = 0
+		return interval
+	}
+
+	e.panicBackoff = min(max(2*e.panicBackoff, minPanicBackoff), maxPanicBackoff)
+
+	// The backoff only delays the extractions, they aren't retried sooner than the interval
+	return max(interval, e.panicBackoff)
+}
+
+func (e *CasExporter) recoverExtract(ctx context.Context) (panicked bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		panicked = true
+		e.ocfCollectionPanics.Inc()
+		e.ocfStatSuccess.With(prometheus.Labels{}).Set(0)
+		// The extraction state may be inconsistent, so start over with a discovery
+		e.rediscover = true
+
+		slog.Error("extraction panic",
+			slog.String("panic", fmt.Sprint(r)),
+			slog.String("stack", string(debug.Stack())),
+		)
+	}()
+
+	e.Extract(ctx)
+
+	return false
+}
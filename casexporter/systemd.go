@@ -0,0 +1,45 @@
+package casexporter
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/isard-vdi/CAS_Exporter/systemd"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultSystemdUnits are the Open CAS units whose state is reported by default
+var DefaultSystemdUnits = []string{"open-cas.service", "open-cas-shutdown.service"}
+
+// collectSystemdUnits exports the state of the Open CAS systemd units, since a cache can be
+// running while the unit managing it has failed
+func (e *CasExporter) collectSystemdUnits(ctx context.Context) {
+	if len(e.systemdUnits) == 0 {
+		return
+	}
+
+	units, err := systemd.Units(ctx, e.systemdUnits)
+	if err != nil {
+		slog.Warn("get systemd units",
+			slog.String("err", err.Error()),
+		)
+
+		return
+	}
+
+	e.ocfSystemdUnitState.Reset()
+	for _, u := range units {
+		// Units without a unit file don't exist in this host
+		if u.LoadState == "not-found" {
+			continue
+		}
+
+		for _, state := range systemd.States {
+			e.ocfSystemdUnitState.With(prometheus.Labels{
+				"unit":  u.Name,
+				"state": state,
+			}).Set(boolFloat(u.ActiveState == state))
+		}
+	}
+}
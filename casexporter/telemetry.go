@@ -0,0 +1,51 @@
+package casexporter
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Stages of the extraction, used to label the collection errors
+const (
+	StageListCaches = "list_caches"
+	StageCacheStats = "cache_stats"
+	StageIOClasses  = "io_classes"
+)
+
+// telemetry are the operational metrics of the exporter itself
+type telemetry struct {
+	e *CasExporter
+}
+
+// Telemetry returns a collector of the operational metrics of the exporter (extraction
+// duration, success and errors), which can be served apart from the OCF metrics
+func (e *CasExporter) Telemetry() prometheus.Collector {
+	return &telemetry{e: e}
+}
+
+func (t *telemetry) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		t.e.ocfStatDuration,
+		t.e.ocfStatSuccess,
+		t.e.ocfGoMaxProcs,
+		t.e.ocfMemoryLimit,
+		t.e.ocfExtractionDuration,
+		t.e.ocfCasadmDuration,
+		t.e.ocfCollectionErrors,
+		t.e.ocfHookDuration,
+		t.e.ocfHookSuccess,
+		t.e.ocfHookFailures,
+		t.e.ocfCollectionLag,
+		t.e.ocfCollectionPanics,
+		t.e.ocfInstanceConflict,
+	}
+}
+
+func (t *telemetry) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range t.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (t *telemetry) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range t.collectors() {
+		c.Collect(ch)
+	}
+}
@@ -0,0 +1,28 @@
+package casexporter
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// withTimestamps returns a channel that forwards the metrics to ch with the time their
+// series were last set (when the casadm data was read), and a function that waits until
+// all of them have been forwarded. The series that aren't tracked are forwarded as is
+func (e *CasExporter) withTimestamps(ch chan<- prometheus.Metric) (chan<- prometheus.Metric, func()) {
+	in := make(chan prometheus.Metric)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for m := range in {
+			if at, ok := e.series.lastSet(m); ok {
+				m = prometheus.NewMetricWithTimestamp(at, m)
+			}
+
+			ch <- m
+		}
+	}()
+
+	return in, func() {
+		close(in)
+		<-done
+	}
+}
@@ -0,0 +1,152 @@
+package casexporter
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/isard-vdi/CAS_Exporter/blkid"
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/sysfs"
+)
+
+// Types of the block devices of the storage topology, besides the holder types
+const (
+	TopologyDisk      = "disk"
+	TopologyPartition = "part"
+	TopologyCAS       = "cas"
+	TopologyFS        = "fs"
+)
+
+// topologyElement returns the type and name of the block device in the storage topology
+// (e.g. lvm:vg/lv)
+func topologyElement(name string) string {
+	if sysfs.IsExportedObject(name) {
+		return TopologyCAS + ":" + name
+	}
+
+	typ, typeName, err := holderType(name)
+	if err != nil {
+		return HolderOther + ":" + name
+	}
+	if typ != HolderOther {
+		return typ + ":" + typeName
+	}
+
+	if disk, err := sysfs.Disk(name); err == nil && disk != name {
+		return TopologyPartition + ":" + name
+	}
+
+	return TopologyDisk + ":" + name
+}
+
+// stacksBelow returns the chains of kernel names from the bottom devices (e.g. the disks of
+// the physical volumes) up to the device, one per bottom device
+func stacksBelow(name string, depth int) [][]string {
+	lower, err := sysfs.Slaves(name)
+	if err != nil || depth >= maxHolderDepth {
+		lower = nil
+	}
+
+	// The partitions are on top of their disks
+	if len(lower) == 0 {
+		if disk, err := sysfs.Disk(name); err == nil && disk != name {
+			lower = []string{disk}
+		}
+	}
+
+	if len(lower) == 0 {
+		return [][]string{{name}}
+	}
+
+	stacks := [][]string{}
+	for _, l := range lower {
+		for _, s := range stacksBelow(l, depth+1) {
+			stacks = append(stacks, append(s, name))
+		}
+	}
+
+	return stacks
+}
+
+// stacksAbove returns the chains of kernel names from the device up to the top devices
+// (e.g. the logical volumes on top of it), one per top device
+func stacksAbove(name string, depth int) [][]string {
+	holders, err := sysfs.Holders(name)
+	if err != nil || depth >= maxHolderDepth || len(holders) == 0 {
+		return [][]string{{name}}
+	}
+
+	stacks := [][]string{}
+	for _, h := range holders {
+		for _, s := range stacksAbove(h, depth+1) {
+			stacks = append(stacks, append([]string{name}, s...))
+		}
+	}
+
+	return stacks
+}
+
+// collectStorageTopology exports the full stacking of the exported objects, from the disks
+// below the core devices to the filesystems on top, since misordered stacking (e.g. LVM
+// below the cache instead of above it) is a recurring cause of performance issues
+func (e *CasExporter) collectStorageTopology(ctx context.Context, caches []*casadm.Cache) {
+	e.ocfStorageTopologyInfo.Reset()
+
+	devices := cacheDevices(caches)
+	for _, c := range caches {
+		if c.Type != casadm.TypeCore || c.Device == "-" {
+			continue
+		}
+
+		object, err := sysfs.BlockName(c.Device)
+		if err != nil {
+			slog.Warn("resolve storage topology",
+				slog.String("device", c.Device),
+				slog.String("err", err.Error()),
+			)
+
+			continue
+		}
+
+		below := [][]string{{}}
+		if core, err := sysfs.BlockName(c.Disk); err == nil {
+			below = stacksBelow(core, 0)
+		} else {
+			slog.Warn("resolve storage topology",
+				slog.String("device", c.Disk),
+				slog.String("err", err.Error()),
+			)
+		}
+
+		labels := e.labelSchema.coreLabels(c, devices[c.CacheID])
+		for _, a := range stacksAbove(object, 0) {
+			top := a[len(a)-1]
+
+			fsType := ""
+			info, err := blkid.Probe(ctx, "/dev/"+top)
+			if err != nil {
+				slog.Warn("probe storage topology",
+					slog.String("device", "/dev/"+top),
+					slog.String("err", err.Error()),
+				)
+			} else {
+				fsType = info.Type
+			}
+
+			for _, b := range below {
+				elements := []string{}
+				for _, name := range append(append([]string{}, b...), a...) {
+					elements = append(elements, topologyElement(name))
+				}
+				if fsType != "" {
+					elements = append(elements, TopologyFS+":"+fsType)
+				}
+
+				l := withLabel(labels, "stack", strings.Join(elements, ">"))
+				l["fs_type"] = fsType
+				e.ocfStorageTopologyInfo.With(l).Set(1)
+			}
+		}
+	}
+}
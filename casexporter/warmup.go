@@ -0,0 +1,49 @@
+package casexporter
+
+import "github.com/isard-vdi/CAS_Exporter/casadm"
+
+// collectWarmup exports how fast each cache is being filled since the previous extraction
+// and the estimated time until it's full, so it's known when a freshly started cache is warmed
+func (e *CasExporter) collectWarmup(prev, cur *snapshot) {
+	if prev == nil {
+		return
+	}
+
+	elapsed := cur.at.Sub(prev.at).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	for _, c := range cur.caches {
+		if c.Type != casadm.TypeCache {
+			continue
+		}
+
+		stats, ok := cur.stats[c.ID]
+		if !ok {
+			continue
+		}
+
+		prevStats, ok := prev.stats[c.ID]
+		if !ok {
+			continue
+		}
+
+		labels := e.labelSchema.cacheLabels(c.ID, c.Disk)
+
+		rate := float64(stats.Occupancy4K-prevStats.Occupancy4K) / elapsed
+		e.set(e.ocfCacheWarmupFillRate, labels, rate)
+
+		switch {
+		case stats.Free4K == 0:
+			e.set(e.ocfCacheWarmupTimeToFull, labels, 0)
+
+		case rate > 0:
+			e.set(e.ocfCacheWarmupTimeToFull, labels, float64(stats.Free4K)/rate)
+
+		// If the cache isn't growing, there's no estimation
+		default:
+			e.ocfCacheWarmupTimeToFull.Delete(labels)
+		}
+	}
+}
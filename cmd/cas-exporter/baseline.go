@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+)
+
+// baselineOptions are the options of the baseline subcommand, set by its flags
+type baselineOptions struct {
+	name         string
+	dir          string
+	casadmBinary string
+	timeout      time.Duration
+}
+
+// newBaselineFlags returns the flags of the baseline subcommand, which set the options
+func newBaselineFlags(o *baselineOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("baseline", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s baseline record|compare [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&o.name, "name", "", "Name of the baseline")
+	fs.StringVar(&o.dir, "dir", casexporter.DefaultBaselineDir, "Directory where the baselines are stored")
+	fs.StringVar(&o.casadmBinary, "casadm-binary", "", "Path of the CAS administration binary. If empty, casadm is used, falling back to the legacy intelcas")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "Timeout for extracting the stats")
+
+	return fs
+}
+
+// baselineCmd records baselines of the cache stats and compares the current stats with them
+func baselineCmd(args []string) int {
+	o := baselineOptions{}
+	fs := newBaselineFlags(&o)
+
+	if len(args) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	action := args[0]
+	fs.Parse(args[1:])
+
+	if o.name == "" {
+		fmt.Fprintln(os.Stderr, "the baseline name is required")
+		return 2
+	}
+
+	cas, err := casadm.NewClient(o.casadmBinary)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create casadm client: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	switch action {
+	case "record":
+		b, err := casexporter.RecordBaseline(ctx, cas, o.name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "record baseline: %v\n", err)
+			return 1
+		}
+
+		if err := casexporter.SaveBaseline(o.dir, b); err != nil {
+			fmt.Fprintf(os.Stderr, "save baseline: %v\n", err)
+			return 1
+		}
+
+		fmt.Printf("recorded baseline '%s' with %d caches\n", b.Name, len(b.Caches))
+
+	case "compare":
+		b, err := casexporter.LoadBaseline(o.dir, o.name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "load baseline: %v\n", err)
+			return 1
+		}
+
+		deviations, err := casexporter.CompareBaseline(ctx, cas, b)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "compare baseline: %v\n", err)
+			return 1
+		}
+
+		fmt.Printf("baseline '%s' captured at %s\n\n", b.Name, b.CapturedAt.Format(time.RFC3339))
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CACHE\tSTAT\tBASELINE\tCURRENT\tDEVIATION")
+		for _, d := range deviations {
+			fmt.Fprintf(w, "%d\t%s\t%.2f\t%.2f\t%+.2f\n", d.CacheID, d.Stat, d.Baseline, d.Current, d.Value())
+		}
+		w.Flush()
+
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	return 0
+}
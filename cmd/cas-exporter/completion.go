@@ -0,0 +1,445 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// completionShells are the shells the completion scripts are generated for
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// completionCommand is a subcommand, as completed by the shells
+type completionCommand struct {
+	name        string
+	description string
+	flags       *flag.FlagSet
+	// actions are the words completed as first argument (e.g. record and compare)
+	actions []string
+	// subcommands are completed as first argument, each one with its flags
+	subcommands []*completionCommand
+	// exporterFlags is whether the exporter flags are taken after --
+	exporterFlags bool
+}
+
+func completionCommands() []*completionCommand {
+	return []*completionCommand{{
+		name:        "watch",
+		description: "Render a top-like table of the caches in the terminal",
+		flags:       newWatchFlags(&watchOptions{}),
+	}, {
+		name:        "lint",
+		description: "Check the exposed metrics",
+		flags:       newLintFlags(&lintOptions{}),
+	}, {
+		name:        "validate-config",
+		description: "Validate the configuration file",
+		flags:       newValidateConfigFlags(&validateConfigOptions{}),
+	}, {
+		name:          "generate",
+		description:   "Print the deployment artifacts matching the exporter flags",
+		exporterFlags: true,
+		subcommands: []*completionCommand{{
+			name:        "systemd",
+			description: "Print a hardened systemd unit",
+			flags:       newGenerateSystemdFlags(&generateSystemdOptions{}),
+		}, {
+			name:        "scrape-config",
+			description: "Print a Prometheus scrape configuration",
+			flags:       newGenerateScrapeConfigFlags(&generateScrapeConfigOptions{}),
+		}},
+	}, {
+		name:        "baseline",
+		description: "Record or compare baselines of the cache stats",
+		flags:       newBaselineFlags(&baselineOptions{}),
+		actions:     []string{"record", "compare"},
+	}, {
+		name:        "helper",
+		description: "Run the privileged helper casadm is run through",
+		flags:       newHelperFlags(&helperOptions{}),
+	}, {
+		name:        "completion",
+		description: "Print the shell completion script",
+		actions:     completionShells,
+	}}
+}
+
+// completionFlag is a flag, as completed by the shells
+type completionFlag struct {
+	name        string
+	description string
+	// value is whether the flag takes a value, and file whether the value is a path, completed
+	// as such
+	value bool
+	file  bool
+}
+
+func completionFlags(fs *flag.FlagSet) []completionFlag {
+	flags := []completionFlag{}
+	if fs == nil {
+		return flags
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		typ, usage := flag.UnquoteUsage(f)
+		cf := completionFlag{name: f.Name, description: firstSentence(usage)}
+
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+			cf.value = true
+			// The usage of the paths starts with what they are
+			cf.file = typ == "string" && (strings.HasPrefix(usage, "Path") || strings.HasPrefix(usage, "Directory"))
+		}
+
+		flags = append(flags, cf)
+	})
+
+	return flags
+}
+
+// firstSentence returns the first sentence of the usage of a flag, as its short description
+func firstSentence(s string) string {
+	for i := 0; ; {
+		j := strings.Index(s[i:], ". ")
+		if j == -1 {
+			break
+		}
+		j += i
+
+		if !strings.HasSuffix(s[:j], "e.g") && !strings.HasSuffix(s[:j], "i.e") {
+			return s[:j]
+		}
+		i = j + 2
+	}
+
+	return strings.TrimSuffix(s, ".")
+}
+
+// completionCmd prints the completion script of the shell, generated from the flags of the
+// exporter and the subcommands
+func completionCmd(args []string, exporterFlags *flag.FlagSet) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s completion %s\n", os.Args[0], strings.Join(completionShells, "|"))
+	}
+
+	if len(args) != 1 {
+		usage()
+		return 2
+	}
+
+	cmds := completionCommands()
+	flags := completionFlags(exporterFlags)
+
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion(cmds, flags))
+	case "zsh":
+		fmt.Print(zshCompletion(cmds, flags))
+	case "fish":
+		fmt.Print(fishCompletion(cmds, flags))
+	default:
+		usage()
+		return 2
+	}
+
+	return 0
+}
+
+func bashCompletion(cmds []*completionCommand, exporterFlags []completionFlag) string {
+	b := &strings.Builder{}
+
+	// The flags that take a value are listed apart, since their value is completed by bash
+	// (as a path)
+	context := func(name string, flags []completionFlag, words []string, position int) {
+		names, values := []string{}, []string{}
+		for _, f := range flags {
+			names = append(names, "-"+f.name)
+			if f.value {
+				values = append(values, "-"+f.name)
+			}
+		}
+
+		fmt.Fprintf(b, "    %q)\n", name)
+		fmt.Fprintf(b, "        flags=%q\n", strings.Join(names, " "))
+		fmt.Fprintf(b, "        values=%q\n", strings.Join(values, " "))
+		if len(words) != 0 {
+			fmt.Fprintf(b, "        if [[ $COMP_CWORD -eq %d ]]; then\n", position)
+			fmt.Fprintf(b, "            words=%q\n", strings.Join(words, " "))
+			fmt.Fprintf(b, "        fi\n")
+		}
+		fmt.Fprintf(b, "        ;;\n")
+	}
+
+	b.WriteString(`# bash completion for cas-exporter, generated by cas-exporter completion bash
+
+_cas_exporter() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+    local cmd="" words="" flags="" values="" i
+
+    if [[ $COMP_CWORD -gt 1 && ${COMP_WORDS[1]} != -* ]]; then
+        cmd="${COMP_WORDS[1]}"
+        if [[ $COMP_CWORD -gt 2 ]]; then
+            case "$cmd" in
+`)
+	for _, c := range cmds {
+		if len(c.subcommands) != 0 {
+			fmt.Fprintf(b, "            %s) cmd=\"$cmd ${COMP_WORDS[2]}\" ;;\n", c.name)
+		}
+	}
+	b.WriteString(`            esac
+        fi
+    fi
+
+    # The exporter flags are passed after --
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        if [[ ${COMP_WORDS[i]} == -- ]]; then
+            cmd="--"
+        fi
+    done
+
+    case "$cmd" in
+`)
+
+	names := []string{}
+	for _, c := range cmds {
+		names = append(names, c.name)
+	}
+	context("", exporterFlags, names, 1)
+	context("--", exporterFlags, nil, 0)
+
+	for _, c := range cmds {
+		words := slices.Clone(c.actions)
+		for _, s := range c.subcommands {
+			words = append(words, s.name)
+		}
+		context(c.name, completionFlags(c.flags), words, 2)
+
+		for _, s := range c.subcommands {
+			context(c.name+" "+s.name, completionFlags(s.flags), nil, 0)
+		}
+	}
+
+	b.WriteString(`    esac
+
+    # The values of the flags are completed as paths
+    if [[ " $values " == *" $prev "* ]]; then
+        return
+    fi
+
+    if [[ $cur == -* ]]; then
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+    elif [[ -n $words ]]; then
+        COMPREPLY=($(compgen -W "$words" -- "$cur"))
+    fi
+}
+
+complete -o default -F _cas_exporter cas-exporter
+`)
+
+	return b.String()
+}
+
+// zshQuote quotes s between single quotes
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// zshFlags returns the _arguments specs of the flags
+func zshFlags(flags []completionFlag) []string {
+	escape := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`)
+
+	specs := []string{}
+	for _, f := range flags {
+		spec := fmt.Sprintf("-%s[%s]", f.name, escape.Replace(f.description))
+		switch {
+		case f.file:
+			spec += fmt.Sprintf(":%s:_files", f.name)
+		case f.value:
+			spec += fmt.Sprintf(":%s: ", f.name)
+		}
+
+		specs = append(specs, zshQuote(spec))
+	}
+
+	return specs
+}
+
+// zshDescribe returns the _describe items of the subcommands
+func zshDescribe(cmds []*completionCommand) string {
+	items := []string{}
+	for _, c := range cmds {
+		items = append(items, zshQuote(c.name+":"+c.description))
+	}
+
+	return strings.Join(items, " ")
+}
+
+func zshCompletion(cmds []*completionCommand, exporterFlags []completionFlag) string {
+	b := &strings.Builder{}
+
+	// arguments writes the _arguments of the words after the subcommand, which is shifted
+	// to be the first one
+	arguments := func(name string, shift int, flags []completionFlag, actions []string) {
+		specs := zshFlags(flags)
+		if len(actions) != 0 {
+			specs = append([]string{zshQuote(fmt.Sprintf("1:action:(%s)", strings.Join(actions, " ")))}, specs...)
+		}
+
+		fmt.Fprintf(b, "    %s)\n", zshQuote(name))
+		fmt.Fprintf(b, "        shift %d words\n", shift)
+		fmt.Fprintf(b, "        (( CURRENT -= %d ))\n", shift)
+		if len(specs) != 0 {
+			fmt.Fprintf(b, "        _arguments %s\n", strings.Join(specs, " "))
+		}
+		fmt.Fprintf(b, "        ;;\n")
+	}
+
+	b.WriteString(`#compdef cas-exporter
+# zsh completion for cas-exporter, generated by cas-exporter completion zsh
+
+_cas_exporter() {
+    local cmd="" sep
+    local -a exporter_flags=(
+`)
+	for _, s := range zshFlags(exporterFlags) {
+		fmt.Fprintf(b, "        %s\n", s)
+	}
+	b.WriteString(`    )
+
+    if (( CURRENT > 2 )) && [[ $words[2] != -* ]]; then
+        cmd=$words[2]
+        if (( CURRENT > 3 )); then
+            case $cmd in
+`)
+	for _, c := range cmds {
+		if len(c.subcommands) != 0 {
+			fmt.Fprintf(b, "            %s) cmd=\"$cmd $words[3]\" ;;\n", c.name)
+		}
+	}
+	fmt.Fprintf(b, `            esac
+        fi
+    fi
+
+    # The exporter flags are passed after --
+    sep=${words[(i)--]}
+    if (( sep < CURRENT )); then
+        shift $(( sep - 1 )) words
+        (( CURRENT -= sep - 1 ))
+        cmd="--"
+    fi
+
+    case $cmd in
+    '')
+        if (( CURRENT == 2 )) && [[ $PREFIX != -* ]]; then
+            local -a commands=(%s)
+            _describe -t commands subcommand commands
+        else
+            _arguments $exporter_flags
+        fi
+        ;;
+    --)
+        _arguments $exporter_flags
+        ;;
+`, zshDescribe(cmds))
+
+	for _, c := range cmds {
+		if len(c.subcommands) != 0 {
+			fmt.Fprintf(b, "    %s)\n", zshQuote(c.name))
+			fmt.Fprintf(b, "        local -a commands=(%s)\n", zshDescribe(c.subcommands))
+			fmt.Fprintf(b, "        _describe -t commands subcommand commands\n")
+			fmt.Fprintf(b, "        ;;\n")
+
+			for _, s := range c.subcommands {
+				arguments(c.name+" "+s.name, 2, completionFlags(s.flags), s.actions)
+			}
+
+			continue
+		}
+
+		arguments(c.name, 1, completionFlags(c.flags), c.actions)
+	}
+
+	b.WriteString(`    esac
+}
+
+if [[ $funcstack[1] == _cas_exporter ]]; then
+    _cas_exporter "$@"
+else
+    compdef _cas_exporter cas-exporter
+fi
+`)
+
+	return b.String()
+}
+
+// fishQuote quotes s between single quotes
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func fishCompletion(cmds []*completionCommand, exporterFlags []completionFlag) string {
+	b := &strings.Builder{}
+
+	flags := func(condition string, flags []completionFlag) {
+		for _, f := range flags {
+			opts := ""
+			switch {
+			case f.file:
+				opts = " -r -F"
+			case f.value:
+				opts = " -x"
+			}
+
+			fmt.Fprintf(b, "complete -c cas-exporter -n %s -o %s%s -d %s\n", fishQuote(condition), f.name, opts, fishQuote(f.description))
+		}
+	}
+
+	words := func(condition string, cmds []*completionCommand) {
+		for _, c := range cmds {
+			fmt.Fprintf(b, "complete -c cas-exporter -n %s -a %s -d %s\n", fishQuote(condition), c.name, fishQuote(c.description))
+		}
+	}
+
+	names := []string{}
+	for _, c := range cmds {
+		names = append(names, c.name)
+	}
+
+	b.WriteString("# fish completion for cas-exporter, generated by cas-exporter completion fish\n\n")
+	b.WriteString("complete -c cas-exporter -f\n\n")
+
+	words("__fish_use_subcommand", cmds)
+	// The exporter flags are passed after --
+	flags(fmt.Sprintf("not __fish_seen_subcommand_from %s; or __fish_seen_subcommand_from --", strings.Join(names, " ")), exporterFlags)
+
+	for _, c := range cmds {
+		b.WriteString("\n")
+
+		seen := "__fish_seen_subcommand_from " + c.name
+		if c.exporterFlags {
+			seen += "; and not __fish_seen_subcommand_from --"
+		}
+
+		if len(c.actions) != 0 {
+			cond := fmt.Sprintf("%s; and not __fish_seen_subcommand_from %s", seen, strings.Join(c.actions, " "))
+			fmt.Fprintf(b, "complete -c cas-exporter -n %s -a %s\n", fishQuote(cond), fishQuote(strings.Join(c.actions, " ")))
+		}
+
+		if len(c.subcommands) != 0 {
+			subnames := []string{}
+			for _, s := range c.subcommands {
+				subnames = append(subnames, s.name)
+			}
+			words(fmt.Sprintf("%s; and not __fish_seen_subcommand_from %s", seen, strings.Join(subnames, " ")), c.subcommands)
+
+			for _, s := range c.subcommands {
+				flags(fmt.Sprintf("%s; and __fish_seen_subcommand_from %s", seen, s.name), completionFlags(s.flags))
+			}
+		}
+
+		flags(seen, completionFlags(c.flags))
+	}
+
+	return b.String()
+}
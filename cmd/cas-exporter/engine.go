@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/transport/engine"
+)
+
+// engineConfig returns the configuration of the isard-vdi engine reporter of the flags
+func engineConfig(engineURL, tokenFile string, interval time.Duration) (engine.Config, error) {
+	u, err := url.Parse(engineURL)
+	if err != nil {
+		return engine.Config{}, fmt.Errorf("invalid url '%s': %w", engineURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return engine.Config{}, fmt.Errorf("invalid url '%s': must be an http or https URL", engineURL)
+	}
+
+	if interval <= 0 {
+		return engine.Config{}, fmt.Errorf("invalid interval %s: must be positive", interval)
+	}
+
+	var token string
+	if tokenFile != "" {
+		b, err := os.ReadFile(tokenFile)
+		if err == nil && strings.TrimSpace(string(b)) == "" {
+			err = errors.New("the token file is empty")
+		}
+		if err != nil {
+			return engine.Config{}, fmt.Errorf("read token: %w", err)
+		}
+
+		token = strings.TrimSpace(string(b))
+	}
+
+	return engine.Config{
+		URL:      engineURL,
+		Token:    token,
+		Interval: interval,
+	}, nil
+}
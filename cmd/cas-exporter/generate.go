@@ -0,0 +1,330 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/isard-vdi/CAS_Exporter/lockfile"
+	"github.com/isard-vdi/CAS_Exporter/transport/http"
+)
+
+// defaultAddr is the default value of the -addr flag
+const defaultAddr = "0.0.0.0:2114"
+
+// generateCmd prints the deployment artifacts (systemd unit, Prometheus scrape
+// configuration) matching the exporter flags passed after --, so the rollouts of the
+// fleet stay consistent
+func generateCmd(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s generate systemd|scrape-config [flags] [-- exporter flags]\n", os.Args[0])
+	}
+
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+
+	// The exporter flags are after --
+	exporterArgs := []string{}
+	for i, a := range args {
+		if a == "--" {
+			exporterArgs = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+
+	switch args[0] {
+	case "systemd":
+		return generateSystemdCmd(args[1:], exporterArgs)
+	case "scrape-config":
+		return generateScrapeConfigCmd(args[1:], exporterArgs)
+	}
+
+	usage()
+	return 2
+}
+
+var systemdTemplate = template.Must(template.New("systemd").Parse(`[Unit]
+Description=Open CAS Prometheus exporter
+Documentation=https://github.com/isard-vdi/CAS_Exporter
+Wants=network-online.target
+After=network-online.target open-cas.service
+
+[Service]
+Type=simple
+ExecStart={{ .ExecStart }}
+Restart=on-failure
+RestartSec=5s
+# Reloading hands off to a new process of the binary, which takes over as the main one
+ExecReload=/bin/kill -USR2 $MAINPID
+NotifyAccess=main
+
+# casadm talks to the kernel module through /dev/cas_ctrl, which requires root
+User=root
+CapabilityBoundingSet=CAP_SYS_ADMIN CAP_DAC_READ_SEARCH{{ if .BindPrivileged }} CAP_NET_BIND_SERVICE{{ end }}
+NoNewPrivileges=yes
+ProtectSystem=strict
+ProtectHome=yes
+PrivateTmp=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectKernelLogs=yes
+ProtectControlGroups=yes
+ProtectClock=yes
+ProtectHostname=yes
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+{{- range .ReadWritePaths }}
+ReadWritePaths={{ . }}
+{{- end }}
+{{- if .StateDirectory }}
+StateDirectory={{ .StateDirectory }}
+StateDirectoryMode=0700
+{{- end }}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// generateSystemdOptions are the options of the generate systemd subcommand, set by its
+// flags
+type generateSystemdOptions struct {
+	binary string
+}
+
+// newGenerateSystemdFlags returns the flags of the generate systemd subcommand, which set
+// the options
+func newGenerateSystemdFlags(o *generateSystemdOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("generate systemd", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s generate systemd [flags] [-- exporter flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&o.binary, "binary", "/usr/local/bin/cas-exporter", "Path of the exporter binary on the hosts")
+
+	return fs
+}
+
+func generateSystemdCmd(args, exporterArgs []string) int {
+	o := generateSystemdOptions{}
+	fs := newGenerateSystemdFlags(&o)
+
+	fs.Parse(args)
+
+	execStart := []string{systemdQuote(o.binary)}
+	for _, a := range exporterArgs {
+		execStart = append(execStart, systemdQuote(a))
+	}
+
+	// The lock file is written by the exporter
+	rw := []string{}
+	lock, ok := lookupFlag(exporterArgs, "lock-file")
+	if !ok {
+		lock = lockfile.DefaultPath
+	}
+	if lock != "" {
+		rw = append(rw, filepath.Dir(lock))
+	}
+
+	// And the ACME certificates. Their directory may not exist yet, so under /var/lib it's
+	// created by systemd as a state directory
+	state := ""
+	if domains, ok := lookupFlag(exporterArgs, "acme-domains"); ok && domains != "" {
+		dir, ok := lookupFlag(exporterArgs, "acme-cache-dir")
+		if !ok {
+			dir = http.DefaultACMECacheDir
+		}
+
+		if rel, ok := strings.CutPrefix(filepath.Clean(dir), "/var/lib/"); ok {
+			state = rel
+		} else {
+			rw = append(rw, dir)
+		}
+	}
+
+	// Listening at the ports below 1024 (e.g. :80 for the ACME challenges) requires its
+	// capability
+	privileged := false
+	for _, name := range []string{"addr", "telemetry-addr", "acme-http-addr"} {
+		addr, ok := lookupFlag(exporterArgs, name)
+		if !ok && name == "addr" {
+			addr = defaultAddr
+		}
+
+		if _, p, err := net.SplitHostPort(addr); err == nil {
+			if port, err := strconv.Atoi(p); err == nil && port < 1024 {
+				privileged = true
+			}
+		}
+	}
+
+	if err := systemdTemplate.Execute(os.Stdout, map[string]any{
+		"ExecStart":      strings.Join(execStart, " "),
+		"ReadWritePaths": rw,
+		"StateDirectory": state,
+		"BindPrivileged": privileged,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "generate systemd unit: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+var scrapeConfigTemplate = template.Must(template.New("scrape-config").Parse(`scrape_configs:
+{{- range .Jobs }}
+  - job_name: {{ .Name }}
+    scrape_interval: {{ $.Interval }}
+{{- if $.Scheme }}
+    scheme: {{ $.Scheme }}
+{{- end }}
+    static_configs:
+      - targets:
+{{- range .Targets }}
+          - {{ . }}
+{{- end }}
+{{- end }}
+`))
+
+// generateScrapeConfigOptions are the options of the generate scrape-config subcommand, set
+// by its flags
+type generateScrapeConfigOptions struct {
+	job   string
+	hosts string
+}
+
+// newGenerateScrapeConfigFlags returns the flags of the generate scrape-config subcommand,
+// which set the options
+func newGenerateScrapeConfigFlags(o *generateScrapeConfigOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("generate scrape-config", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s generate scrape-config [flags] [-- exporter flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&o.job, "job", "cas-exporter", "Name of the scrape job")
+	fs.StringVar(&o.hosts, "hosts", "localhost", "Comma separated list of the hosts running the exporter")
+
+	return fs
+}
+
+func generateScrapeConfigCmd(args, exporterArgs []string) int {
+	o := generateScrapeConfigOptions{}
+	fs := newGenerateScrapeConfigFlags(&o)
+
+	fs.Parse(args)
+
+	type scrapeJob struct {
+		Name    string
+		Targets []string
+	}
+
+	targets := func(addr string) ([]string, error) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address '%s': %w", addr, err)
+		}
+
+		t := []string{}
+		for _, h := range splitList(o.hosts) {
+			t = append(t, net.JoinHostPort(h, port))
+		}
+
+		return t, nil
+	}
+
+	addr, ok := lookupFlag(exporterArgs, "addr")
+	if !ok {
+		addr = defaultAddr
+	}
+
+	t, err := targets(addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	jobs := []scrapeJob{{Name: o.job, Targets: t}}
+
+	if addr, ok := lookupFlag(exporterArgs, "telemetry-addr"); ok && addr != "" {
+		t, err := targets(addr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		jobs = append(jobs, scrapeJob{Name: o.job + "-telemetry", Targets: t})
+	}
+
+	// Scraping faster than the extraction interval only returns the same values
+	interval, ok := lookupFlag(exporterArgs, "extraction-interval")
+	if !ok {
+		interval = "30s"
+	}
+
+	// Both servers use TLS when it's configured
+	scheme := ""
+	for _, name := range []string{"tls-cert-file", "acme-domains"} {
+		if v, ok := lookupFlag(exporterArgs, name); ok && v != "" {
+			scheme = "https"
+		}
+	}
+
+	if err := scrapeConfigTemplate.Execute(os.Stdout, map[string]any{
+		"Jobs":     jobs,
+		"Interval": interval,
+		"Scheme":   scheme,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "generate scrape config: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// lookupFlag returns the value of the flag in the arguments, in any of the forms accepted
+// by the flag package (-name value, -name=value, --name value, --name=value)
+func lookupFlag(args []string, name string) (string, bool) {
+	value, found := "", false
+	for i := 0; i < len(args); i++ {
+		a := strings.TrimPrefix(strings.TrimPrefix(args[i], "-"), "-")
+		if a == args[i] {
+			continue
+		}
+
+		if n, v, ok := strings.Cut(a, "="); ok {
+			if n == name {
+				value, found = v, true
+			}
+			continue
+		}
+
+		if a == name && i+1 < len(args) {
+			value, found = args[i+1], true
+			i++
+		}
+	}
+
+	return value, found
+}
+
+// systemdQuote quotes the argument of ExecStart, if needed
+func systemdQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;$%") {
+		return s
+	}
+
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `%`, `%%`, `$`, `$$`)
+	return `"` + r.Replace(s) + `"`
+}
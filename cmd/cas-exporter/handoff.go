@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/handoff"
+	"github.com/isard-vdi/CAS_Exporter/lockfile"
+	"github.com/isard-vdi/CAS_Exporter/transport/http"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+// handoffTimeout is the maximum time the new process can take to be ready during a handoff
+const handoffTimeout = 2 * time.Minute
+
+// handoffLock is the name of the lock file passed to the new process
+const handoffLock = "lock"
+
+// handOff starts a new process of the exporter (e.g. after upgrading the binary), passing it
+// the listeners and the lock, and waits until it's ready to take over
+func handOff(srv *http.ExporterServer, lock *lockfile.Lock) error {
+	files, err := srv.ListenerFiles()
+	if err != nil {
+		return err
+	}
+	// The listener files are duplicates, but the lock one isn't
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	passed := maps.Clone(files)
+	if lock != nil {
+		passed[handoffLock] = lock.File()
+	}
+
+	slog.Info("handing off to a new process")
+
+	pid, err := handoff.Start(passed, handoffTimeout)
+	if err != nil {
+		return err
+	}
+
+	if lock != nil {
+		lock.HandOff()
+	}
+
+	// Under systemd, the new process has to be supervised instead of this one
+	if _, err := daemon.SdNotify(false, fmt.Sprintf("MAINPID=%d", pid)); err != nil {
+		slog.Error("notify systemd of the new main process",
+			slog.String("err", err.Error()),
+		)
+	}
+
+	slog.Info("handed off to the new process",
+		slog.Int("pid", pid),
+	)
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/transport/heartbeat"
+)
+
+// heartbeatConfig returns the configuration of the heartbeat pinger of the flags
+func heartbeatConfig(heartbeatURL string, interval time.Duration) (heartbeat.Config, error) {
+	u, err := url.Parse(heartbeatURL)
+	if err != nil {
+		return heartbeat.Config{}, fmt.Errorf("invalid url '%s': %w", heartbeatURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return heartbeat.Config{}, fmt.Errorf("invalid url '%s': must be an http or https URL", heartbeatURL)
+	}
+
+	if interval < 0 {
+		return heartbeat.Config{}, fmt.Errorf("invalid interval %s: must not be negative", interval)
+	}
+
+	return heartbeat.Config{
+		URL:      heartbeatURL,
+		Interval: interval,
+	}, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/helper"
+)
+
+// helperOptions are the options of the helper subcommand, set by its flags
+type helperOptions struct {
+	socket            string
+	socketGroup       string
+	casadmBinary      string
+	allowSetCacheMode bool
+}
+
+// newHelperFlags returns the flags of the helper subcommand, which set the options
+func newHelperFlags(o *helperOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("helper", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s helper [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&o.socket, "socket", helper.DefaultSocket, "Path of the unix socket the exporter connects to")
+	fs.StringVar(&o.socketGroup, "socket-group", "", "Group of the socket, the one of the exporter user, which is the only one (along with the owner) that can connect. If empty, it's the group of the helper")
+	fs.StringVar(&o.casadmBinary, "casadm-binary", "", "Path of the CAS administration binary. If empty, casadm is used, falling back to the legacy intelcas")
+	fs.BoolVar(&o.allowSetCacheMode, "allow-set-cache-mode", false, "Allow the exporter to change the caching mode of the caches (/api/v1/caches/<id>/cache-mode). Otherwise, only the commands that extract the stats are run")
+
+	return fs
+}
+
+// helperCmd runs the privileged helper, which runs casadm for an exporter started with
+// -helper-socket, so the process listening on the network doesn't need to be root
+func helperCmd(args []string) int {
+	o := helperOptions{}
+	fs := newHelperFlags(&o)
+
+	fs.Parse(args)
+
+	slog.SetDefault(newLogger(nil))
+
+	gid := -1
+	if o.socketGroup != "" {
+		g, err := user.LookupGroup(o.socketGroup)
+		if err == nil {
+			gid, err = strconv.Atoi(g.Gid)
+		}
+		if err != nil {
+			slog.Error("look up socket group",
+				slog.String("group", o.socketGroup),
+				slog.String("err", err.Error()),
+			)
+			return 1
+		}
+	}
+
+	cas, err := casadm.NewClient(o.casadmBinary)
+	if err != nil {
+		slog.Error("create casadm client",
+			slog.String("err", err.Error()),
+		)
+		return 1
+	}
+
+	l, err := helper.Listen(o.socket, gid)
+	if err != nil {
+		slog.Error("listen",
+			slog.String("socket", o.socket),
+			slog.String("err", err.Error()),
+		)
+		return 1
+	}
+
+	slog.Info("helper listening",
+		slog.String("socket", o.socket),
+		slog.String("binary", cas.Binary()),
+		slog.Bool("allow_set_cache_mode", o.allowSetCacheMode),
+	)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	s := helper.NewServer(helper.Config{AllowSetCacheMode: o.allowSetCacheMode}, cas)
+	if err := s.Serve(ctx, l); err != nil {
+		slog.Error("serve helper",
+			slog.String("err", err.Error()),
+		)
+		return 1
+	}
+
+	return 0
+}
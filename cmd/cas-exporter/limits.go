@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// byteUnits are the units accepted in the memory limit, the same as GOMEMLIMIT
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TiB", 1 << 40},
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"B", 1},
+}
+
+// parseBytes parses a size in bytes with an optional unit suffix (e.g. 64MiB)
+func parseBytes(s string) (int64, error) {
+	num := s
+	size := int64(1)
+	for _, u := range byteUnits {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			num = n
+			size = u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s', it must be a number of bytes with an optional B, KiB, MiB, GiB or TiB suffix", s)
+	}
+
+	return n * size, nil
+}
+
+// applyResourceLimits sets the CPU and memory limits of the runtime. The limits that are
+// unset keep their default, or the GOMAXPROCS and GOMEMLIMIT environment variables
+func applyResourceLimits(gomaxprocs int, memlimit string) error {
+	if gomaxprocs > 0 {
+		runtime.GOMAXPROCS(gomaxprocs)
+	}
+
+	if memlimit != "" {
+		limit, err := parseBytes(memlimit)
+		if err != nil {
+			return fmt.Errorf("parse memory limit: %w", err)
+		}
+
+		debug.SetMemoryLimit(limit)
+	}
+
+	slog.Info("resource limits",
+		slog.Int("gomaxprocs", runtime.GOMAXPROCS(0)),
+		slog.Int64("memlimit", debug.SetMemoryLimit(-1)),
+	)
+
+	return nil
+}
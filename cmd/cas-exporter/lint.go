@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+	"github.com/isard-vdi/CAS_Exporter/transport/http"
+)
+
+// lintOptions are the options of the lint subcommand, set by its flags
+type lintOptions struct {
+	casadmBinary string
+	timeout      time.Duration
+	labelSchema  string
+}
+
+// newLintFlags returns the flags of the lint subcommand, which set the options
+func newLintFlags(o *lintOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s lint [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&o.casadmBinary, "casadm-binary", "", "Path of the CAS administration binary. If empty, casadm is used, falling back to the legacy intelcas")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "Timeout for extracting the stats")
+	fs.StringVar(&o.labelSchema, "label-schema", string(casexporter.LabelSchemaLegacy), "Identity labels of the series: 'legacy' or 'v2'")
+
+	return fs
+}
+
+// lintCmd extracts the stats once and checks the exposed metrics
+func lintCmd(args []string) int {
+	o := lintOptions{}
+	fs := newLintFlags(&o)
+
+	fs.Parse(args)
+
+	schema, err := casexporter.ParseLabelSchema(o.labelSchema)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	cas, err := casadm.NewClient(o.casadmBinary)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create casadm client: %v\n", err)
+		return 1
+	}
+
+	// The extraction errors are reported through the metrics, so keep the output clean
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	})))
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	c := casexporter.NewCasExporter(casexporter.Config{LabelSchema: schema}, cas)
+	c.Extract(ctx)
+
+	problems, err := casexporter.Lint(http.NewRegistry(c))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", p.Metric, p.Text)
+	}
+
+	if len(problems) != 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/isard-vdi/CAS_Exporter/config"
+)
+
+// newLogger returns the JSON logger of the exporter, which adds the fields to every record
+func newLogger(fields map[string]string) *slog.Logger {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	attrs := make([]slog.Attr, 0, len(names))
+	for _, name := range names {
+		attrs = append(attrs, slog.String(name, fields[name]))
+	}
+
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}).WithAttrs(attrs))
+}
+
+// logFields returns the log fields of the configuration, overridden by the ones of the
+// flag, a comma separated list of name=value pairs
+func logFields(cfg map[string]string, flag string) (map[string]string, error) {
+	fields := maps.Clone(cfg)
+	if fields == nil {
+		fields = map[string]string{}
+	}
+
+	for _, f := range splitList(flag) {
+		name, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid log field '%s': must be name=value", f)
+		}
+
+		name = strings.TrimSpace(name)
+		if err := config.ValidateLogField(name); err != nil {
+			return nil, fmt.Errorf("invalid log field '%s': %w", f, err)
+		}
+
+		fields[name] = strings.TrimSpace(value)
+	}
+
+	return fields, nil
+}
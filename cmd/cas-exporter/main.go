@@ -2,54 +2,647 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/isard-vdi/CAS_Exporter/alerts"
+	"github.com/isard-vdi/CAS_Exporter/audit"
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/casctl"
 	"github.com/isard-vdi/CAS_Exporter/casexporter"
+	"github.com/isard-vdi/CAS_Exporter/config"
+	"github.com/isard-vdi/CAS_Exporter/handoff"
+	"github.com/isard-vdi/CAS_Exporter/helper"
+	"github.com/isard-vdi/CAS_Exporter/lockfile"
+	"github.com/isard-vdi/CAS_Exporter/transport/engine"
+	"github.com/isard-vdi/CAS_Exporter/transport/heartbeat"
 	"github.com/isard-vdi/CAS_Exporter/transport/http"
+	"github.com/isard-vdi/CAS_Exporter/transport/mqtt"
+	"github.com/isard-vdi/CAS_Exporter/transport/snmp"
+	"github.com/isard-vdi/CAS_Exporter/transport/zabbix"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var addr string
 
 func main() {
-	addr := flag.String("addr", "0.0.0.0:2114", "Address to listen for HTTP metrics extraction (/metrics)")
+	// The files passed by the previous process during a handoff have to be taken before
+	// their descriptors are reused
+	inherited := handoff.Inherited()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "baseline":
+			os.Exit(baselineCmd(os.Args[2:]))
+		case "watch":
+			os.Exit(watchCmd(os.Args[2:]))
+		case "lint":
+			os.Exit(lintCmd(os.Args[2:]))
+		case "validate-config":
+			os.Exit(validateConfigCmd(os.Args[2:]))
+		case "generate":
+			os.Exit(generateCmd(os.Args[2:]))
+		case "helper":
+			os.Exit(helperCmd(os.Args[2:]))
+		}
+	}
+
+	configPath := flag.String("config", "", "Path of the configuration file (YAML), with the per cache settings. If empty, it's not read")
+	profileName := flag.String("profile", "", "Name of the profile of the configuration file to use, which bundles flags, collectors and labels for a role of the fleet (e.g. hypervisor). If empty, no profile is used")
+	addr := flag.String("addr", defaultAddr, "Address to listen for HTTP metrics extraction (/metrics)")
+	telemetryAddr := flag.String("telemetry-addr", "", "Address to listen for HTTP extraction of the exporter operational metrics (extraction errors, HTTP requests, Go runtime...), apart from the OCF metrics. If empty, they're not served")
 	extractionInterval := flag.Duration("extraction-interval", 30*time.Second, "Interval between stats extraction")
+	discoveryInterval := flag.Duration("discovery-interval", 0, "Interval between caches discoveries (casadm --list-caches), which can be longer than the extraction interval since the topology changes rarely. If 0, the caches are discovered on every extraction")
+	newCacheCheckInterval := flag.Duration("new-cache-check-interval", 5*time.Second, "Interval between checks for new exported objects in sysfs, which trigger a discovery and an extraction right away. If 0, they're not checked")
+	configInterval := flag.Duration("config-interval", 0, "Interval between collections of the configuration data (kernel module, IO classes and systemd units), which changes rarely. If 0, it's collected on every extraction")
+	idleInterval := flag.Duration("idle-interval", 5*time.Minute, "Interval between stats extraction while there are no caches running, until one is created. If 0, the extraction interval is used")
+	cacheStatsTimeout := flag.Duration("cache-stats-timeout", 10*time.Second, "Maximum time the stats extraction of a single cache can take, so a cache that isn't responding (e.g. Incomplete or Stopping) doesn't delay the rest. If 0, there's no limit")
+	extractionDeadline := flag.Duration("extraction-deadline", 0, "Maximum time the stats extraction of all the caches can take, so it doesn't overrun into the next one. The caches left when it's exceeded are skipped and marked as stale, and go first on the next extraction. If 0, there's no limit")
+	casadmBinary := flag.String("casadm-binary", "", "Path of the CAS administration binary. If empty, casadm is used, falling back to the legacy intelcas")
+	helperSocket := flag.String("helper-socket", "", "Path of the unix socket of the privileged helper (cas-exporter helper) casadm is run through, so the exporter doesn't need to be root. If empty, casadm is run by the exporter")
+	inputDir := flag.String("input-dir", "", "Directory with the casadm CSV output written by an external job (list-caches.csv, stats-<cache id>.csv...), for hosts where the exporter can't run casadm. If set, casadm isn't run")
+	casctlConfig := flag.String("casctl-config", casctl.DefaultConfig, "Path of the casctl configuration, used to report configured caches that aren't running. If empty, it's not read")
+	metricTTL := flag.Duration("metric-ttl", 0, "Time after which the cache series that haven't been refreshed are dropped. If 0, they're never dropped")
+	historySize := flag.Int("history-size", 120, "Number of extractions kept in memory for the history API (/api/v1/history)")
+	anomalyZScore := flag.Float64("anomaly-zscore", 0, "Z-score above which the hit ratio and error rate of a cache are flagged as anomalies. If 0, anomaly detection is disabled")
+	pressureWindow := flag.Duration("pressure-window", 5*time.Minute, "Time constant of the moving average of the cache pressure signals (ocf_cache_pressure), so short spikes don't move the VM placement. If 0, they aren't smoothed")
+	anomalyWindow := flag.Int("anomaly-window", 60, "Number of extractions used as the anomaly detection baseline")
+	baselineName := flag.String("baseline", "", "Name of the baseline (recorded with 'baseline record') to export the stats deviation against. If empty, no deviation is exported")
+	baselineDir := flag.String("baseline-dir", casexporter.DefaultBaselineDir, "Directory where the baselines are stored")
+	systemdUnits := flag.String("systemd-units", strings.Join(casexporter.DefaultSystemdUnits, ","), "Comma separated list of the Open CAS systemd units whose state is reported, queried through D-Bus. If empty, no state is reported")
+	libvirtURI := flag.String("libvirt-uri", "", "libvirt connection URI (e.g. qemu:///system) of the running domains whose disks are mapped to the exported objects, queried read-only with virsh on every extraction. If empty, they aren't mapped")
+	shardFlag := flag.String("shard", "", "Subset of the caches handled by this instance, as N/M (the Nth of M shards), for hosts with several instances that each handle the caches whose ID modulo M is N - 1. If empty, all the caches are handled")
+	lockFile := flag.String("lock-file", lockfile.DefaultPath, "Path of the lock file that prevents running two exporter instances on the same host. If empty, no lock is taken")
+	sampleTimestamps := flag.Bool("sample-timestamps", false, "Attach to the cache series the time they were read from casadm, instead of letting Prometheus use the scrape time. Useful with long extraction intervals")
+	nativeHistograms := flag.Bool("native-histograms", false, "Expose the duration histograms (extraction, casadm commands and HTTP requests) also as native histograms, for Prometheus 2.40 or newer with the native histograms feature enabled")
+	labelSchema := flag.String("label-schema", string(casexporter.LabelSchemaLegacy), "Identity labels of the series: 'legacy' (device and id, whose meaning depends on the metric) or 'v2' (cache_id and cache_device on every cache series, plus core_id and exported_object on the core series)")
+	gomaxprocs := flag.Int("gomaxprocs", 0, "Maximum number of CPUs the exporter can use simultaneously, like GOMAXPROCS. If 0, the GOMAXPROCS environment variable or the number of CPUs is used")
+	memlimit := flag.String("memlimit", "", "Soft memory limit of the exporter, like GOMEMLIMIT (e.g. 64MiB). If empty, the GOMEMLIMIT environment variable or no limit is used")
+	adminTokenFile := flag.String("admin-token-file", "", "Path of the file with the bearer token of the maintenance endpoints (/api/v1/pause, /api/v1/resume, /api/v1/caches/<id>/cache-mode and /api/v1/fault-inject). If empty, they aren't served")
+	readOnly := flag.Bool("read-only", true, "Refuse the admin actions that change the caches (e.g. /api/v1/caches/<id>/cache-mode), so the exporter can't modify the storage of the host")
+	pauseSignal := flag.String("pause-signal", "", "Signal that pauses the extraction, like /api/v1/pause, for the maintenance scripts that can't use the HTTP endpoints: SIGHUP, SIGWINCH, SIGTSTP, SIGTTIN or SIGTTOU. If empty, no signal pauses it")
+	resumeSignal := flag.String("resume-signal", "", "Signal that resumes the extraction paused, like /api/v1/resume. It takes the same signals as -pause-signal. If empty, no signal resumes it")
+	logFieldsFlag := flag.String("log-fields", "", "Comma separated list of name=value fields added to every log record (e.g. environment=production,role=hypervisor), on top of the log_fields of the configuration file")
+	auditLog := flag.String("audit-log", "", "Path of the append-only log where the admin actions (e.g. pausing the extraction) are recorded as JSON lines. If empty, they're only logged and counted")
+	tlsCertFile := flag.String("tls-cert-file", "", "Path of the PEM certificate (with the intermediate certificates, if any) the HTTP servers use, reloaded when it changes. If empty, they use plain HTTP")
+	tlsKeyFile := flag.String("tls-key-file", "", "Path of the PEM private key of the certificate of -tls-cert-file, reloaded when it changes")
+	acmeDomains := flag.String("acme-domains", "", "Comma separated list of the domains of the certificate the HTTP servers use, obtained and renewed automatically through ACME, instead of -tls-cert-file. If empty, ACME isn't used")
+	acmeEmail := flag.String("acme-email", "", "Contact email of the ACME account")
+	acmeDirectoryURL := flag.String("acme-directory-url", "", "URL of the directory of the ACME CA (e.g. an internal one). If empty, Let's Encrypt is used")
+	acmeCacheDir := flag.String("acme-cache-dir", http.DefaultACMECacheDir, "Directory where the ACME account and certificates are stored")
+	acmeHTTPAddr := flag.String("acme-http-addr", "", "Address to listen for the ACME HTTP-01 challenges (e.g. :80). If empty, only the TLS-ALPN-01 challenge is answered, which requires the CA to reach the listeners at the port 443")
+	allowedCIDRs := flag.String("allowed-cidrs", "", "Comma separated list of the client ranges (e.g. 10.0.0.0/8,::1) allowed to use the HTTP servers, for the hosts that can't run a firewall. The rest get a 403. If empty, all the clients are allowed")
+	proxyProtocolCIDRs := flag.String("proxy-protocol-cidrs", "", "Comma separated list of the ranges of the TCP load balancers in front of the exporter, whose connections can start with a PROXY protocol (v1 or v2) header with the address of the client, used by -allowed-cidrs and the logs. If empty, the header isn't accepted")
+	mqttBroker := flag.String("mqtt-broker", "", "URL of the MQTT broker the state of every cache is published to after each extraction (e.g. tcp://broker:1883 or ssl://broker:8883), for edge deployments without Prometheus. If empty, it's not published")
+	mqttTopicPrefix := flag.String("mqtt-topic-prefix", "", "Prefix of the MQTT topics, followed by /<cache id> and /status. If empty, cas-exporter/<hostname> is used")
+	mqttClientID := flag.String("mqtt-client-id", "", "Client ID of the MQTT connection. If empty, cas-exporter-<hostname> is used, followed by the shard")
+	mqttUsername := flag.String("mqtt-username", "", "Username of the MQTT connection")
+	mqttPasswordFile := flag.String("mqtt-password-file", "", "Path of the file with the password of the MQTT connection")
+	mqttQoS := flag.Int("mqtt-qos", 1, "Quality of service of the MQTT messages (0, 1 or 2). With 1 or 2, the messages published while the broker is unreachable are delivered once it reconnects")
+	engineURL := flag.String("engine-url", "", "URL of the isard-vdi engine endpoint a summary of the health of the caches is posted to periodically, so the scheduler can avoid the hosts whose caches are saturated or degraded. If empty, it's not posted")
+	engineTokenFile := flag.String("engine-token-file", "", "Path of the file with the bearer token of the isard-vdi engine requests")
+	engineInterval := flag.Duration("engine-interval", 30*time.Second, "Interval between the cache health summaries posted to the isard-vdi engine")
+	heartbeatURL := flag.String("heartbeat-url", "", "URL pinged (GET) after the extractions that have fully succeeded, as a dead man's switch of a monitoring service like healthchecks.io, which alerts if the pings stop. If empty, it's not pinged")
+	heartbeatInterval := flag.Duration("heartbeat-interval", time.Minute, "Minimum interval between the heartbeat pings. If 0, every successful extraction is pinged")
+	zabbixServer := flag.String("zabbix-server", "", "Address of the Zabbix server or proxy the stats of every cache are sent to after each extraction, with the sender protocol (e.g. zabbix:10051). The host and the item keys are set in the zabbix section of the configuration file. If empty, they're not sent")
+	snmpAgentXAddress := flag.String("snmp-agentx-address", "", "Address of the AgentX socket of the SNMP master agent (e.g. /var/agentx/master, or tcp:localhost:705) the headline stats of the caches are exposed through, under the CAS-EXPORTER-MIB. If empty, they're not exposed")
+	snmpBaseOID := flag.String("snmp-base-oid", snmp.DefaultBaseOID, "OID of the subtree of the CAS-EXPORTER-MIB registered in the SNMP master agent")
+	faultInject := flag.Bool("fault-inject", false, "Enable the /api/v1/fault-inject admin endpoint, which makes the casadm commands time out, return output that can't be parsed or fail as if the caches were missing on demand, to rehearse the alerting end to end. Requires -admin-token-file. Not meant for production")
+	dev := flag.Bool("dev", false, "Enable the development endpoints, like /fixtures, which returns the metrics extracted from the posted casadm output. Not meant for production")
+	maxLabelCombinations := flag.Int("max-label-combinations", 0, "Maximum number of distinct cache, core and device label combinations exposed. The rest are aggregated into an 'other' series. If 0, there's no limit")
+
+	// The completion scripts are generated from the flags, so they have to be defined
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		os.Exit(completionCmd(os.Args[2:], flag.CommandLine))
+	}
 
 	flag.Parse()
 
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	})))
+	slog.SetDefault(newLogger(nil))
+
+	cfg := &config.Config{}
+	if *configPath != "" {
+		var err error
+		cfg, err = config.Load(*configPath)
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err != nil {
+			slog.Error("load config",
+				slog.String("config", *configPath),
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+	}
+
+	// The profile sets the flags, so it has to be applied before using them
+	profile := &config.Profile{}
+	if *profileName != "" {
+		var err error
+		profile, err = cfg.Profile(*profileName)
+		if err == nil {
+			err = applyProfileFlags(profile)
+		}
+		if err != nil {
+			slog.Error("apply profile",
+				slog.String("profile", *profileName),
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+	}
+
+	// The fields can be set in the configuration, the profile and the flags, so the logger
+	// is replaced once all of them are known
+	fields, err := logFields(cfg.LogFields, *logFieldsFlag)
+	if err != nil {
+		slog.Error("parse log fields",
+			slog.String("err", err.Error()),
+		)
+		os.Exit(1)
+	}
+	slog.SetDefault(newLogger(fields))
+
+	if *profileName != "" {
+		slog.Info("using profile",
+			slog.String("profile", *profileName),
+		)
+	}
+
+	flags := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+
+	if err := applyResourceLimits(*gomaxprocs, *memlimit); err != nil {
+		slog.Error("apply resource limits",
+			slog.String("err", err.Error()),
+		)
+		os.Exit(1)
+	}
+
+	shard, err := casexporter.ParseShard(*shardFlag)
+	if err != nil {
+		slog.Error("parse shard",
+			slog.String("err", err.Error()),
+		)
+		os.Exit(1)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 
-	c := casexporter.NewCasExporter(*extractionInterval)
+	// Each shard has its own lock, so only the instances of the same shard conflict
+	*lockFile = shard.LockPath(*lockFile)
+
+	var lock *lockfile.Lock
+	if *lockFile != "" {
+		var err error
+		if f, ok := inherited[handoffLock]; ok {
+			lock, err = lockfile.Inherit(*lockFile, f)
+		} else {
+			lock, err = lockfile.Acquire(*lockFile)
+		}
+		if err != nil {
+			msg := "acquire lock file"
+			if errors.Is(err, lockfile.ErrLocked) {
+				msg = "another cas-exporter instance is already running on this host"
+			}
+
+			slog.Error(msg,
+				slog.String("lock_file", *lockFile),
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+		defer lock.Release()
+	}
+
+	var adminToken string
+	if *adminTokenFile != "" {
+		b, err := os.ReadFile(*adminTokenFile)
+		if err == nil && strings.TrimSpace(string(b)) == "" {
+			err = errors.New("the token file is empty")
+		}
+		if err != nil {
+			slog.Error("read admin token",
+				slog.String("admin_token_file", *adminTokenFile),
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+
+		adminToken = strings.TrimSpace(string(b))
+	}
+
+	if *faultInject && adminToken == "" {
+		slog.Error("configure fault injection",
+			slog.String("err", "-fault-inject requires -admin-token-file"),
+		)
+		os.Exit(1)
+	}
+
+	if *historySize < 0 {
+		slog.Error("configure history",
+			slog.String("err", "-history-size can't be negative"),
+		)
+		os.Exit(1)
+	}
+
+	pauseSig, resumeSig, err := parsePauseSignals(*pauseSignal, *resumeSignal)
+	if err != nil {
+		slog.Error("parse pause signals",
+			slog.String("err", err.Error()),
+		)
+		os.Exit(1)
+	}
+
+	if (*tlsCertFile == "") != (*tlsKeyFile == "") {
+		slog.Error("configure tls",
+			slog.String("err", "both -tls-cert-file and -tls-key-file have to be set"),
+		)
+		os.Exit(1)
+	}
+	if *tlsCertFile != "" && *acmeDomains != "" {
+		slog.Error("configure tls",
+			slog.String("err", "-tls-cert-file and -acme-domains can't be used together"),
+		)
+		os.Exit(1)
+	}
+
+	cidrs, err := http.ParseCIDRs(splitList(*allowedCIDRs))
+	if err != nil {
+		slog.Error("parse allowed cidrs",
+			slog.String("err", err.Error()),
+		)
+		os.Exit(1)
+	}
+
+	proxies, err := http.ParseCIDRs(splitList(*proxyProtocolCIDRs))
+	if err != nil {
+		slog.Error("parse proxy protocol cidrs",
+			slog.String("err", err.Error()),
+		)
+		os.Exit(1)
+	}
+
+	audits, err := audit.Open(*auditLog)
+	if err != nil {
+		slog.Error("open audit log",
+			slog.String("audit_log", *auditLog),
+			slog.String("err", err.Error()),
+		)
+		os.Exit(1)
+	}
+	defer audits.Close()
+
+	var cas *casadm.Client
+	if *inputDir != "" {
+		cas = casadm.NewClientWithRunner(&casadm.FileRunner{Dir: *inputDir}, casadm.SchemaOpenCAS)
+
+		slog.Info("reading cas administration output from files",
+			slog.String("dir", *inputDir),
+		)
+
+	} else if *helperSocket != "" {
+		r := &helper.Runner{Socket: *helperSocket}
+		infoCtx, cancelInfo := context.WithTimeout(ctx, 10*time.Second)
+		info, err := r.GetInfo(infoCtx)
+		cancelInfo()
+		if err != nil {
+			slog.Error("connect to the helper",
+				slog.String("socket", *helperSocket),
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+
+		// The helper parses the output of its binary, and sends the rows with the Open CAS
+		// columns, whichever it is
+		cas = casadm.NewClientWithRunner(r, casadm.SchemaOpenCAS)
+
+		slog.Info("running cas administration binary through the helper",
+			slog.String("socket", *helperSocket),
+			slog.String("binary", info.Binary),
+		)
+
+	} else {
+		var err error
+		cas, err = casadm.NewClient(*casadmBinary)
+		if err != nil {
+			slog.Error("create casadm client",
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+
+		slog.Info("using cas administration binary",
+			slog.String("binary", cas.Binary()),
+		)
+	}
+
+	schema, err := casexporter.ParseLabelSchema(*labelSchema)
+	if err != nil {
+		slog.Error("parse label schema",
+			slog.String("err", err.Error()),
+		)
+		os.Exit(1)
+	}
+
+	var baseline *casexporter.Baseline
+	if *baselineName != "" {
+		var err error
+		baseline, err = casexporter.LoadBaseline(*baselineDir, *baselineName)
+		if err != nil {
+			slog.Error("load baseline",
+				slog.String("baseline", *baselineName),
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+	}
+
+	c := casexporter.NewCasExporter(casexporter.Config{
+		ExtractionInterval: *extractionInterval,
+		IdleInterval:       *idleInterval,
+		DiscoveryInterval:  *discoveryInterval,
+		ConfigInterval:     *configInterval,
+		CacheStatsTimeout:  *cacheStatsTimeout,
+		ExtractionDeadline: *extractionDeadline,
+
+		NewCacheCheckInterval: *newCacheCheckInterval,
+		CasctlConfig:          *casctlConfig,
+		MetricTTL:             *metricTTL,
+		HistorySize:           *historySize,
+		AnomalyZScore:         *anomalyZScore,
+		AnomalyWindow:         *anomalyWindow,
+		PressureWindow:        *pressureWindow,
+		Hooks:                 cfg.Hooks,
+		Baseline:              baseline,
+
+		DirtyThreshold:       cfg.DirtyThreshold,
+		CacheDirtyThresholds: cfg.DirtyThresholds(),
+		StoragePools:         cfg.StoragePools,
+		Lock:                 lock,
+		SystemdUnits:         splitList(*systemdUnits),
+		LibvirtURI:           *libvirtURI,
+		LabelSchema:          schema,
+		Shard:                shard,
+		NativeHistograms:     *nativeHistograms,
+		ConstLabels:          profile.Labels,
+		SampleTimestamps:     *sampleTimestamps,
+	}, cas)
+
+	for _, ec := range cfg.ExecCollectors {
+		c.RegisterCollector(casexporter.NewExecCollector(ec), ec.Interval)
+	}
+
+	// The collectors of the profile are only known once all of them are registered
+	if err := c.ValidateCollectors(profile.Collectors); err != nil {
+		slog.Error("apply profile",
+			slog.String("profile", *profileName),
+			slog.String("err", err.Error()),
+		)
+		os.Exit(1)
+	}
+
+	// The outputs have to subscribe to the extractions before they're started
+	collectors := []prometheus.Collector{}
+	if *mqttBroker != "" {
+		mqttCfg, err := mqttConfig(*mqttBroker, *mqttTopicPrefix, *mqttClientID, *mqttUsername, *mqttPasswordFile, *mqttQoS, shard)
+		if err != nil {
+			slog.Error("configure mqtt",
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+
+		p := mqtt.NewPublisher(mqttCfg, c)
+		collectors = append(collectors, p)
+
+		go p.Start(ctx, &wg)
+		wg.Add(1)
+	}
+
+	if len(cfg.AlertRules) != 0 {
+		a := alerts.New(cfg.AlertRules, cfg.Webhooks, c)
+		collectors = append(collectors, a)
+
+		go a.Start(ctx, &wg)
+		wg.Add(1)
+	}
+
+	if *engineURL != "" {
+		engineCfg, err := engineConfig(*engineURL, *engineTokenFile, *engineInterval)
+		if err != nil {
+			slog.Error("configure engine reports",
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+
+		r := engine.NewReporter(engineCfg, c)
+		collectors = append(collectors, r)
+
+		go r.Start(ctx, &wg)
+		wg.Add(1)
+	}
+
+	if *heartbeatURL != "" {
+		heartbeatCfg, err := heartbeatConfig(*heartbeatURL, *heartbeatInterval)
+		if err != nil {
+			slog.Error("configure heartbeat",
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+
+		p := heartbeat.NewPinger(heartbeatCfg, c)
+		collectors = append(collectors, p)
+
+		go p.Start(ctx, &wg)
+		wg.Add(1)
+	}
+
+	if *zabbixServer != "" {
+		zabbixCfg, err := zabbixConfig(*zabbixServer, cfg.Zabbix)
+		if err != nil {
+			slog.Error("configure zabbix",
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+
+		z := zabbix.NewSender(zabbixCfg, c)
+		collectors = append(collectors, z)
+
+		go z.Start(ctx, &wg)
+		wg.Add(1)
+	}
+
+	if *snmpAgentXAddress != "" {
+		snmpCfg, err := snmpConfig(*snmpAgentXAddress, *snmpBaseOID)
+		if err != nil {
+			slog.Error("configure snmp",
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+
+		a := snmp.NewSubagent(snmpCfg, c)
+		collectors = append(collectors, a)
+
+		go a.Start(ctx, &wg)
+		wg.Add(1)
+	}
 
 	go c.Start(ctx, &wg)
 	wg.Add(1)
 
+	// During a handoff, the previous process stops once this one has metrics to serve
+	if len(inherited) != 0 {
+		go func() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.Extracted():
+			}
+
+			if err := handoff.Ready(inherited); err != nil {
+				slog.Error("notify handoff ready",
+					slog.String("err", err.Error()),
+				)
+			}
+		}()
+	}
+
 	http := http.ExporterServer{
-		Addr:        *addr,
-		CasExporter: c,
+		Addr:                 *addr,
+		CasExporter:          c,
+		MaxLabelCombinations: *maxLabelCombinations,
+		Config:               flags,
+		TelemetryAddr:        *telemetryAddr,
+		DefaultCollectors:    profile.Collectors,
+		AdminToken:           adminToken,
+		ReadOnly:             *readOnly,
+		FaultInjection:       *faultInject,
+		Audit:                audits,
+		Dev:                  *dev,
+		TLSCertFile:          *tlsCertFile,
+		TLSKeyFile:           *tlsKeyFile,
+		AllowedCIDRs:         cidrs,
+		ProxyProtocolCIDRs:   proxies,
+		Collectors:           collectors,
+		Inherited:            inherited,
+		ACME: http.ACMEConfig{
+			Domains:      splitList(*acmeDomains),
+			Email:        *acmeEmail,
+			DirectoryURL: *acmeDirectoryURL,
+			CacheDir:     *acmeCacheDir,
+			HTTPAddr:     *acmeHTTPAddr,
+		},
 	}
 
 	go http.Serve(ctx, &wg)
 	wg.Add(1)
 
+	// The pause and resume signals are only handled if they're set, since catching them
+	// changes what they do (e.g. SIGTSTP no longer suspends the process in a terminal)
+	pause := make(chan os.Signal, 1)
+	for _, sig := range []os.Signal{pauseSig, resumeSig} {
+		if sig != nil {
+			signal.Notify(pause, sig)
+		}
+	}
+	go func() {
+		for sig := range pause {
+			action, name := "resume", *resumeSignal
+			if sig == pauseSig {
+				action, name = "pause", *pauseSignal
+				c.Pause(0)
+			} else {
+				c.Resume()
+			}
+
+			audits.Record(audit.Entry{
+				Action:  action,
+				Client:  "signal " + strings.ToUpper(name),
+				Outcome: audit.OutcomeSuccess,
+			})
+		}
+	}()
+
+	dump := make(chan os.Signal, 1)
+	signal.Notify(dump, syscall.SIGUSR1)
+	go func() {
+		for range dump {
+			c.Dump()
+		}
+	}()
+
+	// SIGUSR2 hands off the listeners and the lock to a new process (e.g. after upgrading
+	// the binary), stopping this one once it's ready, so no scrape is dropped
+	handoffs := make(chan os.Signal, 1)
+	signal.Notify(handoffs, syscall.SIGUSR2)
+	handedOff := make(chan struct{})
+	go func() {
+		for range handoffs {
+			e := audit.Entry{
+				Action:  "handoff",
+				Client:  "signal SIGUSR2",
+				Outcome: audit.OutcomeSuccess,
+			}
+
+			err := handOff(&http, lock)
+			if err != nil {
+				slog.Error("hand off",
+					slog.String("err", err.Error()),
+				)
+
+				e.Outcome, e.Error = audit.OutcomeFailure, err.Error()
+			}
+			audits.Record(e)
+
+			if err == nil {
+				close(handedOff)
+				return
+			}
+		}
+	}()
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
 
-	<-stop
-	fmt.Println("")
+	select {
+	case <-stop:
+		fmt.Println("")
+	case <-handedOff:
+	}
 	slog.Info("stopping service")
 
 	cancel()
 
 	wg.Wait()
 }
+
+// splitList splits a comma separated list flag, ignoring the empty items
+func splitList(s string) []string {
+	items := []string{}
+	for _, i := range strings.Split(s, ",") {
+		if i = strings.TrimSpace(i); i != "" {
+			items = append(items, i)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+	"github.com/isard-vdi/CAS_Exporter/transport/mqtt"
+)
+
+// mqttConfig returns the configuration of the MQTT publisher of the flags. The defaults of
+// the topic prefix and the client ID are based on the hostname, and the client ID has the
+// shard too, since the broker disconnects the clients with the same ID
+func mqttConfig(broker, topicPrefix, clientID, username, passwordFile string, qos int, shard casexporter.Shard) (mqtt.Config, error) {
+	if qos < 0 || qos > 2 {
+		return mqtt.Config{}, fmt.Errorf("invalid qos %d: must be 0, 1 or 2", qos)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return mqtt.Config{}, fmt.Errorf("get hostname: %w", err)
+	}
+
+	if topicPrefix == "" {
+		topicPrefix = "cas-exporter/" + hostname
+	}
+	topicPrefix = strings.TrimSuffix(topicPrefix, "/")
+
+	if clientID == "" {
+		clientID = "cas-exporter-" + hostname
+		if shard.Count != 0 {
+			clientID += fmt.Sprintf("-shard-%d-of-%d", shard.Index, shard.Count)
+		}
+	}
+
+	var password string
+	if passwordFile != "" {
+		b, err := os.ReadFile(passwordFile)
+		if err == nil && strings.TrimSpace(string(b)) == "" {
+			err = errors.New("the password file is empty")
+		}
+		if err != nil {
+			return mqtt.Config{}, fmt.Errorf("read password: %w", err)
+		}
+
+		password = strings.TrimSpace(string(b))
+	}
+
+	return mqtt.Config{
+		Broker:      broker,
+		ClientID:    clientID,
+		Username:    username,
+		Password:    password,
+		TopicPrefix: topicPrefix,
+		QoS:         byte(qos),
+	}, nil
+}
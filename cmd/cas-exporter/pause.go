@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// pauseSignals are the signals that can pause and resume the extraction, the ones the
+// exporter doesn't handle otherwise. SIGCONT isn't one of them, since the process also gets
+// it when resumed after being stopped (e.g. by a debugger or the job control of a shell)
+var pauseSignals = map[string]syscall.Signal{
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGWINCH": syscall.SIGWINCH,
+	"SIGTSTP":  syscall.SIGTSTP,
+	"SIGTTIN":  syscall.SIGTTIN,
+	"SIGTTOU":  syscall.SIGTTOU,
+}
+
+// parsePauseSignals returns the signals of -pause-signal and -resume-signal, which are nil
+// if they're empty
+func parsePauseSignals(pause, resume string) (os.Signal, os.Signal, error) {
+	if pause != "" && strings.EqualFold(pause, resume) {
+		return nil, nil, errors.New("the pause and resume signals have to be different")
+	}
+
+	pauseSig, err := parsePauseSignal(pause)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resumeSig, err := parsePauseSignal(resume)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pauseSig, resumeSig, nil
+}
+
+func parsePauseSignal(name string) (os.Signal, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	sig, ok := pauseSignals[strings.ToUpper(name)]
+	if !ok {
+		names := []string{}
+		for n := range pauseSignals {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+
+		return nil, fmt.Errorf("invalid signal '%s', available signals: %s", name, strings.Join(names, ", "))
+	}
+
+	return sig, nil
+}
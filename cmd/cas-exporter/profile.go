@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+
+	"github.com/isard-vdi/CAS_Exporter/config"
+)
+
+// applyProfileFlags sets the flags of the profile that haven't been set in the command line
+func applyProfileFlags(p *config.Profile) error {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	names := make([]string, 0, len(p.Flags))
+	for name := range p.Flags {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		switch {
+		case name == "config" || name == "profile":
+			return fmt.Errorf("flag '%s' can't be set in a profile", name)
+		case flag.Lookup(name) == nil:
+			return fmt.Errorf("unknown flag '%s'", name)
+		case set[name]:
+			continue
+		}
+
+		if err := flag.Set(name, p.Flags[name]); err != nil {
+			return fmt.Errorf("set flag '%s': %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/isard-vdi/CAS_Exporter/transport/snmp"
+)
+
+// snmpConfig returns the configuration of the AgentX subagent of the flags. The address is
+// a unix socket path, or tcp:host:port
+func snmpConfig(address, baseOID string) (snmp.Config, error) {
+	network := "unix"
+	if a, ok := strings.CutPrefix(address, "tcp:"); ok {
+		network, address = "tcp", a
+	} else {
+		address = strings.TrimPrefix(address, "unix:")
+	}
+
+	oid, err := snmp.ParseOID(baseOID)
+	if err == nil && len(oid) < 2 {
+		err = errors.New("it has to have at least two sub-identifiers")
+	}
+	if err != nil {
+		return snmp.Config{}, fmt.Errorf("invalid base oid '%s': %w", baseOID, err)
+	}
+
+	return snmp.Config{
+		Network: network,
+		Address: address,
+		BaseOID: oid,
+	}, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/isard-vdi/CAS_Exporter/casctl"
+	"github.com/isard-vdi/CAS_Exporter/config"
+)
+
+// validateConfigOptions are the options of the validate-config subcommand, set by its flags
+type validateConfigOptions struct {
+	configPath   string
+	casctlConfig string
+}
+
+// newValidateConfigFlags returns the flags of the validate-config subcommand, which set the options
+func newValidateConfigFlags(o *validateConfigOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s validate-config [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&o.configPath, "config", "", "Path of the configuration file (YAML)")
+	fs.StringVar(&o.casctlConfig, "casctl-config", "", "Path of the casctl configuration referenced with -casctl-config, if any")
+
+	return fs
+}
+
+// validateConfigCmd parses and validates the configuration files, for CI and configuration
+// management pipelines
+func validateConfigCmd(args []string) int {
+	o := validateConfigOptions{}
+	fs := newValidateConfigFlags(&o)
+
+	fs.Parse(args)
+
+	if o.configPath == "" && o.casctlConfig == "" {
+		fmt.Fprintln(os.Stderr, "at least one of -config or -casctl-config is required")
+		return 2
+	}
+
+	problems := 0
+	report := func(path string, err error) {
+		if err == nil {
+			fmt.Printf("%s: ok\n", path)
+			return
+		}
+
+		for _, e := range unjoin(err) {
+			fmt.Printf("%s: %v\n", path, e)
+			problems++
+		}
+	}
+
+	if o.configPath != "" {
+		cfg, err := config.LoadStrict(o.configPath)
+		if err == nil {
+			err = cfg.Validate()
+		}
+
+		report(o.configPath, err)
+	}
+
+	if o.casctlConfig != "" {
+		_, err := casctl.Load(o.casctlConfig)
+		report(o.casctlConfig, err)
+	}
+
+	if problems != 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// unjoin returns the errors joined with errors.Join, or the error itself. The yaml errors
+// with several problems are split by line
+func unjoin(err error) []error {
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		return joined.Unwrap()
+	}
+
+	msg := err.Error()
+	if lines := strings.Split(msg, "\n"); len(lines) > 1 {
+		// The first line is the "parse config: yaml: unmarshal errors:" header
+		errs := []error{}
+		for _, l := range lines[1:] {
+			errs = append(errs, fmt.Errorf("%s: %s", strings.TrimSuffix(lines[0], ":"), strings.TrimSpace(l)))
+		}
+
+		return errs
+	}
+
+	return []error{err}
+}
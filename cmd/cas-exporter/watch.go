@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+// clearScreen moves the cursor to the top left and clears the terminal
+const clearScreen = "\033[H\033[2J"
+
+// watchOptions are the options of the watch subcommand, set by its flags
+type watchOptions struct {
+	interval     time.Duration
+	casadmBinary string
+}
+
+// newWatchFlags returns the flags of the watch subcommand, which set the options
+func newWatchFlags(o *watchOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s watch [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.DurationVar(&o.interval, "interval", 2*time.Second, "Interval between refreshes")
+	fs.StringVar(&o.casadmBinary, "casadm-binary", "", "Path of the CAS administration binary. If empty, casadm is used, falling back to the legacy intelcas")
+
+	return fs
+}
+
+// watchCmd renders a live table of the caches and their cores in the terminal
+func watchCmd(args []string) int {
+	o := watchOptions{}
+	fs := newWatchFlags(&o)
+
+	fs.Parse(args)
+
+	cas, err := casadm.NewClient(o.casadmBinary)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create casadm client: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var prev map[uint16]*casadm.CacheStats
+	for {
+		caches, stats, err := watchExtract(ctx, cas)
+		if ctx.Err() != nil {
+			return 0
+		}
+
+		fmt.Print(clearScreen)
+		fmt.Printf("cas-exporter watch - %s - every %s\n\n", time.Now().Format(time.TimeOnly), o.interval)
+
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+		} else {
+			watchRender(caches, stats, prev)
+			prev = stats
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(o.interval):
+		}
+	}
+}
+
+func watchExtract(ctx context.Context, cas *casadm.Client) ([]*casadm.Cache, map[uint16]*casadm.CacheStats, error) {
+	caches, err := cas.ListCaches(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats := map[uint16]*casadm.CacheStats{}
+	for _, c := range caches {
+		if c.Type != casadm.TypeCache {
+			continue
+		}
+
+		// Show what parses of the caches that aren't running
+		s, err := cas.GetCacheStats(ctx, c.ID)
+		var partial *casadm.PartialStatsError
+		if err != nil && !errors.As(err, &partial) {
+			return nil, nil, fmt.Errorf("cache %d: %w", c.ID, err)
+		}
+
+		stats[c.ID] = s
+	}
+
+	return caches, stats, nil
+}
+
+// watchRender prints the table. The hit ratio is the one since the previous refresh, or the
+// cumulative one on the first refresh
+func watchRender(caches []*casadm.Cache, stats, prev map[uint16]*casadm.CacheStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "CACHE\tCORE\tDEVICE\tSTATUS\tMODE\tOCCUPANCY\tDIRTY\tHIT RATIO\tERRORS")
+	for _, c := range caches {
+		if c.Type == casadm.TypeCore {
+			fmt.Fprintf(w, "\t%d\t%s\t%s\t\t\t\t\t\n", c.ID, c.Disk, c.Status)
+			continue
+		}
+
+		s, ok := stats[c.ID]
+		if !ok {
+			fmt.Fprintf(w, "%d\t\t%s\t%s\t%s\t\t\t\t\n", c.ID, c.Disk, c.Status, c.WritePolicy)
+			continue
+		}
+
+		hitRatio := s.ReadHitsPercent
+		if p, ok := prev[c.ID]; ok {
+			hits := s.ReadHitsRequests + s.WriteHitsRequests - p.ReadHitsRequests - p.WriteHitsRequests
+			requests := s.ReadTotalRequests + s.WriteTotalRequests - p.ReadTotalRequests - p.WriteTotalRequests
+			if requests > 0 && hits >= 0 {
+				hitRatio = float64(hits) / float64(requests) * 100
+			}
+		}
+
+		fmt.Fprintf(w, "%d\t\t%s\t%s\t%s\t%.1f%%\t%.1f%%\t%.1f%%\t%d\n",
+			c.ID, c.Disk, c.Status, c.WritePolicy,
+			s.OccupancyPercent, s.DirtyPercent, hitRatio, s.TotalErrorsRequests,
+		)
+	}
+}
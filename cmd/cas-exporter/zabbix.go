@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/isard-vdi/CAS_Exporter/config"
+	"github.com/isard-vdi/CAS_Exporter/transport/zabbix"
+)
+
+// zabbixConfig returns the configuration of the Zabbix sender of the flag and the
+// configuration file. The port of the server defaults to the trapper one, and the host to
+// the hostname
+func zabbixConfig(server string, cfg *config.Zabbix) (zabbix.Config, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, zabbix.DefaultPort)
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return zabbix.Config{}, fmt.Errorf("invalid server '%s': %w", server, err)
+		}
+	}
+
+	if cfg == nil {
+		cfg = &config.Zabbix{}
+	}
+
+	host := cfg.Host
+	if host == "" {
+		var err error
+		host, err = os.Hostname()
+		if err != nil {
+			return zabbix.Config{}, fmt.Errorf("get hostname: %w", err)
+		}
+	}
+
+	keys := cfg.Keys
+	if len(keys) == 0 {
+		keys = zabbix.DefaultKeys()
+	}
+
+	return zabbix.Config{
+		Server: server,
+		Host:   host,
+		Keys:   keys,
+	}, nil
+}
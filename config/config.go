@@ -0,0 +1,572 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the exporter configuration file, with the settings that don't fit in flags
+type Config struct {
+	// DirtyThreshold is the dirty percentage above which the caches are flagged. If it's 0,
+	// the caches without their own threshold aren't flagged
+	DirtyThreshold float64 `yaml:"dirty_threshold"`
+	// Caches are the settings of each cache, indexed by cache ID
+	Caches map[uint16]*Cache `yaml:"caches"`
+	// ExecCollectors are the external commands whose output is merged into the metrics
+	ExecCollectors []*ExecCollector `yaml:"exec_collectors"`
+	// Hooks are the commands run before and after every extraction
+	Hooks []*Hook `yaml:"hooks"`
+	// Profiles are named bundles of settings for the different roles of the fleet (e.g.
+	// hypervisor, backup-node), indexed by name
+	Profiles map[string]*Profile `yaml:"profiles"`
+	// LogFields are added to every log record (e.g. environment, cluster), so the log
+	// pipelines can route the logs without parsing them
+	LogFields map[string]string `yaml:"log_fields"`
+	// AlertRules are thresholds on the stats of the caches that fire the webhooks, for the
+	// sites without an Alertmanager
+	AlertRules []*AlertRule `yaml:"alert_rules"`
+	// Webhooks are the HTTP endpoints notified when the alert rules fire and resolve
+	Webhooks []*Webhook `yaml:"webhooks"`
+	// StoragePools are the isard-vdi storage pools and tenants of the cache and core devices,
+	// indexed by device, which are added as labels to their series for chargeback
+	StoragePools map[string]*StoragePool `yaml:"storage_pools"`
+	// Zabbix maps the stats of the caches to the items of the Zabbix sender output
+	Zabbix *Zabbix `yaml:"zabbix"`
+}
+
+// Profile is a named bundle of settings, selected at startup
+type Profile struct {
+	// Flags are the values of the command line flags (e.g. intervals), indexed by flag name.
+	// The flags set in the command line take precedence
+	Flags map[string]string `yaml:"flags"`
+	// Collectors are the collectors exposed when the scrape doesn't select them with collect[]
+	Collectors []string `yaml:"collectors"`
+	// Labels are added to all the exposed series
+	Labels map[string]string `yaml:"labels"`
+	// ExecCollectors are added to the ones of the configuration
+	ExecCollectors []*ExecCollector `yaml:"exec_collectors"`
+	// LogFields are added to the ones of the configuration, overriding them
+	LogFields map[string]string `yaml:"log_fields"`
+}
+
+// Formats of the output of the exec collectors
+const (
+	ExecFormatPrometheus = "prometheus"
+	ExecFormatCSV        = "csv"
+)
+
+// DefaultExecPrefix is the prefix of the metrics of the exec collectors without one
+const DefaultExecPrefix = "ocf_exec_"
+
+// ExecCollector is an external command whose standard output, in the Prometheus text
+// format or CSV, is merged into the metrics
+type ExecCollector struct {
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command"`
+	// Format is the format of the output, prometheus (default) or csv
+	Format string `yaml:"format"`
+	// Prefix is prepended to the names of the metrics. If it's empty, DefaultExecPrefix is used
+	Prefix string `yaml:"prefix"`
+	// Interval is the interval between runs. If it's 0, it runs on every extraction
+	Interval time.Duration `yaml:"interval"`
+	// Timeout is the maximum time the command can take. If it's 0, there's no limit
+	Timeout time.Duration `yaml:"timeout"`
+	// Labels are the CSV columns used as labels
+	Labels []string `yaml:"labels"`
+	// Values are the metric names of the CSV columns exported as values, indexed by column
+	Values map[string]string `yaml:"values"`
+}
+
+// Stages of the extraction the hooks run at
+const (
+	HookPre  = "pre"
+	HookPost = "post"
+)
+
+// Hook is a command run before or after every extraction, in lockstep with it (e.g. to
+// refresh the sandbox casadm runs in, or to touch a heartbeat file)
+type Hook struct {
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command"`
+	// Stage is when the command runs, pre or post
+	Stage string `yaml:"stage"`
+	// Timeout is the maximum time the command can take. If it's unset, it's the extraction
+	// deadline, or the extraction interval if there's none, so a hung hook doesn't block the
+	// extractions
+	Timeout *time.Duration `yaml:"timeout"`
+}
+
+// AlertStats are the stats of the caches the alert rules can use, named like in the
+// inventory API
+var AlertStats = []string{
+	"occupancy_percent",
+	"dirty_percent",
+	"read_hits_percent",
+	"write_hits_percent",
+	"cache_errors",
+	"core_errors",
+	"total_requests",
+	"serviced_requests",
+	"dirty_for_seconds",
+	"inactive_cores",
+}
+
+// DefaultZabbixKey is the key of the items of the stats without one, where {stat} is the
+// name of the stat
+const DefaultZabbixKey = "cas.{stat}[{cache_id}]"
+
+// Zabbix is the host and the item keys the stats of the caches are sent to Zabbix with
+type Zabbix struct {
+	// Host is the name of the host in Zabbix. If it's empty, the hostname is used
+	Host string `yaml:"host"`
+	// Keys are the item keys of the stats sent, one of AlertStats, where {cache_id} and
+	// {device} are replaced by the ones of the cache. If it's empty, all the stats are sent
+	// with DefaultZabbixKey
+	Keys map[string]string `yaml:"keys"`
+}
+
+// AlertRule fires while a stat of a cache is above or below a threshold, or increasing
+// (e.g. the errors), for at least a while
+type AlertRule struct {
+	Name string `yaml:"name"`
+	// Stat is one of AlertStats
+	Stat  string   `yaml:"stat"`
+	Above *float64 `yaml:"above"`
+	Below *float64 `yaml:"below"`
+	// Increasing fires while the stat increases between extractions
+	Increasing bool `yaml:"increasing"`
+	// For is how long the condition has to be met before firing. If it's 0, it fires on the
+	// first extraction that meets it
+	For time.Duration `yaml:"for"`
+}
+
+// Webhook is an HTTP endpoint the alerts are posted to, as JSON
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Headers are added to the requests (e.g. Authorization)
+	Headers map[string]string `yaml:"headers"`
+}
+
+// StoragePool is the isard-vdi storage pool and tenant of a device
+type StoragePool struct {
+	Pool   string `yaml:"pool"`
+	Tenant string `yaml:"tenant"`
+}
+
+type Cache struct {
+	// DirtyThreshold overrides the global dirty threshold for the cache
+	DirtyThreshold float64 `yaml:"dirty_threshold"`
+}
+
+func Load(path string) (*Config, error) {
+	return load(path, false)
+}
+
+// LoadStrict loads the configuration failing on the unknown keys, which are usually typos
+func LoadStrict(path string) (*Config, error) {
+	return load(path, true)
+}
+
+func load(path string, strict bool) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	cfg := &Config{}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(strict)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the semantics of the configuration, returning all the problems found
+func (c *Config) Validate() error {
+	errs := []error{}
+	if err := validateThreshold(c.DirtyThreshold); err != nil {
+		errs = append(errs, fmt.Errorf("dirty_threshold: %w", err))
+	}
+
+	ids := make([]uint16, 0, len(c.Caches))
+	for id := range c.Caches {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	for _, id := range ids {
+		cache := c.Caches[id]
+		if cache == nil {
+			continue
+		}
+
+		if id == 0 {
+			errs = append(errs, errors.New("caches: cache ID 0 is invalid, the IDs start at 1"))
+		}
+
+		if err := validateThreshold(cache.DirtyThreshold); err != nil {
+			errs = append(errs, fmt.Errorf("caches.%d.dirty_threshold: %w", id, err))
+		}
+	}
+
+	names := map[string]bool{}
+	errs = append(errs, validateExecCollectors("exec_collectors", c.ExecCollectors, names)...)
+	errs = append(errs, validateLogFields("log_fields", c.LogFields)...)
+	errs = append(errs, c.validateHooks()...)
+	errs = append(errs, c.validateAlerts()...)
+	errs = append(errs, c.validateStoragePools()...)
+	errs = append(errs, c.validateZabbix()...)
+
+	profiles := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		profiles = append(profiles, name)
+	}
+	slices.Sort(profiles)
+
+	for _, name := range profiles {
+		p := c.Profiles[name]
+		if p == nil {
+			continue
+		}
+
+		for l := range p.Labels {
+			if !labelNameRegexp.MatchString(l) || strings.HasPrefix(l, "__") {
+				errs = append(errs, fmt.Errorf("profiles.%s.labels: '%s' isn't a valid label name", name, l))
+			}
+		}
+
+		errs = append(errs, validateLogFields("profiles."+name+".log_fields", p.LogFields)...)
+
+		// The exec collectors of each profile only clash with the global ones
+		profileNames := maps.Clone(names)
+		errs = append(errs, validateExecCollectors("profiles."+name+".exec_collectors", p.ExecCollectors, profileNames)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateExecCollectors(path string, collectors []*ExecCollector, names map[string]bool) []error {
+	errs := []error{}
+	for i, ec := range collectors {
+		if ec == nil {
+			continue
+		}
+
+		for _, err := range ec.validate() {
+			errs = append(errs, fmt.Errorf("%s.%d: %w", path, i, err))
+		}
+
+		if ec.Name != "" && names[ec.Name] {
+			errs = append(errs, fmt.Errorf("%s.%d: duplicated name '%s'", path, i, ec.Name))
+		}
+		names[ec.Name] = true
+	}
+
+	return errs
+}
+
+// reservedLogFields are the fields of every log record
+var reservedLogFields = []string{"time", "level", "msg", "source"}
+
+// ValidateLogField checks that the log field doesn't clash with the fields of every record
+func ValidateLogField(name string) error {
+	if name == "" {
+		return errors.New("the field name can't be empty")
+	}
+
+	if slices.Contains(reservedLogFields, name) {
+		return fmt.Errorf("'%s' is a reserved field, the reserved fields are: %s", name, strings.Join(reservedLogFields, ", "))
+	}
+
+	return nil
+}
+
+func validateLogFields(path string, fields map[string]string) []error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	errs := []error{}
+	for _, name := range names {
+		if err := ValidateLogField(name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+
+	return errs
+}
+
+var (
+	metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+func (ec *ExecCollector) validate() []error {
+	errs := []error{}
+	if ec.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+
+	if len(ec.Command) == 0 {
+		errs = append(errs, errors.New("command is required"))
+	}
+
+	if ec.Prefix != "" && !metricNameRegexp.MatchString(ec.Prefix) {
+		errs = append(errs, fmt.Errorf("prefix '%s' isn't a valid metric name prefix", ec.Prefix))
+	}
+
+	if ec.Interval < 0 || ec.Timeout < 0 {
+		errs = append(errs, errors.New("interval and timeout can't be negative"))
+	}
+
+	switch ec.Format {
+	case "", ExecFormatPrometheus:
+		if len(ec.Labels) != 0 || len(ec.Values) != 0 {
+			errs = append(errs, errors.New("labels and values are only used with the csv format"))
+		}
+
+	case ExecFormatCSV:
+		if len(ec.Values) == 0 {
+			errs = append(errs, errors.New("values are required with the csv format"))
+		}
+
+		for col, name := range ec.Values {
+			if !metricNameRegexp.MatchString(name) {
+				errs = append(errs, fmt.Errorf("values: metric name '%s' of column '%s' isn't valid", name, col))
+			}
+		}
+
+	default:
+		errs = append(errs, fmt.Errorf("unknown format '%s', available formats: %s, %s", ec.Format, ExecFormatPrometheus, ExecFormatCSV))
+	}
+
+	return errs
+}
+
+func (c *Config) validateHooks() []error {
+	errs := []error{}
+	names := map[string]bool{}
+	for i, h := range c.Hooks {
+		if h == nil {
+			continue
+		}
+
+		if h.Name == "" {
+			errs = append(errs, fmt.Errorf("hooks.%d: name is required", i))
+		} else if names[h.Name] {
+			errs = append(errs, fmt.Errorf("hooks.%d: duplicated name '%s'", i, h.Name))
+		}
+		names[h.Name] = true
+
+		if len(h.Command) == 0 {
+			errs = append(errs, fmt.Errorf("hooks.%d: command is required", i))
+		}
+
+		if h.Stage != HookPre && h.Stage != HookPost {
+			errs = append(errs, fmt.Errorf("hooks.%d: unknown stage '%s', available stages: %s, %s", i, h.Stage, HookPre, HookPost))
+		}
+
+		if h.Timeout != nil && *h.Timeout <= 0 {
+			errs = append(errs, fmt.Errorf("hooks.%d: timeout has to be positive, or unset to use the extraction deadline or interval", i))
+		}
+	}
+
+	return errs
+}
+
+func (c *Config) validateAlerts() []error {
+	errs := []error{}
+	names := map[string]bool{}
+	for i, r := range c.AlertRules {
+		if r == nil {
+			continue
+		}
+
+		for _, err := range r.validate() {
+			errs = append(errs, fmt.Errorf("alert_rules.%d: %w", i, err))
+		}
+
+		if r.Name != "" && names[r.Name] {
+			errs = append(errs, fmt.Errorf("alert_rules.%d: duplicated name '%s'", i, r.Name))
+		}
+		names[r.Name] = true
+	}
+
+	if len(c.AlertRules) != 0 && len(c.Webhooks) == 0 {
+		errs = append(errs, errors.New("alert_rules: at least a webhook is required to notify the alerts"))
+	}
+
+	for i, w := range c.Webhooks {
+		if w == nil {
+			continue
+		}
+
+		u, err := url.Parse(w.URL)
+		if err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+			err = errors.New("must be an http or https URL")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("webhooks.%d: invalid url '%s': %w", i, w.URL, err))
+		}
+	}
+
+	return errs
+}
+
+func (r *AlertRule) validate() []error {
+	errs := []error{}
+	if r.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+
+	if !slices.Contains(AlertStats, r.Stat) {
+		errs = append(errs, fmt.Errorf("unknown stat '%s', available stats: %s", r.Stat, strings.Join(AlertStats, ", ")))
+	}
+
+	conditions := 0
+	for _, set := range []bool{r.Above != nil, r.Below != nil, r.Increasing} {
+		if set {
+			conditions++
+		}
+	}
+	if conditions != 1 {
+		errs = append(errs, errors.New("exactly one of above, below or increasing is required"))
+	}
+
+	if r.For < 0 {
+		errs = append(errs, errors.New("for can't be negative"))
+	}
+
+	return errs
+}
+
+func (c *Config) validateZabbix() []error {
+	if c.Zabbix == nil {
+		return nil
+	}
+
+	stats := make([]string, 0, len(c.Zabbix.Keys))
+	for stat := range c.Zabbix.Keys {
+		stats = append(stats, stat)
+	}
+	slices.Sort(stats)
+
+	errs := []error{}
+	for _, stat := range stats {
+		if !slices.Contains(AlertStats, stat) {
+			errs = append(errs, fmt.Errorf("zabbix.keys: unknown stat '%s', available stats: %s", stat, strings.Join(AlertStats, ", ")))
+		}
+
+		if c.Zabbix.Keys[stat] == "" {
+			errs = append(errs, fmt.Errorf("zabbix.keys.%s: the key can't be empty", stat))
+		}
+	}
+
+	return errs
+}
+
+func (c *Config) validateStoragePools() []error {
+	errs := []error{}
+	devices := make([]string, 0, len(c.StoragePools))
+	for device := range c.StoragePools {
+		devices = append(devices, device)
+	}
+	slices.Sort(devices)
+
+	for _, device := range devices {
+		if !strings.HasPrefix(device, "/") {
+			errs = append(errs, fmt.Errorf("storage_pools.%s: the device must be an absolute path", device))
+		}
+
+		p := c.StoragePools[device]
+		if p == nil || (p.Pool == "" && p.Tenant == "") {
+			errs = append(errs, fmt.Errorf("storage_pools.%s: at least one of pool or tenant is required", device))
+		}
+	}
+
+	if len(c.StoragePools) == 0 {
+		return errs
+	}
+
+	// The labels of the profiles would be duplicated
+	profiles := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		profiles = append(profiles, name)
+	}
+	slices.Sort(profiles)
+
+	for _, name := range profiles {
+		if p := c.Profiles[name]; p != nil {
+			for _, l := range []string{"pool", "tenant"} {
+				if _, ok := p.Labels[l]; ok {
+					errs = append(errs, fmt.Errorf("profiles.%s.labels: '%s' is set by storage_pools", name, l))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+func validateThreshold(t float64) error {
+	if t < 0 || t > 100 {
+		return fmt.Errorf("%g is out of range, it must be a percentage between 0 and 100", t)
+	}
+
+	return nil
+}
+
+// Profile returns the profile, merging its settings into the configuration
+func (c *Config) Profile(name string) (*Profile, error) {
+	p, ok := c.Profiles[name]
+	if !ok || p == nil {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+
+		return nil, fmt.Errorf("unknown profile '%s', available profiles: %s", name, strings.Join(names, ", "))
+	}
+
+	c.ExecCollectors = append(c.ExecCollectors, p.ExecCollectors...)
+
+	if len(p.LogFields) != 0 {
+		fields := maps.Clone(c.LogFields)
+		if fields == nil {
+			fields = map[string]string{}
+		}
+		maps.Copy(fields, p.LogFields)
+		c.LogFields = fields
+	}
+
+	return p, nil
+}
+
+// DirtyThresholds returns the dirty threshold overrides of each cache
+func (c *Config) DirtyThresholds() map[uint16]float64 {
+	thresholds := map[uint16]float64{}
+	for id, cache := range c.Caches {
+		if cache != nil && cache.DirtyThreshold != 0 {
+			thresholds[id] = cache.DirtyThreshold
+		}
+	}
+
+	return thresholds
+}
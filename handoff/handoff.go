@@ -0,0 +1,126 @@
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// env is the environment variable with the names of the files passed to the new process,
+// in the order of their descriptors, starting at 3
+const env = "CAS_EXPORTER_HANDOFF"
+
+// readyName is the name of the pipe the new process writes to once it's ready to take over
+const readyName = "ready"
+
+// Inherited returns the files passed by the previous process, by name. If the process hasn't
+// been started by a handoff, it's empty. It has to be called before opening any file, so
+// their descriptors aren't reused
+func Inherited() map[string]*os.File {
+	names := os.Getenv(env)
+	os.Unsetenv(env)
+
+	files := map[string]*os.File{}
+	if names == "" {
+		return files
+	}
+
+	for i, name := range strings.Split(names, ",") {
+		fd := 3 + i
+
+		// They aren't passed to the processes started by this one (e.g. casadm)
+		syscall.CloseOnExec(fd)
+		files[name] = os.NewFile(uintptr(fd), name)
+	}
+
+	return files
+}
+
+// Ready lets the previous process know this one is ready to take over, so it can stop. If
+// the process hasn't been started by a handoff, it does nothing
+func Ready(inherited map[string]*os.File) error {
+	f, ok := inherited[readyName]
+	if !ok {
+		return nil
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte{'\n'}); err != nil {
+		return fmt.Errorf("notify handoff ready: %w", err)
+	}
+
+	return nil
+}
+
+// Start starts a new process of the executable (which may have been replaced by a new
+// version), with the same arguments, passing it the files, and waits until it's ready to
+// take over, returning its PID. If it isn't ready before the timeout, it's killed
+func Start(files map[string]*os.File, timeout time.Duration) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("get executable: %w", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("create ready pipe: %w", err)
+	}
+	defer r.Close()
+
+	names := make([]string, 0, len(files)+1)
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	extra := make([]*os.File, 0, len(names)+1)
+	for _, name := range names {
+		extra = append(extra, files[name])
+	}
+	names = append(names, readyName)
+	extra = append(extra, w)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env+"="+strings.Join(names, ","))
+	cmd.ExtraFiles = extra
+
+	err = cmd.Start()
+	// The new process has its own copy, so the pipe is closed once it exits
+	w.Close()
+	if err != nil {
+		return 0, fmt.Errorf("start new process: %w", err)
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		if _, err := r.Read(make([]byte, 1)); err != nil {
+			ready <- errors.New("the new process has exited before being ready")
+			return
+		}
+
+		ready <- nil
+	}()
+
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = fmt.Errorf("the new process isn't ready after %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+
+	return pid, nil
+}
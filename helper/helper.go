@@ -0,0 +1,314 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+// DefaultSocket is the unix socket the helper listens on
+const DefaultSocket = "/run/cas-exporter/helper.sock"
+
+// Operations of the requests
+const (
+	opInfo = "info"
+	opRun  = "run"
+)
+
+// Types of the frames of the command responses
+const (
+	frameRow  = 1
+	frameExit = 2
+)
+
+// maxFrameSize is the maximum size of the frames
+const maxFrameSize = 32 << 10
+
+// Kinds of the errors of the commands, which are passed along to the exporter, so it
+// gets the same errors as with the local binary
+const (
+	kindNotInstalled  = "not_installed"
+	kindPermission    = "permission"
+	kindCacheNotFound = "cache_not_found"
+	kindTimeout       = "timeout"
+	kindNotAllowed    = "not_allowed"
+)
+
+// ErrNotAllowed is returned when the helper refuses the command (e.g. changing the cache
+// mode, if it isn't allowed)
+var ErrNotAllowed = errors.New("command not allowed by the helper")
+
+// request is the first line of the connections, which have one request each
+type request struct {
+	Op   string   `json:"op"`
+	Args []string `json:"args,omitempty"`
+}
+
+// Info is the response of the info requests
+type Info struct {
+	// Binary is the path of the CAS administration binary run by the helper
+	Binary string `json:"binary"`
+	// IntelCAS is whether the binary is the legacy intelcas. Its output is parsed by the
+	// helper, so the exporter gets the rows with the Open CAS columns anyway
+	IntelCAS bool `json:"intel_cas"`
+}
+
+// exitStatus is the payload of the exit frame, the last one of the command responses
+type exitStatus struct {
+	Error string `json:"error,omitempty"`
+	Kind  string `json:"kind,omitempty"`
+}
+
+// remoteError is an error of a command run by the helper, which wraps the kind of the error,
+// if it's known
+type remoteError struct {
+	msg  string
+	kind error
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.kind
+}
+
+func (s exitStatus) err() error {
+	if s.Error == "" {
+		return nil
+	}
+
+	var kind error
+	switch s.Kind {
+	case kindNotInstalled:
+		kind = casadm.ErrCasadmNotInstalled
+	case kindPermission:
+		kind = casadm.ErrPermissionDenied
+	case kindCacheNotFound:
+		kind = casadm.ErrCacheNotFound
+	case kindTimeout:
+		kind = casadm.ErrTimeout
+	case kindNotAllowed:
+		kind = ErrNotAllowed
+	}
+
+	return &remoteError{msg: "helper: " + s.Error, kind: kind}
+}
+
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	b := make([]byte, 5, 5+len(payload))
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:], uint32(len(payload)))
+
+	_, err := w.Write(append(b, payload...))
+	return err
+}
+
+// Runner runs the CAS administration commands through the privileged helper, so the exporter
+// doesn't need to be root. The helper parses their output, and the runner returns the rows
+// it sends as CSV with the Open CAS columns, so the client decodes them like the output of
+// casadm (and the fixtures and archives keep working)
+type Runner struct {
+	// Socket is the unix socket of the helper
+	Socket string
+}
+
+// GetInfo returns the information of the helper, which also checks it's reachable
+func (r *Runner) GetInfo(ctx context.Context) (Info, error) {
+	conn, err := r.request(ctx, request{Op: opInfo})
+	if err != nil {
+		return Info{}, err
+	}
+	defer conn.Close()
+
+	// The connection is closed if the context is done
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	info := Info{}
+	if err := json.NewDecoder(conn).Decode(&info); err != nil {
+		return Info{}, fmt.Errorf("read helper info: %w", err)
+	}
+
+	return info, nil
+}
+
+func (r *Runner) Run(ctx context.Context, args ...string) ([]byte, error) {
+	o, err := r.Stream(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	b, rerr := io.ReadAll(o)
+	if err := o.Close(); err != nil {
+		return b, err
+	}
+
+	return b, rerr
+}
+
+func (r *Runner) Stream(ctx context.Context, args ...string) (io.ReadCloser, error) {
+	conn, err := r.request(ctx, request{Op: opRun, Args: args})
+	if err != nil {
+		return nil, err
+	}
+
+	// Closing the connection makes the helper kill the binary
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	o := &output{ctx: ctx, conn: conn, r: bufio.NewReader(conn), stop: stop, typ: rowTypes[casadm.ParseArgs(args).Command]}
+	o.csv = csv.NewWriter(&o.buf)
+
+	return o, nil
+}
+
+func (r *Runner) request(ctx context.Context, req request) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", r.Socket)
+	if err != nil {
+		return nil, fmt.Errorf("connect to the helper: %w", err)
+	}
+
+	b, err := json.Marshal(req)
+	if err == nil {
+		_, err = conn.Write(append(b, '\n'))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("send helper request: %w", err)
+	}
+
+	return conn, nil
+}
+
+// output is the output of a command run by the helper, written as CSV from the rows of its
+// frames as they're read
+type output struct {
+	ctx  context.Context
+	conn net.Conn
+	r    *bufio.Reader
+	stop func() bool
+
+	// typ is the type of the rows of the command, if it has any
+	typ reflect.Type
+	// buf is the CSV of the rows read that hasn't been read from the output yet
+	buf  bytes.Buffer
+	csv  *csv.Writer
+	rows int
+
+	// exit is the status of the command, once the exit frame has been read
+	exit *exitStatus
+	err  error
+}
+
+func (o *output) Read(p []byte) (int, error) {
+	for o.buf.Len() == 0 {
+		if o.exit != nil {
+			return 0, io.EOF
+		}
+		if o.err != nil {
+			return 0, o.err
+		}
+
+		o.err = o.readFrame()
+	}
+
+	return o.buf.Read(p)
+}
+
+// readFrame reads the next frame, writing the CSV record of the row frames
+func (o *output) readFrame() error {
+	h := make([]byte, 5)
+	if _, err := io.ReadFull(o.r, h); err != nil {
+		return o.readError(err)
+	}
+
+	size := int(binary.BigEndian.Uint32(h[1:]))
+	switch h[0] {
+	case frameRow:
+		if size > maxFrameSize {
+			return fmt.Errorf("read helper output: too large frame: %d bytes", size)
+		}
+
+		b := make([]byte, size)
+		if _, err := io.ReadFull(o.r, b); err != nil {
+			return o.readError(err)
+		}
+
+		if o.typ == nil {
+			return errors.New("read helper output: unexpected row")
+		}
+
+		rw := row{}
+		v := reflect.New(o.typ)
+		if err := json.Unmarshal(b, &rw); err != nil {
+			return fmt.Errorf("read helper row: %w", err)
+		}
+		if err := json.Unmarshal(rw.Value, v.Interface()); err != nil {
+			return fmt.Errorf("read helper row: %w", err)
+		}
+
+		// The header is written with the first row, so the failed commands have no output
+		if o.rows == 0 {
+			o.csv.Write(csvHeader(o.typ))
+		}
+		o.rows++
+
+		o.csv.Write(csvRecord(v.Elem(), rw.Unparsed))
+		o.csv.Flush()
+		if err := o.csv.Error(); err != nil {
+			return fmt.Errorf("write helper row: %w", err)
+		}
+
+	case frameExit:
+		b := make([]byte, min(size, maxFrameSize))
+		if _, err := io.ReadFull(o.r, b); err != nil {
+			return fmt.Errorf("read helper exit status: %w", err)
+		}
+
+		o.exit = &exitStatus{}
+		if err := json.Unmarshal(b, o.exit); err != nil {
+			return fmt.Errorf("read helper exit status: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("read helper output: unknown frame %d", h[0])
+	}
+
+	return nil
+}
+
+// readError returns the error reading the output. If the context is done, the connection
+// has been closed because of it
+func (o *output) readError(err error) error {
+	if cerr := o.ctx.Err(); cerr != nil {
+		err = cerr
+	} else if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return fmt.Errorf("read helper output: %w", err)
+}
+
+// Close reads the rest of the output, returning the error of the command
+func (o *output) Close() error {
+	defer o.conn.Close()
+	defer o.stop()
+
+	if _, err := io.Copy(io.Discard, o); err != nil {
+		return err
+	}
+
+	return o.exit.err()
+}
@@ -0,0 +1,78 @@
+package helper
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strconv"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+// rowTypes are the types of the rows of the output of the commands, by the command. The
+// commands without rows (e.g. changing the cache mode) only have the exit frame
+var rowTypes = map[string]reflect.Type{
+	casadm.CommandListCaches:   reflect.TypeOf(casadm.Cache{}),
+	casadm.CommandStats:        reflect.TypeOf(casadm.CacheStats{}),
+	casadm.CommandIOClassStats: reflect.TypeOf(casadm.IOClassStats{}),
+	casadm.CommandIOClasses:    reflect.TypeOf(casadm.IOClass{}),
+}
+
+// row is the payload of the row frames, one of the rows of the output parsed by the helper
+type row struct {
+	Value json.RawMessage `json:"value"`
+	// Unparsed are the fields of the stats the helper couldn't parse, which are left empty
+	Unparsed []string `json:"unparsed,omitempty"`
+}
+
+// unparsedValue is the CSV value of the fields the helper couldn't parse. The client can't
+// parse it either, so it reports the same fields as unparsed
+const unparsedValue = "-"
+
+// csvFields returns the indexes of the fields of the type that are CSV columns
+func csvFields(t reflect.Type) []int {
+	fields := []int{}
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("csv"); tag != "" && tag != "-" {
+			fields = append(fields, i)
+		}
+	}
+
+	return fields
+}
+
+// csvHeader returns the CSV header of the rows of the type, the csv tags of its fields
+func csvHeader(t reflect.Type) []string {
+	header := []string{}
+	for _, i := range csvFields(t) {
+		header = append(header, t.Field(i).Tag.Get("csv"))
+	}
+
+	return header
+}
+
+// csvRecord returns the CSV record of the row, in the columns of csvHeader
+func csvRecord(v reflect.Value, unparsed []string) []string {
+	record := []string{}
+	for _, i := range csvFields(v.Type()) {
+		f := v.Field(i)
+
+		var s string
+		switch {
+		case slices.Contains(unparsed, v.Type().Field(i).Name):
+			s = unparsedValue
+		case f.CanInt():
+			s = strconv.FormatInt(f.Int(), 10)
+		case f.CanUint():
+			s = strconv.FormatUint(f.Uint(), 10)
+		case f.CanFloat():
+			s = strconv.FormatFloat(f.Float(), 'f', -1, 64)
+		default:
+			s = f.String()
+		}
+
+		record = append(record, s)
+	}
+
+	return record
+}
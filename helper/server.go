@@ -0,0 +1,376 @@
+package helper
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+)
+
+// requestTimeout is the maximum time to send the request, once connected
+const requestTimeout = 5 * time.Second
+
+// commandTimeout is the maximum time a command can run, in case the exporter doesn't
+// close the connection
+const commandTimeout = 5 * time.Minute
+
+// maxRequestSize is the maximum size of the requests
+const maxRequestSize = 64 << 10
+
+// maxCommands is the maximum number of commands running at the same time
+const maxCommands = 4
+
+// Placeholders of the arguments of the allowed commands
+const (
+	argCacheID   = "<cache id>"
+	argCacheMode = "<cache mode>"
+	argFlush     = "<flush>"
+)
+
+// readCommands are the commands the helper runs, the ones the exporter needs to extract
+// the stats. Any other command is refused, so the exporter can't change the caches. They're
+// written with the Open CAS flags whichever the binary, as the client of the helper
+// translates them
+var readCommands = [][]string{
+	{"--list-caches", "--output-format", "csv"},
+	{"--stats", "--cache-id", argCacheID, "--output-format", "csv"},
+	{"--stats", "--cache-id", argCacheID, "--io-class-id", "--output-format", "csv"},
+	{"--io-class", "--list", "--cache-id", argCacheID, "--output-format", "csv"},
+}
+
+// setCacheModeCommand is the command to change the caching mode, only run if it's allowed
+var setCacheModeCommand = []string{"--set-cache-mode", "--cache-mode", argCacheMode, "--cache-id", argCacheID, "--flush-cache", argFlush}
+
+// Config is the configuration of the helper
+type Config struct {
+	// AllowSetCacheMode allows the exporter to change the caching mode of the caches
+	AllowSetCacheMode bool
+}
+
+// Server is the privileged helper, which runs the CAS administration binary for the
+// unprivileged exporter, parses its output and streams the parsed rows over a unix socket.
+// It only runs the commands the exporter needs, with their arguments validated
+type Server struct {
+	cfg  Config
+	cas  *casadm.Client
+	sema chan struct{}
+}
+
+// NewServer returns the helper that runs the binary of the client
+func NewServer(cfg Config, cas *casadm.Client) *Server {
+	return &Server{
+		cfg:  cfg,
+		cas:  cas,
+		sema: make(chan struct{}, maxCommands),
+	}
+}
+
+// Serve handles the connections of the listener until the context is done, waiting for the
+// commands running
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("accept connection: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+
+	r := bufio.NewReader(io.LimitReader(conn, maxRequestSize))
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		slog.Warn("read helper request",
+			slog.String("err", err.Error()),
+		)
+		return
+	}
+
+	req := request{}
+	if err := json.Unmarshal(line, &req); err != nil {
+		slog.Warn("read helper request",
+			slog.String("err", err.Error()),
+		)
+		return
+	}
+
+	conn.SetReadDeadline(time.Time{})
+
+	switch req.Op {
+	case opInfo:
+		json.NewEncoder(conn).Encode(Info{
+			Binary:   s.cas.Binary(),
+			IntelCAS: s.cas.Schema() == casadm.SchemaIntelCAS,
+		})
+
+	case opRun:
+		s.run(ctx, conn, req.Args)
+
+	default:
+		slog.Warn("unknown helper request",
+			slog.String("op", req.Op),
+		)
+	}
+}
+
+// run runs the command, writing the rows of its output in frames and its exit status last.
+// The command is killed if the connection is closed (e.g. the exporter timeouts)
+func (s *Server) run(ctx context.Context, conn net.Conn, args []string) {
+	if !s.allowed(args) {
+		slog.Warn("helper command refused",
+			slog.Any("args", args),
+		)
+
+		writeExit(conn, exitStatus{Error: ErrNotAllowed.Error(), Kind: kindNotAllowed})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	// The exporter doesn't send anything else, so the read only returns when the
+	// connection is closed
+	go func() {
+		conn.Read(make([]byte, 1))
+		cancel()
+	}()
+
+	select {
+	case s.sema <- struct{}{}:
+		defer func() { <-s.sema }()
+	case <-ctx.Done():
+		return
+	}
+
+	rows, err := s.rows(ctx, args)
+	for _, r := range rows {
+		b, merr := json.Marshal(r)
+		if merr != nil {
+			err = fmt.Errorf("encode row: %w", merr)
+			break
+		}
+
+		if err := writeFrame(conn, frameRow, b); err != nil {
+			return
+		}
+	}
+
+	writeExit(conn, exitStatusOf(err))
+}
+
+// rows runs the command with the client of the helper, returning the rows of its output.
+// The stats parsed partially are returned along with their unparsed fields, like the client
+// does
+func (s *Server) rows(ctx context.Context, args []string) ([]row, error) {
+	a := casadm.ParseArgs(args)
+
+	var out interface{}
+	var unparsed []string
+	var err error
+	switch a.Command {
+	case casadm.CommandListCaches:
+		out, err = s.cas.ListCaches(ctx)
+
+	case casadm.CommandStats:
+		var stats *casadm.CacheStats
+		stats, err = s.cas.GetCacheStats(ctx, a.CacheID)
+
+		var partial *casadm.PartialStatsError
+		if errors.As(err, &partial) {
+			unparsed, err = partial.Fields, nil
+		}
+		out = []*casadm.CacheStats{stats}
+
+	case casadm.CommandIOClassStats:
+		out, err = s.cas.GetIOClassStats(ctx, a.CacheID)
+
+	case casadm.CommandIOClasses:
+		out, err = s.cas.ListIOClasses(ctx, a.CacheID)
+
+	case casadm.CommandSetCacheMode:
+		return nil, s.cas.SetCacheMode(ctx, a.CacheID, argValue(args, "--cache-mode"), argValue(args, "--flush-cache") == "yes")
+
+	default:
+		return nil, fmt.Errorf("unknown command '%s'", a.Command)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v := reflect.ValueOf(out)
+	rows := make([]row, v.Len())
+	for i := range rows {
+		b, err := json.Marshal(v.Index(i).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("encode row: %w", err)
+		}
+
+		rows[i] = row{Value: b, Unparsed: unparsed}
+	}
+
+	return rows, nil
+}
+
+// argValue returns the value of the flag of the arguments, which have been validated
+func argValue(args []string, flag string) string {
+	if i := slices.Index(args, flag); i != -1 && i+1 < len(args) {
+		return args[i+1]
+	}
+
+	return ""
+}
+
+// allowed returns whether the command is one of the exporter, with valid arguments
+func (s *Server) allowed(args []string) bool {
+	commands := readCommands
+	if s.cfg.AllowSetCacheMode {
+		commands = append(slices.Clone(commands), setCacheModeCommand)
+	}
+
+	return slices.ContainsFunc(commands, func(cmd []string) bool {
+		return matchArgs(cmd, args)
+	})
+}
+
+func matchArgs(cmd, args []string) bool {
+	if len(cmd) != len(args) {
+		return false
+	}
+
+	for i, a := range cmd {
+		switch a {
+		case argCacheID:
+			if id, err := strconv.ParseUint(args[i], 10, 16); err != nil || id == 0 {
+				return false
+			}
+		case argCacheMode:
+			if !slices.Contains(casadm.CacheModes, args[i]) {
+				return false
+			}
+		case argFlush:
+			if args[i] != "yes" && args[i] != "no" {
+				return false
+			}
+		default:
+			if args[i] != a {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func exitStatusOf(err error) exitStatus {
+	if err == nil {
+		return exitStatus{}
+	}
+
+	s := exitStatus{Error: err.Error()}
+
+	switch {
+	case errors.Is(err, casadm.ErrCasadmNotInstalled):
+		s.Kind = kindNotInstalled
+	case errors.Is(err, casadm.ErrPermissionDenied):
+		s.Kind = kindPermission
+	case errors.Is(err, casadm.ErrCacheNotFound):
+		s.Kind = kindCacheNotFound
+	case errors.Is(err, casadm.ErrTimeout):
+		s.Kind = kindTimeout
+	}
+
+	return s
+}
+
+func writeExit(w io.Writer, s exitStatus) {
+	b, _ := json.Marshal(s)
+	writeFrame(w, frameExit, b)
+}
+
+// Listen listens on the unix socket, replacing the one left by a previous helper. The socket
+// is only accessible by its owner and the group, if gid isn't -1, so it's the group of the
+// exporter that can run the commands
+func Listen(socket string, gid int) (net.Listener, error) {
+	if err := removeStaleSocket(socket); err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("listen on the helper socket: %w", err)
+	}
+
+	if err := os.Chmod(socket, 0o660); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("set the helper socket permissions: %w", err)
+	}
+
+	if gid != -1 {
+		if err := os.Chown(socket, -1, gid); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("set the helper socket group: %w", err)
+		}
+	}
+
+	return l, nil
+}
+
+// removeStaleSocket removes the socket if there's no helper listening on it
+func removeStaleSocket(socket string) error {
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("listen on the helper socket: another helper is listening on %s", socket)
+	}
+
+	fi, err := os.Lstat(socket)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("remove stale helper socket: %w", err)
+	}
+
+	// Anything else than a socket is left alone, in case the path is wrong
+	if fi.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("remove stale helper socket: %s isn't a socket", socket)
+	}
+
+	if err := os.Remove(socket); err != nil {
+		return fmt.Errorf("remove stale helper socket: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package libvirt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const virshCmd = "virsh"
+
+// Disk is a disk, or an image of its backing chain, of a running domain
+type Disk struct {
+	Domain string
+	// Target is the name of the disk in the domain (e.g. vda)
+	Target string
+	// Path is the block device or the image file
+	Path string
+}
+
+// Disks returns the disks of the running domains, including the backing chains of their
+// images, with a read-only connection to the URI (e.g. qemu:///system)
+func Disks(ctx context.Context, uri string) ([]*Disk, error) {
+	b, err := exec.CommandContext(ctx, virshCmd, "--readonly", "--connect", uri, "domstats", "--block", "--backing", "--list-running").Output()
+	if err != nil {
+		var stderr []byte
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			stderr = exitErr.Stderr
+		}
+
+		return nil, fmt.Errorf("get domains stats: %w: '%s'", err, bytes.TrimSpace(stderr))
+	}
+
+	return parseDomstats(b)
+}
+
+// parseDomstats parses the output of virsh domstats:
+//
+//	Domain: 'desktop1'
+//	  block.count=2
+//	  block.0.name=vda
+//	  block.0.path=/isard/groups/desktop1.qcow2
+//	  block.1.name=vda
+//	  block.1.path=/isard/templates/template1.qcow2
+//	  block.1.backingIndex=1
+func parseDomstats(b []byte) ([]*Disk, error) {
+	disks := []*Disk{}
+
+	var domain string
+	var blocks map[string]*Disk
+	var order []string
+	flush := func() {
+		for _, n := range order {
+			if d := blocks[n]; d.Path != "" {
+				disks = append(disks, d)
+			}
+		}
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+
+		if name, ok := strings.CutPrefix(line, "Domain: "); ok {
+			flush()
+
+			domain = strings.TrimSuffix(strings.TrimPrefix(name, "'"), "'")
+			blocks = map[string]*Disk{}
+			order = nil
+			continue
+		}
+
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || blocks == nil {
+			continue
+		}
+
+		// block.<n>.<field>
+		parts := strings.SplitN(k, ".", 3)
+		if len(parts) != 3 || parts[0] != "block" {
+			continue
+		}
+
+		d, ok := blocks[parts[1]]
+		if !ok {
+			d = &Disk{Domain: domain}
+			blocks[parts[1]] = d
+			order = append(order, parts[1])
+		}
+
+		switch parts[2] {
+		case "name":
+			d.Target = v
+		case "path":
+			d.Path = v
+		}
+	}
+	flush()
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read virsh output: %w", err)
+	}
+
+	return disks, nil
+}
@@ -0,0 +1,180 @@
+package lockfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultPath is the path of the lock file used by default
+const DefaultPath = "/run/cas-exporter.lock"
+
+// conflictSuffix is the suffix of the file touched by the instances that fail to acquire
+// the lock, so the instance holding it can report the conflict
+const conflictSuffix = ".conflict"
+
+// ErrLocked is returned when the lock is held by another process
+var ErrLocked = errors.New("lock held by another instance")
+
+// Lock is an exclusive flock on a file, which contains the PID of its holder
+type Lock struct {
+	path       string
+	f          *os.File
+	acquiredAt time.Time
+	// handedOff is whether the lock has been taken over by another process
+	handedOff bool
+	// conflictSeen is the modification time of the conflict file when it was last reported
+	conflictSeen time.Time
+}
+
+// Acquire takes the lock, failing with ErrLocked if another process holds it
+func Acquire(path string) (*Lock, error) {
+	for {
+		f, err := acquire(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// The holder removes the file when releasing the lock, so the file may have been
+		// locked after being removed, and another instance may lock the new one
+		if f == nil {
+			continue
+		}
+
+		return hold(path, f)
+	}
+}
+
+// acquire locks the file at path. If the file has been removed or replaced before locking
+// it, the returned file is nil
+func acquire(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer f.Close()
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			// Let the holder know another instance has been started
+			if err := os.WriteFile(path+conflictSuffix, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+				return nil, fmt.Errorf("%w: write conflict file: %w", ErrLocked, err)
+			}
+
+			b, _ := os.ReadFile(path)
+			if pid := strings.TrimSpace(string(b)); pid != "" {
+				return nil, fmt.Errorf("%w (PID %s)", ErrLocked, pid)
+			}
+
+			return nil, ErrLocked
+		}
+
+		return nil, fmt.Errorf("lock file: %w", err)
+	}
+
+	locked, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("check lock file: %w", err)
+	}
+
+	current, err := os.Stat(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		f.Close()
+		return nil, fmt.Errorf("check lock file: %w", err)
+	}
+
+	if current == nil || !os.SameFile(locked, current) {
+		f.Close()
+		return nil, nil
+	}
+
+	return f, nil
+}
+
+// Inherit takes over the lock of the file at path, passed by the process holding it (e.g.
+// during a handoff). The lock is shared by the descriptors of the file, so it's held
+// without gaps
+func Inherit(path string, f *os.File) (*Lock, error) {
+	// It doesn't block, since the lock is already held through the file
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock inherited file: %w", err)
+	}
+
+	return hold(path, f)
+}
+
+// hold writes the PID of the process in the locked file
+func hold(path string, f *os.File) (*Lock, error) {
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("truncate lock file: %w", err)
+	}
+
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write lock file: %w", err)
+	}
+
+	return &Lock{
+		path:       path,
+		f:          f,
+		acquiredAt: time.Now(),
+	}, nil
+}
+
+// File returns the locked file, to pass it to the process taking over the lock
+func (l *Lock) File() *os.File {
+	return l.f
+}
+
+// HandOff marks the lock as taken over by another process, which has inherited its file, so
+// Release only closes it
+func (l *Lock) HandOff() {
+	l.handedOff = true
+}
+
+// Conflicted returns whether another instance has tried to acquire the lock since it was
+// last checked, or acquired
+func (l *Lock) Conflicted() (bool, error) {
+	info, err := os.Stat(l.path + conflictSuffix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("check conflict file: %w", err)
+	}
+
+	if info.ModTime().Before(l.acquiredAt) || !info.ModTime().After(l.conflictSeen) {
+		return false, nil
+	}
+	l.conflictSeen = info.ModTime()
+
+	return true, nil
+}
+
+// Release removes the lock file and releases the lock. Since it's removed while locked, the
+// instances acquiring it check that the file they lock is still the one at the path
+func (l *Lock) Release() error {
+	if l.handedOff {
+		return l.f.Close()
+	}
+
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove lock file: %w", err)
+	}
+
+	if err := os.Remove(l.path + conflictSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove conflict file: %w", err)
+	}
+
+	return l.f.Close()
+}
@@ -0,0 +1,44 @@
+package nvme
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+const nvmeCmd = "nvme"
+
+// ErrNotInstalled is returned when nvme-cli isn't installed
+var ErrNotInstalled = errors.New("nvme-cli isn't installed")
+
+// kelvinOffset is the difference between kelvin and celsius, the smart log reports
+// temperatures in kelvin
+const kelvinOffset = 273.15
+
+type SmartLog struct {
+	Temperature float64 `json:"temperature"`
+}
+
+// TemperatureCelsius returns the composite temperature of the controller in celsius
+func (s *SmartLog) TemperatureCelsius() float64 {
+	return s.Temperature - kelvinOffset
+}
+
+func GetSmartLog(ctx context.Context, dev string) (*SmartLog, error) {
+	b, err := exec.CommandContext(ctx, nvmeCmd, "smart-log", dev, "--output-format", "json").Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("get smart log: %w", ErrNotInstalled)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get smart log: %w: '%s'", err, b)
+	}
+
+	log := &SmartLog{}
+	if err := json.Unmarshal(b, log); err != nil {
+		return nil, fmt.Errorf("unmarshal smart log json: %w", err)
+	}
+
+	return log, nil
+}
@@ -0,0 +1,437 @@
+package sysfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	root     = "/sys"
+	procRoot = "/proc"
+)
+
+// BlockName returns the kernel name of a block device path, resolving any symlinks
+// (e.g. /dev/mapper/vg-lv -> dm-0)
+func BlockName(dev string) (string, error) {
+	p, err := filepath.EvalSymlinks(dev)
+	if err != nil {
+		return "", fmt.Errorf("resolve block device: %w", err)
+	}
+
+	return filepath.Base(p), nil
+}
+
+func blockPath(name string, elem ...string) string {
+	return filepath.Join(append([]string{root, "class", "block", name}, elem...)...)
+}
+
+func readString(p string) (string, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// exportedObjectRegexp matches the kernel names of the Open CAS exported objects
+// (cas<cache id>-<core id>)
+var exportedObjectRegexp = regexp.MustCompile(`^cas[0-9]+-[0-9]+$`)
+
+// IsExportedObject returns whether the kernel name is the one of an Open CAS exported object
+func IsExportedObject(name string) bool {
+	return exportedObjectRegexp.MatchString(name)
+}
+
+// ExportedObjects returns the kernel names of the Open CAS exported objects
+func ExportedObjects() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(root, "class", "block"))
+	if err != nil {
+		return nil, fmt.Errorf("read block devices: %w", err)
+	}
+
+	objects := []string{}
+	for _, e := range entries {
+		if exportedObjectRegexp.MatchString(e.Name()) {
+			objects = append(objects, e.Name())
+		}
+	}
+
+	return objects, nil
+}
+
+// Holders returns the kernel names of the block devices stacked directly on top of the device
+func Holders(name string) ([]string, error) {
+	entries, err := os.ReadDir(blockPath(name, "holders"))
+	if err != nil {
+		return nil, fmt.Errorf("read block device holders: %w", err)
+	}
+
+	holders := []string{}
+	for _, e := range entries {
+		holders = append(holders, e.Name())
+	}
+
+	return holders, nil
+}
+
+// Slaves returns the kernel names of the block devices the device is stacked directly on
+// top of (e.g. the physical volumes of a logical volume)
+func Slaves(name string) ([]string, error) {
+	entries, err := os.ReadDir(blockPath(name, "slaves"))
+	if err != nil {
+		// Only the stacked devices (device-mapper, md...) have slaves
+		if errors.Is(err, fs.ErrNotExist) {
+			return []string{}, nil
+		}
+
+		return nil, fmt.Errorf("read block device slaves: %w", err)
+	}
+
+	slaves := []string{}
+	for _, e := range entries {
+		slaves = append(slaves, e.Name())
+	}
+
+	return slaves, nil
+}
+
+// DevNumber returns the device number of the block device, as major:minor
+func DevNumber(name string) (string, error) {
+	n, err := readString(blockPath(name, "dev"))
+	if err != nil {
+		return "", fmt.Errorf("read block device number: %w", err)
+	}
+
+	return n, nil
+}
+
+type DM struct {
+	Name string
+	UUID string
+}
+
+// DeviceMapper returns the device-mapper information of the device, or nil if it's not
+// a device-mapper device
+func DeviceMapper(name string) (*DM, error) {
+	n, err := readString(blockPath(name, "dm", "name"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("read device mapper name: %w", err)
+	}
+
+	uuid, err := readString(blockPath(name, "dm", "uuid"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read device mapper uuid: %w", err)
+	}
+
+	return &DM{
+		Name: n,
+		UUID: uuid,
+	}, nil
+}
+
+// LVM returns the volume group and logical volume names of the device, if it's an LVM
+// logical volume
+func (d *DM) LVM() (vg string, lv string, ok bool) {
+	if !strings.HasPrefix(d.UUID, "LVM-") {
+		return "", "", false
+	}
+
+	// Device mapper names are "<vg>-<lv>", with the dashes inside each part doubled
+	for i := 0; i < len(d.Name); i++ {
+		if d.Name[i] != '-' {
+			continue
+		}
+
+		if i+1 < len(d.Name) && d.Name[i+1] == '-' {
+			i++
+			continue
+		}
+
+		vg = strings.ReplaceAll(d.Name[:i], "--", "-")
+		lv = strings.ReplaceAll(d.Name[i+1:], "--", "-")
+
+		return vg, lv, true
+	}
+
+	return "", "", false
+}
+
+// Disk returns the kernel name of the whole disk of a block device, which is the device
+// itself unless it's a partition
+func Disk(name string) (string, error) {
+	if _, err := os.Stat(blockPath(name, "partition")); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return name, nil
+		}
+
+		return "", fmt.Errorf("check block device partition: %w", err)
+	}
+
+	p, err := filepath.EvalSymlinks(blockPath(name))
+	if err != nil {
+		return "", fmt.Errorf("resolve block device partition: %w", err)
+	}
+
+	return filepath.Base(filepath.Dir(p)), nil
+}
+
+// Serial returns the world wide identifier or, if the disk doesn't have one, the serial
+// number of the disk. If it has none of them, it's empty
+func Serial(disk string) (string, error) {
+	for _, p := range []string{
+		blockPath(disk, "wwid"),
+		blockPath(disk, "device", "wwid"),
+		blockPath(disk, "device", "serial"),
+	} {
+		s, err := readString(p)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			return "", fmt.Errorf("read disk serial: %w", err)
+		}
+
+		if s != "" {
+			return s, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Temperature returns the temperature in celsius reported by the hwmon driver of the disk
+// (nvme or drivetemp). If the disk has no hwmon, ok is false
+func Temperature(disk string) (temp float64, ok bool, err error) {
+	for _, pattern := range []string{
+		blockPath(disk, "device", "hwmon*", "temp1_input"),
+		blockPath(disk, "device", "hwmon", "hwmon*", "temp1_input"),
+	} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return 0, false, fmt.Errorf("find hwmon temperature: %w", err)
+		}
+
+		if len(matches) == 0 {
+			continue
+		}
+
+		s, err := readString(matches[0])
+		if err != nil {
+			return 0, false, fmt.Errorf("read hwmon temperature: %w", err)
+		}
+
+		milli, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, false, fmt.Errorf("parse hwmon temperature: %w", err)
+		}
+
+		return float64(milli) / 1000, true, nil
+	}
+
+	return 0, false, nil
+}
+
+type PCIeLink struct {
+	SpeedGTs    float64
+	Width       int
+	MaxSpeedGTs float64
+	MaxWidth    int
+}
+
+// Degraded returns whether the link has been negotiated below its maximum capabilities
+func (l *PCIeLink) Degraded() bool {
+	return l.SpeedGTs < l.MaxSpeedGTs || l.Width < l.MaxWidth
+}
+
+// GetPCIeLink returns the PCIe link status of the disk. If the disk isn't directly attached
+// to PCIe (e.g. SATA disks behind a controller), ok is false
+func GetPCIeLink(disk string) (link *PCIeLink, ok bool, err error) {
+	for _, dir := range []string{
+		// NVMe namespaces point to the controller, which points to the PCIe function
+		blockPath(disk, "device", "device"),
+		blockPath(disk, "device"),
+	} {
+		if _, err := os.Stat(filepath.Join(dir, "current_link_speed")); err != nil {
+			continue
+		}
+
+		link := &PCIeLink{}
+		for _, f := range []struct {
+			name  string
+			speed *float64
+			width *int
+		}{
+			{name: "current_link_speed", speed: &link.SpeedGTs},
+			{name: "current_link_width", width: &link.Width},
+			{name: "max_link_speed", speed: &link.MaxSpeedGTs},
+			{name: "max_link_width", width: &link.MaxWidth},
+		} {
+			s, err := readString(filepath.Join(dir, f.name))
+			if err != nil {
+				return nil, false, fmt.Errorf("read pcie %s: %w", f.name, err)
+			}
+
+			if f.speed != nil {
+				// e.g. "8.0 GT/s PCIe"
+				v, _, _ := strings.Cut(s, " ")
+				if *f.speed, err = strconv.ParseFloat(v, 64); err != nil {
+					return nil, false, fmt.Errorf("parse pcie %s: %w", f.name, err)
+				}
+			} else {
+				if *f.width, err = strconv.Atoi(s); err != nil {
+					return nil, false, fmt.Errorf("parse pcie %s: %w", f.name, err)
+				}
+			}
+		}
+
+		return link, true, nil
+	}
+
+	return nil, false, nil
+}
+
+type MDArray struct {
+	// Level is the RAID level (e.g. raid1)
+	Level string
+	// State is the array state (e.g. clean, active or readonly)
+	State string
+	// SyncAction is the running sync (e.g. idle, resync or recover). It's empty for the
+	// levels without redundancy
+	SyncAction string
+	RaidDisks  int
+	// Redundant is whether the level has redundancy, and so it can be degraded
+	Redundant bool
+	// Degraded is the number of devices missing from the array
+	Degraded int
+}
+
+// GetMDArray returns the status of the md array. If the device isn't an md array, ok is false
+func GetMDArray(name string) (array *MDArray, ok bool, err error) {
+	if _, err := os.Stat(blockPath(name, "md")); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, false, nil
+		}
+
+		return nil, false, fmt.Errorf("check md array: %w", err)
+	}
+
+	array = &MDArray{}
+	for _, f := range []struct {
+		name     string
+		s        *string
+		n        *int
+		optional bool
+	}{
+		{name: "level", s: &array.Level},
+		{name: "array_state", s: &array.State},
+		{name: "raid_disks", n: &array.RaidDisks},
+		{name: "sync_action", s: &array.SyncAction, optional: true},
+		{name: "degraded", n: &array.Degraded, optional: true},
+	} {
+		s, err := readString(blockPath(name, "md", f.name))
+		if err != nil {
+			if f.optional && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			return nil, false, fmt.Errorf("read md %s: %w", f.name, err)
+		}
+
+		if f.s != nil {
+			*f.s = s
+			continue
+		}
+
+		if *f.n, err = strconv.Atoi(s); err != nil {
+			return nil, false, fmt.Errorf("parse md %s: %w", f.name, err)
+		}
+		if f.name == "degraded" {
+			array.Redundant = true
+		}
+	}
+
+	return array, true, nil
+}
+
+// ModuleParameters returns the values of the parameters of a loaded kernel module
+func ModuleParameters(module string) (map[string]string, error) {
+	dir := filepath.Join(root, "module", module, "parameters")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read module parameters: %w", err)
+	}
+
+	params := map[string]string{}
+	for _, e := range entries {
+		v, err := readString(filepath.Join(dir, e.Name()))
+		if err != nil {
+			// Some parameters are write only
+			if errors.Is(err, fs.ErrPermission) {
+				continue
+			}
+
+			return nil, fmt.Errorf("read module parameter %s: %w", e.Name(), err)
+		}
+
+		params[e.Name()] = v
+	}
+
+	return params, nil
+}
+
+// KernelRelease returns the release of the running kernel
+func KernelRelease() (string, error) {
+	r, err := readString(filepath.Join(procRoot, "sys", "kernel", "osrelease"))
+	if err != nil {
+		return "", fmt.Errorf("read kernel release: %w", err)
+	}
+
+	return r, nil
+}
+
+type Module struct {
+	Loaded  bool
+	Version string
+	// Taint are the taint flags of the module (e.g. "OE")
+	Taint string
+}
+
+// GetModule returns the status of a kernel module. Modules that aren't loaded are
+// returned with Loaded set to false
+func GetModule(module string) (*Module, error) {
+	dir := filepath.Join(root, "module", module)
+
+	if _, err := os.Stat(dir); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &Module{}, nil
+		}
+
+		return nil, fmt.Errorf("check module: %w", err)
+	}
+
+	m := &Module{Loaded: true}
+
+	var err error
+	if m.Version, err = readString(filepath.Join(dir, "version")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read module version: %w", err)
+	}
+
+	if m.Taint, err = readString(filepath.Join(dir, "taint")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read module taint: %w", err)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,46 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+// States are the possible active states of a unit
+var States = []string{"active", "reloading", "inactive", "failed", "activating", "deactivating"}
+
+type Unit struct {
+	Name string
+	// LoadState is whether the unit file has been loaded (e.g. "loaded" or "not-found")
+	LoadState string
+	// ActiveState is one of States
+	ActiveState string
+	SubState    string
+}
+
+// Units returns the status of the units, queried through D-Bus
+func Units(ctx context.Context, names []string) ([]*Unit, error) {
+	conn, err := dbus.NewWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	status, err := conn.ListUnitsByNamesContext(ctx, names)
+	if err != nil {
+		return nil, fmt.Errorf("list systemd units: %w", err)
+	}
+
+	units := []*Unit{}
+	for _, s := range status {
+		units = append(units, &Unit{
+			Name:        s.Name,
+			LoadState:   s.LoadState,
+			ActiveState: s.ActiveState,
+			SubState:    s.SubState,
+		})
+	}
+
+	return units, nil
+}
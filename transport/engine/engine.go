@@ -0,0 +1,243 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Health of the caches and the hosts
+const (
+	HealthOK        = "ok"
+	HealthSaturated = "saturated"
+	HealthDegraded  = "degraded"
+	// HealthUnknown is the health of the hosts without any extraction yet
+	HealthUnknown = "unknown"
+)
+
+// requestTimeout is the maximum time a report request can take
+const requestTimeout = 10 * time.Second
+
+// Config is the configuration of the engine reporter
+type Config struct {
+	// URL is the endpoint of the engine API the summaries are posted to
+	URL string
+	// Token is the bearer token of the requests. If it's empty, they aren't authenticated
+	Token    string
+	Interval time.Duration
+}
+
+// Summary is the body of the report requests
+type Summary struct {
+	Host string `json:"host"`
+	// Health is the worst health of the caches, or degraded if the last extraction failed
+	Health string `json:"health"`
+	// UpdatedAt is when the stats were extracted
+	UpdatedAt *time.Time        `json:"updated_at"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Caches    []*CacheSummary   `json:"caches"`
+}
+
+// CacheSummary is the health of a cache
+type CacheSummary struct {
+	ID     uint16 `json:"id"`
+	Device string `json:"device"`
+	Status string `json:"status"`
+	Health string `json:"health"`
+	// Reasons explain why the cache isn't ok (e.g. inactive_cores)
+	Reasons          []string `json:"reasons,omitempty"`
+	OccupancyPercent float64  `json:"occupancy_percent"`
+	DirtyPercent     float64  `json:"dirty_percent"`
+	ReadHitsPercent  float64  `json:"read_hits_percent"`
+}
+
+// Reporter posts a summary of the health of the caches to the isard-vdi engine periodically,
+// so the scheduler can avoid placing new desktops on the hosts whose caches are saturated
+// or degraded
+type Reporter struct {
+	cfg    Config
+	e      *casexporter.CasExporter
+	host   string
+	client *http.Client
+
+	reports      prometheus.Counter
+	reportErrors prometheus.Counter
+}
+
+// NewReporter returns the reporter of the caches of the exporter
+func NewReporter(cfg Config, e *casexporter.CasExporter) *Reporter {
+	host, _ := os.Hostname()
+
+	return &Reporter{
+		cfg:    cfg,
+		e:      e,
+		host:   host,
+		client: &http.Client{Timeout: requestTimeout},
+
+		reports: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_engine_reports_total",
+			Help: "Number of cache health summaries posted to the isard-vdi engine",
+		}),
+		reportErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_engine_report_errors_total",
+			Help: "Number of cache health summaries that have failed to be posted to the isard-vdi engine",
+		}),
+	}
+}
+
+// Start posts the summary every interval, until the context is done. The summary isn't
+// posted before the first extraction
+func (r *Reporter) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-r.e.Extracted():
+	}
+
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.report(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reporter) report(ctx context.Context) {
+	b, err := json.Marshal(r.summary(r.e.Inventory()))
+	if err == nil {
+		err = r.post(ctx, b)
+	}
+	if err != nil {
+		// The requests cancelled by the shutdown aren't errors
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The report isn't retried, the next one has fresher stats
+		r.reportErrors.Inc()
+		slog.Error("report cache health to the engine",
+			slog.String("url", r.cfg.URL),
+			slog.String("err", err.Error()),
+		)
+		return
+	}
+
+	r.reports.Inc()
+}
+
+// summary returns the health summary of the inventory. The caches are degraded if they
+// aren't running, have inactive cores or their stats have failed, and saturated if their
+// dirty percentage exceeds their threshold
+func (r *Reporter) summary(inv *casexporter.Inventory) *Summary {
+	s := &Summary{
+		Host:      r.host,
+		Health:    HealthUnknown,
+		UpdatedAt: inv.UpdatedAt,
+		Labels:    r.e.ConstLabels(),
+		Caches:    []*CacheSummary{},
+	}
+	if inv.UpdatedAt == nil {
+		return s
+	}
+
+	s.Health = HealthOK
+	if !inv.Success {
+		s.Health = HealthDegraded
+	}
+
+	for _, cache := range inv.Caches {
+		c := &CacheSummary{
+			ID:     cache.ID,
+			Device: cache.Device,
+			Status: cache.Status,
+			Health: HealthOK,
+		}
+
+		if cache.Status != casadm.StatusRunning {
+			c.Reasons = append(c.Reasons, "not_running")
+		}
+
+		if cache.Stats == nil {
+			c.Reasons = append(c.Reasons, "stats_failed")
+		} else {
+			c.OccupancyPercent = cache.Stats.OccupancyPercent
+			c.DirtyPercent = cache.Stats.DirtyPercent
+			c.ReadHitsPercent = cache.Stats.ReadHitsPercent
+
+			if cache.Stats.InactiveCores != 0 {
+				c.Reasons = append(c.Reasons, "inactive_cores")
+			}
+		}
+
+		if len(c.Reasons) != 0 {
+			c.Health = HealthDegraded
+		} else if t := r.e.DirtyThreshold(cache.ID); t != 0 && c.DirtyPercent > t {
+			c.Health = HealthSaturated
+			c.Reasons = append(c.Reasons, "dirty_threshold_exceeded")
+		}
+
+		switch {
+		case c.Health == HealthDegraded:
+			s.Health = HealthDegraded
+		case c.Health == HealthSaturated && s.Health == HealthOK:
+			s.Health = HealthSaturated
+		}
+
+		s.Caches = append(s.Caches, c)
+	}
+
+	return s
+}
+
+func (r *Reporter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create report request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if r.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.cfg.Token)
+	}
+
+	rsp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request engine: %w", err)
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return fmt.Errorf("request engine: unexpected status %s", rsp.Status)
+	}
+
+	return nil
+}
+
+func (r *Reporter) Describe(ch chan<- *prometheus.Desc) {
+	r.reports.Describe(ch)
+	r.reportErrors.Describe(ch)
+}
+
+func (r *Reporter) Collect(ch chan<- prometheus.Metric) {
+	r.reports.Collect(ch)
+	r.reportErrors.Collect(ch)
+}
@@ -0,0 +1,131 @@
+package heartbeat
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// requestTimeout is the maximum time a ping can take
+const requestTimeout = 10 * time.Second
+
+// Config is the configuration of the heartbeat pinger
+type Config struct {
+	// URL is pinged after the successful extractions (e.g. a healthchecks.io check)
+	URL string
+	// Interval is the minimum time between pings. If it's 0, every successful extraction
+	// is pinged
+	Interval time.Duration
+}
+
+// Pinger pings a dead man's switch after the extractions that have fully succeeded, as an
+// out of band signal that the exporter is alive and extracting the stats, which doesn't
+// depend on Prometheus. The monitoring service alerts if the pings stop
+type Pinger struct {
+	cfg       Config
+	e         *casexporter.CasExporter
+	extracted <-chan struct{}
+	client    *http.Client
+
+	pings      prometheus.Counter
+	pingErrors prometheus.Counter
+}
+
+// NewPinger returns the heartbeat pinger of the extractions of the exporter
+func NewPinger(cfg Config, e *casexporter.CasExporter) *Pinger {
+	return &Pinger{
+		cfg:       cfg,
+		e:         e,
+		extracted: e.Subscribe(),
+		client:    &http.Client{Timeout: requestTimeout},
+
+		pings: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_heartbeat_pings_total",
+			Help: "Number of heartbeat pings sent after successful extractions",
+		}),
+		pingErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_heartbeat_ping_errors_total",
+			Help: "Number of heartbeat pings that have failed",
+		}),
+	}
+}
+
+// Start pings after each successful extraction, at most once every interval, until the
+// context is done. The failed pings are retried after the next successful extraction
+func (p *Pinger) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-p.extracted:
+			// The failed extractions aren't pinged, so the switch goes off if they keep
+			// failing
+			if !p.e.Inventory().Success || time.Since(last) < p.cfg.Interval {
+				continue
+			}
+
+			if p.ping(ctx) {
+				last = time.Now()
+			}
+		}
+	}
+}
+
+func (p *Pinger) ping(ctx context.Context) bool {
+	if err := p.request(ctx); err != nil {
+		// The requests cancelled by the shutdown aren't errors
+		if ctx.Err() != nil {
+			return false
+		}
+
+		p.pingErrors.Inc()
+		slog.Error("ping heartbeat",
+			slog.String("url", p.cfg.URL),
+			slog.String("err", err.Error()),
+		)
+		return false
+	}
+
+	p.pings.Inc()
+	return true
+}
+
+func (p *Pinger) request(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.URL, nil)
+	if err != nil {
+		return fmt.Errorf("create ping request: %w", err)
+	}
+
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request heartbeat: %w", err)
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return fmt.Errorf("request heartbeat: unexpected status %s", rsp.Status)
+	}
+
+	return nil
+}
+
+func (p *Pinger) Describe(ch chan<- *prometheus.Desc) {
+	p.pings.Describe(ch)
+	p.pingErrors.Describe(ch)
+}
+
+func (p *Pinger) Collect(ch chan<- prometheus.Metric) {
+	p.pings.Collect(ch)
+	p.pingErrors.Collect(ch)
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DefaultACMECacheDir is the default directory where the ACME account and certificates are
+// stored
+const DefaultACMECacheDir = "/var/lib/cas-exporter/acme"
+
+// ACMEConfig is the configuration of the certificates obtained and renewed automatically
+// through ACME
+type ACMEConfig struct {
+	// Domains are the names the certificate is requested for. If it's empty, ACME isn't used
+	Domains []string
+	// Email is the contact address of the ACME account. It can be empty
+	Email string
+	// DirectoryURL is the directory of the ACME CA. If it's empty, Let's Encrypt is used
+	DirectoryURL string
+	// CacheDir is the directory where the account and the certificates are stored, so they
+	// survive restarts
+	CacheDir string
+	// HTTPAddr is the address the HTTP-01 challenges are answered at (e.g. :80). If it's
+	// empty, only the TLS-ALPN-01 challenge, answered at the TLS listeners, is used
+	HTTPAddr string
+}
+
+func (c ACMEConfig) manager() *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(c.CacheDir),
+		HostPolicy: autocert.HostWhitelist(c.Domains...),
+		Email:      c.Email,
+	}
+	if c.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: c.DirectoryURL}
+	}
+
+	return m
+}
+
+// acmeServer returns the TLS configuration that obtains and renews the certificate through
+// the manager, along with the server of the HTTP-01 challenges, if there's an address for
+// them
+func (c ACMEConfig) acmeServer() (*tls.Config, *http.Server) {
+	m := c.manager()
+
+	cfg := m.TLSConfig()
+	cfg.MinVersion = tls.VersionTLS12
+
+	if c.HTTPAddr == "" {
+		return cfg, nil
+	}
+
+	// The default fallback redirects to HTTPS on the port 443, where the exporter isn't
+	// listening, so the requests that aren't challenges are rejected instead
+	return cfg, &http.Server{
+		Addr:    c.HTTPAddr,
+		Handler: m.HTTPHandler(http.NotFoundHandler()),
+	}
+}
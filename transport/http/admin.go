@@ -0,0 +1,250 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/audit"
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+	"github.com/isard-vdi/CAS_Exporter/helper"
+)
+
+// authenticated only lets through the requests with the admin token as bearer token. The
+// rejected requests of state changing actions are recorded in the audit log
+func (s *ExporterServer) authenticated(action string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+			if action != "" {
+				s.audit(r, action, nil, audit.OutcomeUnauthorized, nil)
+			}
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cas-exporter"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// audit records the admin action requested
+func (s *ExporterServer) audit(r *http.Request, action string, params map[string]string, outcome string, err error) {
+	if s.Audit == nil {
+		return
+	}
+
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client = host
+	}
+
+	e := audit.Entry{
+		Action:    action,
+		Client:    client,
+		UserAgent: r.UserAgent(),
+		Params:    params,
+		Outcome:   outcome,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	s.Audit.Record(e)
+}
+
+// handleAdmin registers the maintenance endpoints, if there's an admin token
+func (s *ExporterServer) handleAdmin(handleFunc func(string, http.HandlerFunc)) {
+	if s.AdminToken == "" {
+		return
+	}
+
+	handleFunc("GET /api/v1/pause", s.authenticated("", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.CasExporter.PauseState())
+	}))
+
+	handleFunc("POST /api/v1/pause", s.authenticated("pause", func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]string
+
+		var d time.Duration
+		if v := r.URL.Query().Get("duration"); v != "" {
+			params = map[string]string{"duration": v}
+
+			var err error
+			d, err = time.ParseDuration(v)
+			if err == nil && d < 0 {
+				err = errors.New("negative duration")
+			}
+			if err != nil {
+				s.audit(r, "pause", params, audit.OutcomeFailure, err)
+				http.Error(w, fmt.Sprintf("invalid duration '%s'", v), http.StatusBadRequest)
+				return
+			}
+		}
+
+		state := s.CasExporter.Pause(d)
+		s.audit(r, "pause", params, audit.OutcomeSuccess, nil)
+		writeJSON(w, state)
+	}))
+
+	handleFunc("POST /api/v1/resume", s.authenticated("resume", func(w http.ResponseWriter, r *http.Request) {
+		state := s.CasExporter.Resume()
+		s.audit(r, "resume", nil, audit.OutcomeSuccess, nil)
+		writeJSON(w, state)
+	}))
+	handleFunc("POST /api/v1/caches/{id}/cache-mode", s.authenticated("set_cache_mode", s.handleSetCacheMode))
+	handleFunc("GET /api/v1/snapshot", s.authenticated("", s.handleSnapshot))
+
+	if s.FaultInjection {
+		handleFunc("GET /api/v1/fault-inject", s.authenticated("", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, s.CasExporter.FaultState())
+		}))
+		handleFunc("POST /api/v1/fault-inject", s.authenticated("inject_fault", s.handleInjectFault))
+		handleFunc("DELETE /api/v1/fault-inject", s.authenticated("clear_fault", func(w http.ResponseWriter, r *http.Request) {
+			state := s.CasExporter.ClearFault()
+			s.audit(r, "clear_fault", nil, audit.OutcomeSuccess, nil)
+			writeJSON(w, state)
+		}))
+	}
+}
+
+// handleSnapshot writes the snapshot archive, one at a time
+func (s *ExporterServer) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	// The snapshot runs casadm, which has to be left alone during the maintenance
+	if s.CasExporter.Paused() {
+		http.Error(w, "the extraction is paused for maintenance", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !s.snapshotting.CompareAndSwap(false, true) {
+		http.Error(w, "a snapshot is already being written", http.StatusTooManyRequests)
+		return
+	}
+	defer s.snapshotting.Store(false)
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="cas-exporter-snapshot-%s.tar.gz"`, time.Now().UTC().Format("20060102T150405Z")))
+
+	if err := s.CasExporter.WriteSnapshot(r.Context(), w, s.Config); err != nil {
+		slog.Error("write snapshot",
+			slog.String("err", err.Error()),
+		)
+	}
+}
+
+// handleInjectFault makes the casadm commands fail with the fault, of all the caches or of
+// one, for a duration or until it's cleared
+func (s *ExporterServer) handleInjectFault(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	params := map[string]string{
+		"fault":    q.Get("fault"),
+		"cache_id": q.Get("cache_id"),
+		"duration": q.Get("duration"),
+	}
+
+	fail := func(err error) {
+		s.audit(r, "inject_fault", params, audit.OutcomeFailure, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+
+	if !slices.Contains(casexporter.Faults, params["fault"]) {
+		fail(fmt.Errorf("invalid fault '%s', available faults: %s", params["fault"], strings.Join(casexporter.Faults, ", ")))
+		return
+	}
+
+	var id uint64
+	if v := params["cache_id"]; v != "" {
+		var err error
+		id, err = strconv.ParseUint(v, 10, 16)
+		if err != nil || id == 0 {
+			fail(fmt.Errorf("invalid cache id '%s'", v))
+			return
+		}
+	}
+
+	var d time.Duration
+	if v := params["duration"]; v != "" {
+		var err error
+		d, err = time.ParseDuration(v)
+		if err != nil || d < 0 {
+			fail(fmt.Errorf("invalid duration '%s'", v))
+			return
+		}
+	}
+
+	state := s.CasExporter.InjectFault(params["fault"], uint16(id), d)
+	s.audit(r, "inject_fault", params, audit.OutcomeSuccess, nil)
+	writeJSON(w, state)
+}
+
+// handleSetCacheMode switches the caching mode of a cache (e.g. from wb to wt before a
+// maintenance), unless the exporter is read-only
+func (s *ExporterServer) handleSetCacheMode(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	params := map[string]string{
+		"cache_id": r.PathValue("id"),
+		"mode":     q.Get("mode"),
+		"flush":    q.Get("flush"),
+	}
+
+	fail := func(status int, err error) {
+		s.audit(r, "set_cache_mode", params, audit.OutcomeFailure, err)
+		http.Error(w, err.Error(), status)
+	}
+
+	if s.ReadOnly {
+		fail(http.StatusForbidden, errors.New("the exporter is read-only"))
+		return
+	}
+
+	id, err := strconv.ParseUint(params["cache_id"], 10, 16)
+	if err != nil {
+		fail(http.StatusBadRequest, fmt.Errorf("invalid cache id '%s'", params["cache_id"]))
+		return
+	}
+
+	if !slices.Contains(casadm.CacheModes, params["mode"]) {
+		fail(http.StatusBadRequest, fmt.Errorf("invalid cache mode '%s', available modes: %s", params["mode"], strings.Join(casadm.CacheModes, ", ")))
+		return
+	}
+
+	var flush bool
+	switch params["flush"] {
+	case "yes":
+		flush = true
+	case "", "no":
+	default:
+		fail(http.StatusBadRequest, fmt.Errorf("invalid flush '%s', it has to be yes or no", params["flush"]))
+		return
+	}
+
+	// Flushing can take a while, and it shouldn't be interrupted if the client gives up
+	if err := s.CasExporter.SetCacheMode(context.WithoutCancel(r.Context()), uint16(id), params["mode"], flush); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, casadm.ErrCacheNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, helper.ErrNotAllowed):
+			status = http.StatusForbidden
+		}
+
+		fail(status, err)
+		return
+	}
+
+	s.audit(r, "set_cache_mode", params, audit.OutcomeSuccess, nil)
+	writeJSON(w, map[string]string{
+		"cache_id": params["cache_id"],
+		"mode":     params["mode"],
+	})
+}
@@ -0,0 +1,100 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ParseCIDRs parses the client ranges allowed. The addresses without a prefix length are a
+// single client
+func ParseCIDRs(list []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cidr '%s': %w", s, err)
+			}
+
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr '%s': %w", s, err)
+		}
+
+		prefixes = append(prefixes, p.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// allowlist rejects the requests of the clients outside of the allowed ranges, for the
+// hosts that can't run a firewall
+type allowlist struct {
+	prefixes []netip.Prefix
+
+	denied prometheus.Counter
+}
+
+func newAllowlist(prefixes []netip.Prefix) *allowlist {
+	return &allowlist{
+		prefixes: prefixes,
+
+		denied: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_http_requests_denied_total",
+			Help: "Number of HTTP requests rejected because the client isn't in the allowed ranges",
+		}),
+	}
+}
+
+// containsAddr returns whether the address (with or without port) is in any of the ranges
+func containsAddr(prefixes []netip.Prefix, remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+
+	// The IPv4 clients of a dual-stack listener are IPv4-mapped IPv6 addresses
+	addr = addr.Unmap()
+
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *allowlist) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !containsAddr(a.prefixes, r.RemoteAddr) {
+			a.denied.Inc()
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (a *allowlist) Describe(ch chan<- *prometheus.Desc) {
+	a.denied.Describe(ch)
+}
+
+func (a *allowlist) Collect(ch chan<- prometheus.Metric) {
+	a.denied.Collect(ch)
+}
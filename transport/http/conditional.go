@@ -0,0 +1,81 @@
+package http
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricsETag returns the validators of the metrics served for the request: they only change
+// with a new extraction (or a pause or a fault injection), so the clients polling faster than
+// the extraction interval don't download the same metrics again. The tag also depends on
+// what's requested (e.g. collect[] or the format). The families computed at scrape time are
+// left out, so the clients keep the values of the response they have until the next
+// extraction. If there has been no extraction yet, or the metrics have other families that
+// change between extractions (e.g. of the servers), ok is false
+func metricsETag(e *casexporter.CasExporter, r *http.Request, mfs []*dto.MetricFamily, families, scrapeTime map[string]bool) (etag string, modified time.Time, ok bool) {
+	modified = e.ExtractedAt()
+	if modified.IsZero() {
+		return "", time.Time{}, false
+	}
+
+	for _, mf := range mfs {
+		if !families[mf.GetName()] && !scrapeTime[mf.GetName()] {
+			return "", time.Time{}, false
+		}
+	}
+
+	// The fault changes the metrics until it's cleared or expires
+	fault := e.FaultState()
+	var faultSince int64
+	if fault.Since != nil {
+		faultSince = fault.Since.UnixNano()
+	}
+
+	h := fnv.New64a()
+	for _, s := range []string{
+		r.URL.Query().Encode(),
+		r.Header.Get("Accept"),
+		fmt.Sprint(e.Paused()),
+		fmt.Sprint(fault.Fault, fault.CacheID, faultSince),
+	} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+
+	// It's weak because the body depends on the compression too
+	return fmt.Sprintf(`W/"%x-%x"`, modified.UnixNano(), h.Sum64()), modified, true
+}
+
+// notModified returns whether the client already has the metrics, with If-None-Match or,
+// if it isn't sent, If-Modified-Since
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+
+		// The header has a resolution of seconds
+		return !modified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
@@ -0,0 +1,100 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casadm"
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+)
+
+// fixtures are the casadm output of a host with a cache and a core
+var fixtures = map[string][]byte{
+	"list-caches.csv": []byte(`type,id,disk,status,write policy,device
+cache,1,/dev/nvme0n1,Running,wt,-
+core,1,/dev/sda,Active,-,/dev/cas1-1
+`),
+	"stats-1.csv": []byte(`Cache Id,Cache Size [4KiB Blocks],Occupancy [4KiB Blocks],Occupancy [%],Dirty [%],Read hits [Requests],Read hits [%],Write hits [%],Total errors [Requests],Write Policy,Status
+1,1000,410,50.0,10.0,1410,90.0,80.0,0,wt,Running
+`),
+	"io-classes-1.csv": []byte(`IO class ID,IO class name,Eviction priority,Allocation
+0,unclassified,22,1.00
+`),
+	"io-class-stats-1.csv": []byte(`IO class ID,IO class name,Pass-Through reads [Requests],Pass-Through writes [Requests]
+0,unclassified,0,0
+`),
+}
+
+// serve starts the server of the exporter, returning the address it listens on
+func serve(t *testing.T, e *casexporter.CasExporter) string {
+	t.Helper()
+
+	s := &ExporterServer{Addr: "127.0.0.1:0", CasExporter: e}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go s.Serve(ctx, &wg)
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		s.listenersMu.Lock()
+		ln := s.listeners[listenerMetrics]
+		s.listenersMu.Unlock()
+
+		if ln != nil {
+			return ln.Addr().String()
+		}
+	}
+
+	t.Fatal("the server isn't listening")
+	return ""
+}
+
+func TestMetricsNotModified(t *testing.T) {
+	cas := casadm.NewClientWithRunner(&casadm.FixtureRunner{Files: fixtures}, casadm.SchemaOpenCAS)
+	e := casexporter.NewCasExporter(casexporter.Config{ExtractionInterval: time.Minute}, cas)
+	e.Extract(context.Background())
+
+	url := "http://" + serve(t, e) + "/metrics"
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("get metrics: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	// The scrape time metrics of the cache are served, but they don't prevent the validation
+	if !strings.Contains(string(body), "ocf_cache_last_update_seconds{") {
+		t.Fatal("the metrics don't have the series of the cache")
+	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("the metrics don't have an ETag")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get metrics: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("unexpected status %d with If-None-Match, expected %d", resp.StatusCode, http.StatusNotModified)
+	}
+}
@@ -0,0 +1,53 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// maxFixturesSize is the maximum size of the fixture files posted together
+const maxFixturesSize = 10 << 20
+
+// handleFixtures extracts the stats from the casadm output posted as multipart form files,
+// named like the ones of the input directory (list-caches.csv, stats-<cache id>.csv...), and
+// returns the resulting metrics
+func (s *ExporterServer) handleFixtures(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFixturesSize)
+	if err := r.ParseMultipartForm(maxFixturesSize); err != nil {
+		http.Error(w, fmt.Sprintf("parse fixture files: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	files := map[string][]byte{}
+	for name, headers := range r.MultipartForm.File {
+		f, err := headers[0].Open()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("open fixture file '%s': %s", name, err), http.StatusBadRequest)
+			return
+		}
+
+		b, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("read fixture file '%s': %s", name, err), http.StatusBadRequest)
+			return
+		}
+
+		files[name] = b
+	}
+
+	if _, ok := files["list-caches.csv"]; !ok {
+		http.Error(w, "missing fixture file 'list-caches.csv'", http.StatusBadRequest)
+		return
+	}
+
+	e := s.CasExporter.FixtureExporter(files)
+	e.Extract(r.Context())
+
+	promhttp.HandlerFor(e.PoolGatherer(NewRegistry(e)), promhttp.HandlerOpts{
+		ErrorHandling: promhttp.ContinueOnError,
+	}).ServeHTTP(w, r)
+}
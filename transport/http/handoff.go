@@ -0,0 +1,73 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// Names of the listeners passed to the new process during a handoff
+const (
+	listenerMetrics   = "metrics"
+	listenerTelemetry = "telemetry"
+	listenerACME      = "acme"
+)
+
+// listener returns the listener with the name, inherited from the previous process if it
+// has passed it, so the connections waiting to be accepted aren't dropped
+func (s *ExporterServer) listener(name, addr string) (net.Listener, error) {
+	var ln net.Listener
+	if f, ok := s.Inherited[name]; ok {
+		var err error
+		ln, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherit listener: %w", err)
+		}
+
+	} else {
+		var err error
+		ln, err = net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	if s.listeners == nil {
+		s.listeners = map[string]net.Listener{}
+	}
+	s.listeners[name] = ln
+
+	return ln, nil
+}
+
+// ListenerFiles returns the files of the listeners, by name, to pass them to a new process
+// during a handoff. They're duplicates, so they have to be closed once passed
+func (s *ExporterServer) ListenerFiles() (map[string]*os.File, error) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	files := map[string]*os.File{}
+	for name, ln := range s.listeners {
+		fl, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+
+		f, err := fl.File()
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+
+			return nil, fmt.Errorf("get %s listener file: %w", name, err)
+		}
+
+		files[name] = f
+	}
+
+	return files, nil
+}
@@ -2,65 +2,386 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/isard-vdi/CAS_Exporter/audit"
 	"github.com/isard-vdi/CAS_Exporter/casexporter"
 
+	"github.com/gocarina/gocsv"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
+//go:embed ui
+var uiFS embed.FS
+
 type ExporterServer struct {
 	Addr        string
 	CasExporter *casexporter.CasExporter
+	// MaxLabelCombinations is the maximum number of distinct identity label combinations
+	// exposed. If it's 0, there's no limit
+	MaxLabelCombinations int
+	// Config is the exporter configuration, included in the snapshot archives
+	Config map[string]string
+	// DefaultCollectors are the collectors exposed when the scrape doesn't select them with
+	// collect[]. If it's empty, all of them are exposed
+	DefaultCollectors []string
+	// TelemetryAddr is the address the operational metrics of the exporter (extraction
+	// errors, HTTP requests, Go runtime...) are served at. If it's empty, they aren't served
+	TelemetryAddr string
+	// AdminToken is the bearer token of the maintenance endpoints (e.g. /api/v1/pause). If
+	// it's empty, they aren't served
+	AdminToken string
+	// ReadOnly refuses the admin actions that change the caches (e.g. the cache mode), so
+	// the exporter can only change its own state
+	ReadOnly bool
+	// FaultInjection enables the admin endpoint that injects faults in the casadm commands
+	// (/api/v1/fault-inject), to rehearse the alerting
+	FaultInjection bool
+	// Audit records the admin actions. If it's nil, they aren't recorded
+	Audit *audit.Log
+	// Dev enables the development endpoints (e.g. /fixtures)
+	Dev bool
+	// TLSCertFile and TLSKeyFile are the certificate and key the HTTP servers use. They're
+	// reloaded when they change. If they're empty, the servers use plain HTTP
+	TLSCertFile string
+	TLSKeyFile  string
+	// ACME obtains and renews the certificate of the HTTP servers automatically, instead of
+	// reading it from TLSCertFile and TLSKeyFile
+	ACME ACMEConfig
+	// AllowedCIDRs are the client ranges allowed to use the HTTP servers. If it's empty, all
+	// the clients are allowed
+	AllowedCIDRs []netip.Prefix
+	// ProxyProtocolCIDRs are the ranges of the load balancers whose connections can start
+	// with a PROXY protocol header, with the address of the client. If it's empty, the
+	// header isn't accepted
+	ProxyProtocolCIDRs []netip.Prefix
+	// Collectors are the operational metrics of the rest of the exporter components (e.g.
+	// the outputs), exposed with the OCF metrics and in the telemetry listener
+	Collectors []prometheus.Collector
+	// Inherited are the listeners passed by the previous process during a handoff, by name.
+	// The ones missing are created
+	Inherited map[string]*os.File
+
+	listenersMu sync.Mutex
+	listeners   map[string]net.Listener
+	// snapshotting is whether a snapshot archive is being written, since each one runs
+	// casadm for every cache
+	snapshotting atomic.Bool
 }
 
-func (s *ExporterServer) Serve(ctx context.Context, wg *sync.WaitGroup) {
+// NewRegistry returns the registry with all the metrics exposed by the exporter
+func NewRegistry(e *casexporter.CasExporter) *prometheus.Registry {
 	reg := prometheus.NewRegistry()
-	reg.MustRegister(version.NewCollector("ocf"))
-	reg.MustRegister(s.CasExporter)
+
+	r := prometheus.WrapRegistererWith(e.ConstLabels(), reg)
+	r.MustRegister(version.NewCollector("ocf"))
+	r.MustRegister(e)
+	r.MustRegister(e.Collectors()...)
+
+	return reg
+}
+
+func (s *ExporterServer) Serve(ctx context.Context, wg *sync.WaitGroup) {
+	reg := NewRegistry(s.CasExporter)
+	if s.Audit != nil {
+		prometheus.WrapRegistererWith(s.CasExporter.ConstLabels(), reg).MustRegister(s.Audit)
+	}
+
+	guard := s.CasExporter.PoolGatherer(casexporter.CardinalityGuard(reg, s.MaxLabelCombinations))
+
+	// The metrics of the servers themselves, exposed with the OCF metrics and in the
+	// telemetry listener
+	serverCollectors := append([]prometheus.Collector{}, s.Collectors...)
+
+	var tlsConfig *tls.Config
+	var acmeChallenges *http.Server
+
+	var certs *certReloader
+	switch {
+	case s.TLSCertFile != "":
+		var err error
+		certs, err = newCertReloader(s.TLSCertFile, s.TLSKeyFile)
+		if err != nil {
+			slog.Error("load tls certificate",
+				slog.String("cert_file", s.TLSCertFile),
+				slog.String("key_file", s.TLSKeyFile),
+				slog.String("err", err.Error()),
+			)
+			os.Exit(1)
+		}
+
+		serverCollectors = append(serverCollectors, certs)
+		go certs.watch(ctx)
+
+		tlsConfig = certs.tlsConfig()
+
+	case len(s.ACME.Domains) != 0:
+		tlsConfig, acmeChallenges = s.ACME.acmeServer()
+	}
+
+	// If there are no allowed ranges, all the clients are allowed
+	allow := func(h http.Handler) http.Handler { return h }
+	if len(s.AllowedCIDRs) != 0 {
+		a := newAllowlist(s.AllowedCIDRs)
+		serverCollectors = append(serverCollectors, a)
+		allow = a.handler
+	}
+
+	prometheus.WrapRegistererWith(s.CasExporter.ConstLabels(), reg).MustRegister(serverCollectors...)
+
+	var t *telemetry
+	if s.TelemetryAddr != "" {
+		t = newTelemetry(s.CasExporter, s.Audit)
+		prometheus.WrapRegistererWith(s.CasExporter.ConstLabels(), t.reg).MustRegister(serverCollectors...)
+	}
 
 	m := http.NewServeMux()
-	m.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+	handle := func(pattern string, h http.Handler) {
+		if t == nil {
+			m.Handle(pattern, h)
+			return
+		}
+
+		// The handler label is the path, without the method
+		path := pattern
+		if _, p, ok := strings.Cut(pattern, " "); ok {
+			path = p
+		}
+
+		m.Handle(pattern, t.instrument(path, h))
+	}
+	handleFunc := func(pattern string, h http.HandlerFunc) {
+		handle(pattern, h)
+	}
+
+	// The families the conditional requests are validated for, along with the build info
+	extractionFamilies := s.CasExporter.ExtractionFamilies()
+	extractionFamilies["ocf_build_info"] = true
+	scrapeTimeFamilies := s.CasExporter.ScrapeTimeFamilies()
+
+	handleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		promhttp.HandlerFor(prometheus.Gatherers{reg}, promhttp.HandlerOpts{
+		profile, err := casexporter.ParseProfile(r.URL.Query().Get("profile"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		collect := r.URL.Query()["collect[]"]
+		if len(collect) == 0 {
+			collect = s.DefaultCollectors
+		}
+
+		g, err := s.CasExporter.CollectorsGatherer(guard, collect)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		g, err = casexporter.CacheGatherer(g, r.URL.Query().Get("cache_id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The metrics are gathered once, so the validators are of what's served
+		mfs, gerr := profile.Gatherer(g).Gather()
+		if etag, modified, ok := metricsETag(s.CasExporter, r, mfs, extractionFamilies, scrapeTimeFamilies); ok {
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+			if notModified(r, etag, modified) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		gathered := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return mfs, gerr })
+		promhttp.HandlerFor(gathered, promhttp.HandlerOpts{
 			ErrorHandling:       promhttp.ContinueOnError,
 			MaxRequestsInFlight: 40,
 		}).ServeHTTP(w, r)
 
 		slog.Info("stats served",
 			slog.Duration("duration", time.Since(start)),
+			slog.String("profile", string(profile)),
 		)
 	})
 
-	srv := http.Server{
-		Addr:    s.Addr,
-		Handler: m,
+	handleFunc("GET /api/v1/inventory", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.CasExporter.Inventory())
+	})
+
+	handleFunc("GET /api/v1/history", func(w http.ResponseWriter, r *http.Request) {
+		var cacheID *uint16
+		if id := r.URL.Query().Get("cache_id"); id != "" {
+			n, err := strconv.ParseUint(id, 10, 16)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid cache id '%s'", id), http.StatusBadRequest)
+				return
+			}
+
+			i := uint16(n)
+			cacheID = &i
+		}
+
+		var since time.Time
+		if v := r.URL.Query().Get("since"); v != "" {
+			var err error
+			since, err = parseTime(v)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		writeJSON(w, s.CasExporter.History(cacheID, since))
+	})
+
+	// The catalog describes the metrics of everything registered along with the exporter
+	catalogCollectors := append([]prometheus.Collector{version.NewCollector("ocf")}, s.CasExporter.Collectors()...)
+	if s.Audit != nil {
+		catalogCollectors = append(catalogCollectors, s.Audit)
 	}
+	catalogCollectors = append(catalogCollectors, serverCollectors...)
 
-	go func() {
-		slog.Info("listening http for extraction",
-			slog.String("addr", s.Addr),
-		)
-		if err := srv.ListenAndServe(); err != nil {
-			slog.Error("serve http",
+	handleFunc("GET /api/v1/metrics-catalog", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.CasExporter.MetricsCatalog(catalogCollectors...))
+	})
+
+	handleFunc("GET /api/v1/stats.csv", func(w http.ResponseWriter, r *http.Request) {
+		stats, at := s.CasExporter.Stats()
+
+		w.Header().Set("Content-Type", "text/csv")
+		if !at.IsZero() {
+			w.Header().Set("Last-Modified", at.UTC().Format(http.TimeFormat))
+		}
+
+		if err := gocsv.Marshal(stats, w); err != nil {
+			slog.Error("write stats csv",
 				slog.String("err", err.Error()),
-				slog.String("addr", s.Addr),
 			)
-			os.Exit(1)
 		}
-	}()
+	})
+
+	s.handleAdmin(handleFunc)
+
+	if s.Dev {
+		handleFunc("POST /fixtures", s.handleFixtures)
+	}
+
+	ui, err := fs.Sub(uiFS, "ui")
+	if err != nil {
+		panic(err)
+	}
+	handle("GET /ui/", http.StripPrefix("/ui/", http.FileServerFS(ui)))
+	handle("GET /ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+
+	servers := []*http.Server{{
+		Addr:      s.Addr,
+		Handler:   allow(m),
+		TLSConfig: tlsConfig,
+	}}
+	go s.listen(listenerMetrics, servers[0], s.ProxyProtocolCIDRs, "listening http for extraction")
+
+	if t != nil {
+		servers = append(servers, &http.Server{
+			Addr:      s.TelemetryAddr,
+			Handler:   allow(t.handler()),
+			TLSConfig: tlsConfig,
+		})
+		go s.listen(listenerTelemetry, servers[1], s.ProxyProtocolCIDRs, "listening http for telemetry")
+	}
+
+	if acmeChallenges != nil {
+		servers = append(servers, acmeChallenges)
+		go s.listen(listenerACME, acmeChallenges, nil, "listening http for acme challenges")
+	}
 
 	<-ctx.Done()
 	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	srv.Shutdown(timeout)
+	for _, srv := range servers {
+		srv.Shutdown(timeout)
+	}
 	wg.Done()
 }
+
+// listen serves the server at the listener with the name. If there are trusted proxies, the
+// PROXY protocol header of their connections is accepted
+func (s *ExporterServer) listen(name string, srv *http.Server, proxies []netip.Prefix, msg string) {
+	_, inherited := s.Inherited[name]
+	slog.Info(msg,
+		slog.String("addr", srv.Addr),
+		slog.Bool("tls", srv.TLSConfig != nil),
+		slog.Bool("proxy_protocol", len(proxies) != 0),
+		slog.Bool("inherited", inherited),
+	)
+
+	ln, err := s.listener(name, srv.Addr)
+	if err == nil {
+		if len(proxies) != 0 {
+			ln = proxyListener(ln, proxies)
+		}
+
+		if srv.TLSConfig != nil {
+			// The certificate is served by the TLS configuration, so it can be reloaded
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			err = srv.Serve(ln)
+		}
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("serve http",
+			slog.String("err", err.Error()),
+			slog.String("addr", srv.Addr),
+		)
+		os.Exit(1)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("write json response",
+			slog.String("err", err.Error()),
+		)
+	}
+}
+
+// parseTime parses either a RFC 3339 time or a unix timestamp
+func parseTime(s string) (time.Time, error) {
+	if unix, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(unix)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time '%s': must be RFC 3339 or a unix timestamp", s)
+	}
+
+	return t, nil
+}
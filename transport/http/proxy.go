@@ -0,0 +1,25 @@
+package http
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// proxyListener accepts the PROXY protocol header (v1 or v2) of the connections coming from
+// the trusted load balancers, so the address of the client is the one of the header. The
+// rest of the connections are served as they are, so the clients can't spoof their address
+func proxyListener(ln net.Listener, trusted []netip.Prefix) net.Listener {
+	return &proxyproto.Listener{
+		Listener: ln,
+		ConnPolicy: func(opts proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+			if containsAddr(trusted, opts.Upstream.String()) {
+				// The health checks of the load balancer may not send the header
+				return proxyproto.USE, nil
+			}
+
+			return proxyproto.SKIP, nil
+		},
+	}
+}
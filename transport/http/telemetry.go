@@ -0,0 +1,72 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/isard-vdi/CAS_Exporter/audit"
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// telemetry are the operational metrics of the exporter, served in their own listener
+type telemetry struct {
+	reg *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newTelemetry(e *casexporter.CasExporter, a *audit.Log) *telemetry {
+	t := &telemetry{
+		reg: prometheus.NewRegistry(),
+
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ocf_http_requests_total",
+				Help: "Number of HTTP requests served by the exporter",
+			},
+			[]string{"handler", "code", "method"},
+		),
+		duration: prometheus.NewHistogramVec(
+			e.HistogramOpts(prometheus.HistogramOpts{
+				Name:    "ocf_http_request_duration_seconds",
+				Help:    "Duration of the HTTP requests served by the exporter",
+				Buckets: prometheus.DefBuckets,
+			}),
+			[]string{"handler", "code", "method"},
+		),
+	}
+
+	prometheus.WrapRegistererWith(e.ConstLabels(), t.reg).MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		e.Telemetry(),
+		t.requests,
+		t.duration,
+	)
+
+	if a != nil {
+		prometheus.WrapRegistererWith(e.ConstLabels(), t.reg).MustRegister(a)
+	}
+
+	return t
+}
+
+// instrument records the requests served by the handler
+func (t *telemetry) instrument(name string, h http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+
+	return promhttp.InstrumentHandlerCounter(t.requests.MustCurryWith(labels),
+		promhttp.InstrumentHandlerDuration(t.duration.MustCurryWith(labels), h),
+	)
+}
+
+func (t *telemetry) handler() http.Handler {
+	m := http.NewServeMux()
+	m.Handle("/metrics", promhttp.HandlerFor(t.reg, promhttp.HandlerOpts{}))
+
+	return m
+}
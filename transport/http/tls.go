@@ -0,0 +1,159 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// certReloadInterval is the interval between checks of the certificate files
+const certReloadInterval = 10 * time.Second
+
+// certReloader serves the certificate of the files, reloading it when they change, so
+// short-lived certificates are rotated without restarting the listeners
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// modTimes are the modification times of the files when they were loaded
+	modTimes [2]time.Time
+
+	expiry       prometheus.GaugeFunc
+	reloadErrors prometheus.Counter
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+
+		reloadErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_tls_certificate_reload_errors_total",
+			Help: "Number of failed reloads of the TLS certificate of the exporter",
+		}),
+	}
+	r.expiry = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ocf_tls_certificate_expiry_timestamp_seconds",
+		Help: "Time the TLS certificate served by the exporter expires at",
+	}, func() float64 {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		return float64(r.cert.Leaf.NotAfter.Unix())
+	})
+
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// reload loads the certificate again if the files have changed, returning whether they
+// have
+func (r *certReloader) reload() (bool, error) {
+	modTimes := [2]time.Time{}
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, fmt.Errorf("stat tls file: %w", err)
+		}
+
+		modTimes[i] = info.ModTime()
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTimes == r.modTimes
+	r.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("load tls certificate: %w", err)
+	}
+
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return false, fmt.Errorf("parse tls certificate: %w", err)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTimes = modTimes
+	r.mu.Unlock()
+
+	return true, nil
+}
+
+// watch reloads the certificate when the files change, until the context is done. If the
+// new files can't be loaded (e.g. the key has been written but not the certificate yet),
+// the previous certificate is kept and they're retried on the next check
+func (r *certReloader) watch(ctx context.Context) {
+	t := time.NewTicker(certReloadInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		reloaded, err := r.reload()
+		if err != nil {
+			r.reloadErrors.Inc()
+			slog.Error("reload tls certificate",
+				slog.String("err", err.Error()),
+			)
+			continue
+		}
+
+		if reloaded {
+			r.mu.RLock()
+			notAfter := r.cert.Leaf.NotAfter
+			r.mu.RUnlock()
+
+			slog.Info("tls certificate reloaded",
+				slog.Time("not_after", notAfter),
+			)
+		}
+	}
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+	}
+}
+
+func (r *certReloader) Describe(ch chan<- *prometheus.Desc) {
+	r.expiry.Describe(ch)
+	r.reloadErrors.Describe(ch)
+}
+
+func (r *certReloader) Collect(ch chan<- prometheus.Metric) {
+	r.expiry.Collect(ch)
+	r.reloadErrors.Collect(ch)
+}
@@ -0,0 +1,228 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxPending is the maximum number of messages waiting to be delivered (e.g. while the
+// broker is unreachable). Once it's reached, the caches of the following cycles are dropped,
+// so the messages don't pile up in memory
+const maxPending = 1000
+
+// Config is the configuration of the MQTT publisher
+type Config struct {
+	// Broker is the URL of the broker (e.g. tcp://broker:1883, ssl://broker:8883 or
+	// ws://broker:80/mqtt)
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// TopicPrefix is the prefix of the topics (e.g. cas-exporter/hv1)
+	TopicPrefix string
+	// QoS is the quality of service of the messages. With 1 or 2, the ones published while
+	// the broker is unreachable are delivered once it reconnects
+	QoS byte
+}
+
+// Publisher publishes the state of every cache to an MQTT broker after each extraction,
+// for the edge deployments where the monitoring backhaul is a broker instead of Prometheus.
+// Each cache has its own topic, <prefix>/<cache id>, with the JSON of its inventory as a
+// retained message, and <prefix>/status is online or offline
+type Publisher struct {
+	cfg       Config
+	e         *casexporter.CasExporter
+	extracted <-chan struct{}
+
+	// pending is the number of messages waiting to be delivered
+	pending atomic.Int64
+
+	connected     prometheus.Gauge
+	published     prometheus.Counter
+	publishErrors prometheus.Counter
+	dropped       prometheus.Counter
+
+	// caches are the IDs of the caches with a retained message, which is removed once they
+	// aren't found
+	caches map[uint16]bool
+}
+
+// NewPublisher returns the publisher of the caches of the exporter. It has to be called
+// before the exporter is started
+func NewPublisher(cfg Config, e *casexporter.CasExporter) *Publisher {
+	return &Publisher{
+		cfg:       cfg,
+		e:         e,
+		extracted: e.Subscribe(),
+
+		connected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ocf_mqtt_connected",
+			Help: "Whether the exporter is connected to the MQTT broker",
+		}),
+		published: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_mqtt_messages_published_total",
+			Help: "Number of cache messages published to the MQTT broker",
+		}),
+		publishErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_mqtt_publish_errors_total",
+			Help: "Number of cache messages that have failed to be published to the MQTT broker",
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_mqtt_messages_dropped_total",
+			Help: "Number of cache messages dropped because too many were waiting to be delivered to the MQTT broker",
+		}),
+
+		caches: map[uint16]bool{},
+	}
+}
+
+// cachePayload is the message of a cache
+type cachePayload struct {
+	Time *time.Time `json:"time"`
+	*casexporter.InventoryCache
+}
+
+func (p *Publisher) statusTopic() string {
+	return p.cfg.TopicPrefix + "/status"
+}
+
+func (p *Publisher) cacheTopic(id uint16) string {
+	return p.cfg.TopicPrefix + "/" + strconv.Itoa(int(id))
+}
+
+// Start connects to the broker and publishes the caches after every extraction, until the
+// context is done
+func (p *Publisher) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	opts := paho.NewClientOptions().
+		AddBroker(p.cfg.Broker).
+		SetClientID(p.cfg.ClientID).
+		SetUsername(p.cfg.Username).
+		SetPassword(p.cfg.Password).
+		SetAutoReconnect(true).
+		// The first connection is retried too, so the exporter can start without the broker
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5*time.Second).
+		SetMaxReconnectInterval(time.Minute).
+		SetWill(p.statusTopic(), "offline", p.cfg.QoS, true).
+		SetOnConnectHandler(func(c paho.Client) {
+			p.connected.Set(1)
+			slog.Info("connected to mqtt broker",
+				slog.String("broker", p.cfg.Broker),
+			)
+
+			c.Publish(p.statusTopic(), p.cfg.QoS, true, "online")
+		}).
+		SetConnectionLostHandler(func(c paho.Client, err error) {
+			p.connected.Set(0)
+			slog.Error("mqtt connection lost",
+				slog.String("broker", p.cfg.Broker),
+				slog.String("err", err.Error()),
+			)
+		})
+
+	c := paho.NewClient(opts)
+	c.Connect()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// The will isn't sent on a clean disconnection
+			c.Publish(p.statusTopic(), p.cfg.QoS, true, "offline").WaitTimeout(time.Second)
+			c.Disconnect(250)
+			return
+
+		case <-p.extracted:
+			p.publish(c)
+		}
+	}
+}
+
+// publish publishes the caches of the last extraction. The messages are delivered in the
+// background, so the cycles published while the broker is unreachable are queued
+func (p *Publisher) publish(c paho.Client) {
+	inv := p.e.Inventory()
+
+	messages := map[string][]byte{}
+	found := map[uint16]bool{}
+	for _, cache := range inv.Caches {
+		found[cache.ID] = true
+
+		b, err := json.Marshal(cachePayload{
+			Time:           inv.UpdatedAt,
+			InventoryCache: cache,
+		})
+		if err != nil {
+			p.publishErrors.Inc()
+			slog.Error("marshal mqtt message",
+				slog.Int("cache_id", int(cache.ID)),
+				slog.String("err", err.Error()),
+			)
+			continue
+		}
+
+		messages[p.cacheTopic(cache.ID)] = b
+	}
+
+	// An empty retained message removes the previous one
+	for id := range p.caches {
+		if !found[id] {
+			messages[p.cacheTopic(id)] = []byte{}
+		}
+	}
+
+	if p.pending.Load()+int64(len(messages)) > maxPending {
+		p.dropped.Add(float64(len(messages)))
+		slog.Warn("too many mqtt messages waiting to be delivered, dropping the cycle",
+			slog.Int64("pending", p.pending.Load()),
+		)
+		return
+	}
+	p.caches = found
+
+	for topic, b := range messages {
+		p.pending.Add(1)
+		t := c.Publish(topic, p.cfg.QoS, true, b)
+
+		go func() {
+			<-t.Done()
+			p.pending.Add(-1)
+
+			if err := t.Error(); err != nil {
+				p.publishErrors.Inc()
+				slog.Error("publish mqtt message",
+					slog.String("topic", topic),
+					slog.String("err", err.Error()),
+				)
+				return
+			}
+
+			p.published.Inc()
+		}()
+	}
+}
+
+func (p *Publisher) Describe(ch chan<- *prometheus.Desc) {
+	p.connected.Describe(ch)
+	p.published.Describe(ch)
+	p.publishErrors.Describe(ch)
+	p.dropped.Describe(ch)
+}
+
+func (p *Publisher) Collect(ch chan<- prometheus.Metric) {
+	p.connected.Collect(ch)
+	p.published.Collect(ch)
+	p.publishErrors.Collect(ch)
+	p.dropped.Collect(ch)
+}
@@ -0,0 +1,300 @@
+package snmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Types of the AgentX PDUs (RFC 2741)
+const (
+	pduOpen       = 1
+	pduClose      = 2
+	pduRegister   = 3
+	pduGet        = 5
+	pduGetNext    = 6
+	pduGetBulk    = 7
+	pduTestSet    = 8
+	pduCommitSet  = 9
+	pduUndoSet    = 10
+	pduCleanupSet = 11
+	pduResponse   = 18
+)
+
+// Flags of the AgentX PDUs
+const (
+	flagNonDefaultContext = 0x08
+	flagNetworkByteOrder  = 0x10
+)
+
+// Types of the values of the variable bindings
+const (
+	typeInteger        = 2
+	typeOctetString    = 4
+	typeCounter32      = 65
+	typeGauge32        = 66
+	typeCounter64      = 70
+	typeNoSuchObject   = 128
+	typeNoSuchInstance = 129
+	typeEndOfMIBView   = 130
+)
+
+// Errors of the responses
+const (
+	errNoError         = 0
+	errNotWritable     = 17
+	errProcessingError = 268
+)
+
+// closeShutdown is the reason of the Close PDU sent when the exporter stops
+const closeShutdown = 5
+
+const headerSize = 20
+
+// maxPayloadSize is the maximum size of the PDUs of the master agent
+const maxPayloadSize = 1 << 20
+
+// OID is an SNMP object identifier
+type OID []uint32
+
+// ParseOID parses the dotted OID (e.g. 1.3.6.1.4.1), with or without a leading dot
+func ParseOID(s string) (OID, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+
+	oid := OID{}
+	for _, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid oid '%s'", s)
+		}
+
+		oid = append(oid, uint32(n))
+	}
+
+	return oid, nil
+}
+
+func (o OID) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// Append returns the OID followed by the sub-identifiers
+func (o OID) Append(ids ...uint32) OID {
+	return append(slices.Clone(o), ids...)
+}
+
+// HasPrefix returns whether the OID is in the subtree of prefix
+func (o OID) HasPrefix(prefix OID) bool {
+	return len(o) >= len(prefix) && slices.Equal(o[:len(prefix)], prefix)
+}
+
+// header is the header of the AgentX PDUs
+type header struct {
+	typ           byte
+	flags         byte
+	sessionID     uint32
+	transactionID uint32
+	packetID      uint32
+}
+
+// varBind is a variable binding, the value of an OID
+type varBind struct {
+	oid   OID
+	typ   uint16
+	value any
+}
+
+// searchRange is a range of OIDs of the Get, GetNext and GetBulk requests. If end is empty,
+// there's no upper bound
+type searchRange struct {
+	start   OID
+	include bool
+	end     OID
+}
+
+// encoder writes the PDUs, always in network byte order
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) uint16(v uint16) { e.b = binary.BigEndian.AppendUint16(e.b, v) }
+func (e *encoder) uint32(v uint32) { e.b = binary.BigEndian.AppendUint32(e.b, v) }
+func (e *encoder) uint64(v uint64) { e.b = binary.BigEndian.AppendUint64(e.b, v) }
+
+// oid writes the OID, using the prefix of the internet subtree (1.3.6.1.x) if it can
+func (e *encoder) oid(o OID, include bool) {
+	prefix := byte(0)
+	if len(o) >= 5 && o.HasPrefix(OID{1, 3, 6, 1}) && o[4] != 0 && o[4] < 256 {
+		prefix = byte(o[4])
+		o = o[5:]
+	}
+
+	e.b = append(e.b, byte(len(o)), prefix, boolByte(include), 0)
+	for _, n := range o {
+		e.uint32(n)
+	}
+}
+
+func (e *encoder) octetString(s string) {
+	e.uint32(uint32(len(s)))
+	e.b = append(e.b, s...)
+	for len(e.b)%4 != 0 {
+		e.b = append(e.b, 0)
+	}
+}
+
+func (e *encoder) varBind(vb varBind) {
+	e.uint16(vb.typ)
+	e.uint16(0)
+	e.oid(vb.oid, false)
+
+	switch vb.typ {
+	case typeInteger:
+		e.uint32(uint32(vb.value.(int32)))
+	case typeOctetString:
+		e.octetString(vb.value.(string))
+	case typeCounter32, typeGauge32:
+		e.uint32(vb.value.(uint32))
+	case typeCounter64:
+		e.uint64(vb.value.(uint64))
+	}
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// pdu returns the PDU with the header and the payload
+func pdu(h header, payload []byte) []byte {
+	e := &encoder{b: make([]byte, 0, headerSize+len(payload))}
+	e.b = append(e.b, 1, h.typ, h.flags|flagNetworkByteOrder, 0)
+	e.uint32(h.sessionID)
+	e.uint32(h.transactionID)
+	e.uint32(h.packetID)
+	e.uint32(uint32(len(payload)))
+
+	return append(e.b, payload...)
+}
+
+// decoder reads the payload of the PDUs, in the byte order of their header
+type decoder struct {
+	b     []byte
+	order binary.ByteOrder
+	err   error
+}
+
+var errShortPDU = errors.New("short pdu")
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || len(d.b) < n {
+		d.err = errShortPDU
+		return make([]byte, n)
+	}
+
+	b := d.b[:n]
+	d.b = d.b[n:]
+
+	return b
+}
+
+func (d *decoder) uint16() uint16 { return d.order.Uint16(d.next(2)) }
+func (d *decoder) uint32() uint32 { return d.order.Uint32(d.next(4)) }
+
+func (d *decoder) oid() (OID, bool) {
+	h := d.next(4)
+	n, prefix, include := int(h[0]), h[1], h[2] != 0
+
+	o := OID{}
+	if prefix != 0 {
+		o = OID{1, 3, 6, 1, uint32(prefix)}
+	}
+	for i := 0; i < n && d.err == nil; i++ {
+		o = append(o, d.uint32())
+	}
+
+	return o, include
+}
+
+func (d *decoder) octetString() string {
+	n := int(d.uint32())
+	if n > len(d.b) {
+		d.err = errShortPDU
+		return ""
+	}
+
+	s := string(d.next(n))
+	if pad := (4 - n%4) % 4; pad != 0 {
+		d.next(pad)
+	}
+
+	return s
+}
+
+// searchRanges reads the search ranges up to the end of the payload
+func (d *decoder) searchRanges() []searchRange {
+	ranges := []searchRange{}
+	for len(d.b) != 0 && d.err == nil {
+		start, include := d.oid()
+		end, _ := d.oid()
+		ranges = append(ranges, searchRange{start: start, include: include, end: end})
+	}
+
+	return ranges
+}
+
+// readPDU reads a PDU, returning its header and its payload
+func readPDU(r io.Reader) (header, *decoder, error) {
+	b := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return header{}, nil, err
+	}
+
+	if b[0] != 1 {
+		return header{}, nil, fmt.Errorf("unsupported agentx version %d", b[0])
+	}
+
+	h := header{typ: b[1], flags: b[2]}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if h.flags&flagNetworkByteOrder != 0 {
+		order = binary.BigEndian
+	}
+	h.sessionID = order.Uint32(b[4:])
+	h.transactionID = order.Uint32(b[8:])
+	h.packetID = order.Uint32(b[12:])
+
+	size := order.Uint32(b[16:])
+	if size > maxPayloadSize {
+		return header{}, nil, fmt.Errorf("too large pdu: %d bytes", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return header{}, nil, err
+	}
+
+	d := &decoder{b: payload, order: order}
+
+	// Only the default context is registered, but the context has to be skipped
+	if h.flags&flagNonDefaultContext != 0 {
+		switch h.typ {
+		case pduGet, pduGetNext, pduGetBulk, pduTestSet:
+			d.octetString()
+		}
+	}
+
+	return h, d, nil
+}
@@ -0,0 +1,430 @@
+package snmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultBaseOID is the subtree of the CAS-EXPORTER-MIB, in the Net-SNMP playpen
+// (netSnmpPlaypen.2114), which is meant for private MIBs without an enterprise number
+const DefaultBaseOID = "1.3.6.1.4.1.8072.9999.9999.2114"
+
+// reconnectInterval is the time between the connection attempts to the master agent
+const reconnectInterval = 5 * time.Second
+
+// timeout is the timeout of the session, in seconds, after which the master agent considers
+// the requests failed
+const timeout = 5
+
+// priority is the priority of the registration. 127 is the default one
+const priority = 127
+
+// Columns of the casCacheTable. The first one, casCacheId, is the index, so it's only part
+// of the OIDs of the rest
+const (
+	colCacheDevice = iota + 2
+	colCacheStatus
+	colCacheWritePolicy
+	colCacheStatsValid
+	colCacheOccupancy
+	colCacheDirty
+	colCacheReadHits
+	colCacheWriteHits
+	colCacheErrors
+	colCacheCoreErrors
+	colCacheInactiveCores
+	colCacheDirtyFor
+)
+
+// TruthValue of SNMPv2-TC
+const (
+	truthTrue  int32 = 1
+	truthFalse int32 = 2
+)
+
+// Config is the configuration of the AgentX subagent
+type Config struct {
+	// Network and Address are the ones of the master agent (e.g. unix and /var/agentx/master,
+	// or tcp and localhost:705)
+	Network string
+	Address string
+	// BaseOID is the subtree registered
+	BaseOID OID
+}
+
+// Subagent exposes the headline stats of the caches through an AgentX (RFC 2741) subagent
+// of the SNMP master agent of the host (e.g. Net-SNMP snmpd), under the CAS-EXPORTER-MIB, so
+// the legacy NMS that only speak SNMP can monitor the caches. The objects are read-only and
+// refreshed after every extraction
+type Subagent struct {
+	cfg       Config
+	e         *casexporter.CasExporter
+	extracted <-chan struct{}
+
+	// sessionID is the ID of the session opened with the master agent
+	sessionID atomic.Uint32
+
+	mu sync.Mutex
+	// vars are the values of the objects, sorted by OID
+	vars []varBind
+
+	connected prometheus.Gauge
+	requests  prometheus.Counter
+}
+
+// NewSubagent returns the subagent of the caches of the exporter. It has to be called before
+// the exporter is started
+func NewSubagent(cfg Config, e *casexporter.CasExporter) *Subagent {
+	return &Subagent{
+		cfg:       cfg,
+		e:         e,
+		extracted: e.Subscribe(),
+
+		connected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ocf_snmp_agentx_connected",
+			Help: "Whether the exporter is connected to the SNMP master agent, with its subtree registered",
+		}),
+		requests: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_snmp_requests_total",
+			Help: "Number of AgentX requests (Get, GetNext and GetBulk) answered",
+		}),
+	}
+}
+
+// Start connects to the master agent and answers its requests, reconnecting if the
+// connection is lost (e.g. snmpd is restarted), until the context is done
+func (s *Subagent) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.extracted:
+				s.refresh(s.e.Inventory())
+			}
+		}
+	}()
+
+	for {
+		err := s.serve(ctx)
+		s.connected.Set(0)
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Error("serve snmp agentx subagent",
+			slog.String("address", s.cfg.Address),
+			slog.String("err", err.Error()),
+		)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+// serve opens a session with the master agent, registers the subtree and answers the
+// requests until the connection is closed
+func (s *Subagent) serve(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, s.cfg.Network, s.cfg.Address)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			// The master agent drops the registrations of the session right away
+			e := &encoder{}
+			e.b = append(e.b, closeShutdown, 0, 0, 0)
+			conn.SetWriteDeadline(time.Now().Add(time.Second))
+			conn.Write(pdu(header{typ: pduClose, sessionID: s.sessionID.Load()}, e.b))
+			conn.Close()
+		}
+	}()
+
+	// Open
+	e := &encoder{}
+	e.b = append(e.b, timeout, 0, 0, 0)
+	e.oid(s.cfg.BaseOID, false)
+	e.octetString("cas-exporter")
+	sessionID, err := s.request(conn, header{typ: pduOpen, packetID: 1}, e.b)
+	if err != nil {
+		return fmt.Errorf("open session: %w", err)
+	}
+	s.sessionID.Store(sessionID)
+
+	// Register
+	e = &encoder{}
+	e.b = append(e.b, 0, priority, 0, 0)
+	e.oid(s.cfg.BaseOID, false)
+	if _, err := s.request(conn, header{typ: pduRegister, sessionID: sessionID, packetID: 2}, e.b); err != nil {
+		return fmt.Errorf("register subtree %s: %w", s.cfg.BaseOID, err)
+	}
+
+	s.connected.Set(1)
+	slog.Info("registered snmp agentx subagent",
+		slog.String("address", s.cfg.Address),
+		slog.String("oid", s.cfg.BaseOID.String()),
+	)
+
+	for {
+		h, d, err := readPDU(conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return errors.New("connection closed by the master agent")
+			}
+
+			return fmt.Errorf("read pdu: %w", err)
+		}
+
+		switch h.typ {
+		case pduClose:
+			return errors.New("session closed by the master agent")
+
+		// The responses of the pings of the master agent, and the sets that aren't
+		// answered since they've failed on the test
+		case pduResponse, pduCommitSet, pduUndoSet, pduCleanupSet:
+			continue
+		}
+
+		if _, err := conn.Write(s.response(h, d)); err != nil {
+			return fmt.Errorf("write response: %w", err)
+		}
+	}
+}
+
+// request sends a PDU and waits for its response, returning the session ID
+func (s *Subagent) request(conn net.Conn, h header, payload []byte) (uint32, error) {
+	conn.SetDeadline(time.Now().Add(timeout * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write(pdu(h, payload)); err != nil {
+		return 0, err
+	}
+
+	for {
+		rh, d, err := readPDU(conn)
+		if err != nil {
+			return 0, err
+		}
+		if rh.typ != pduResponse || rh.packetID != h.packetID {
+			continue
+		}
+
+		d.uint32()
+		if code := d.uint16(); code != errNoError {
+			return 0, fmt.Errorf("agentx error %d", code)
+		}
+		if d.err != nil {
+			return 0, d.err
+		}
+
+		return rh.sessionID, nil
+	}
+}
+
+// response returns the Response PDU of the request of the master agent
+func (s *Subagent) response(h header, d *decoder) []byte {
+	s.requests.Inc()
+
+	s.mu.Lock()
+	vars := s.vars
+	s.mu.Unlock()
+
+	code, index := uint16(errNoError), uint16(0)
+	results := []varBind{}
+
+	switch h.typ {
+	case pduGet:
+		for _, r := range d.searchRanges() {
+			results = append(results, get(vars, r.start, s.cfg.BaseOID))
+		}
+
+	case pduGetNext:
+		for _, r := range d.searchRanges() {
+			results = append(results, getNext(vars, r))
+		}
+
+	case pduGetBulk:
+		nonRepeaters := int(d.uint16())
+		maxRepetitions := int(d.uint16())
+		ranges := d.searchRanges()
+
+		for i := 0; i < nonRepeaters && i < len(ranges); i++ {
+			results = append(results, getNext(vars, ranges[i]))
+		}
+
+		repeaters := ranges[min(nonRepeaters, len(ranges)):]
+		for i := 0; i < maxRepetitions && len(repeaters) != 0; i++ {
+			end := true
+			for j, r := range repeaters {
+				vb := getNext(vars, r)
+				results = append(results, vb)
+
+				if vb.typ != typeEndOfMIBView {
+					end = false
+				}
+				repeaters[j] = searchRange{start: vb.oid, end: r.end}
+			}
+
+			if end {
+				break
+			}
+		}
+
+	case pduTestSet:
+		code, index = errNotWritable, 1
+
+	default:
+		code = errProcessingError
+	}
+
+	if d.err != nil {
+		code, index, results = errProcessingError, 0, nil
+	}
+
+	e := &encoder{}
+	// sysUpTime is only meaningful in the responses to the subagent
+	e.uint32(0)
+	e.uint16(code)
+	e.uint16(index)
+	for _, vb := range results {
+		e.varBind(vb)
+	}
+
+	return pdu(header{
+		typ:           pduResponse,
+		sessionID:     h.sessionID,
+		transactionID: h.transactionID,
+		packetID:      h.packetID,
+	}, e.b)
+}
+
+// get returns the value of the OID. The OIDs of the missing objects are noSuchObject, and
+// the missing instances of existing objects (e.g. a cache that isn't running) noSuchInstance
+func get(vars []varBind, oid, base OID) varBind {
+	i, found := slices.BinarySearchFunc(vars, oid, func(vb varBind, oid OID) int {
+		return slices.Compare(vb.oid, oid)
+	})
+	if found {
+		return vars[i]
+	}
+
+	typ := uint16(typeNoSuchObject)
+	if oid.HasPrefix(base) && len(oid) > len(base)+1 {
+		// The scalars and the columns of the table
+		for _, vb := range vars {
+			if vb.oid.HasPrefix(oid[:len(oid)-1]) {
+				typ = typeNoSuchInstance
+				break
+			}
+		}
+	}
+
+	return varBind{oid: oid, typ: typ}
+}
+
+// getNext returns the first value after the start of the range, and before its end
+func getNext(vars []varBind, r searchRange) varBind {
+	i, found := slices.BinarySearchFunc(vars, r.start, func(vb varBind, oid OID) int {
+		return slices.Compare(vb.oid, oid)
+	})
+	if found && !r.include {
+		i++
+	}
+
+	if i < len(vars) && (len(r.end) == 0 || slices.Compare(vars[i].oid, r.end) < 0) {
+		return vars[i]
+	}
+
+	return varBind{oid: r.start, typ: typeEndOfMIBView}
+}
+
+// refresh updates the values of the objects with the inventory of the last extraction
+func (s *Subagent) refresh(inv *casexporter.Inventory) {
+	base := s.cfg.BaseOID
+	scalar := func(id uint32) OID { return base.Append(1, id, 0) }
+	column := func(col, index uint32) OID { return base.Append(2, 1, col, index) }
+
+	success := truthFalse
+	if inv.Success {
+		success = truthTrue
+	}
+
+	vars := []varBind{
+		{oid: scalar(1), typ: typeInteger, value: success},
+		{oid: scalar(2), typ: typeGauge32, value: uint32(len(inv.Caches))},
+	}
+
+	for _, c := range inv.Caches {
+		id := uint32(c.ID)
+		vars = append(vars,
+			varBind{oid: column(colCacheDevice, id), typ: typeOctetString, value: c.Device},
+			varBind{oid: column(colCacheStatus, id), typ: typeOctetString, value: c.Status},
+			varBind{oid: column(colCacheWritePolicy, id), typ: typeOctetString, value: c.WritePolicy},
+		)
+
+		if c.Stats == nil {
+			vars = append(vars, varBind{oid: column(colCacheStatsValid, id), typ: typeInteger, value: truthFalse})
+			continue
+		}
+
+		vars = append(vars,
+			varBind{oid: column(colCacheStatsValid, id), typ: typeInteger, value: truthTrue},
+			varBind{oid: column(colCacheOccupancy, id), typ: typeGauge32, value: hundredths(c.Stats.OccupancyPercent)},
+			varBind{oid: column(colCacheDirty, id), typ: typeGauge32, value: hundredths(c.Stats.DirtyPercent)},
+			varBind{oid: column(colCacheReadHits, id), typ: typeGauge32, value: hundredths(c.Stats.ReadHitsPercent)},
+			varBind{oid: column(colCacheWriteHits, id), typ: typeGauge32, value: hundredths(c.Stats.WriteHitsPercent)},
+			varBind{oid: column(colCacheErrors, id), typ: typeCounter64, value: uint64(max(c.Stats.CacheErrors, 0))},
+			varBind{oid: column(colCacheCoreErrors, id), typ: typeCounter64, value: uint64(max(c.Stats.CoreErrors, 0))},
+			varBind{oid: column(colCacheInactiveCores, id), typ: typeGauge32, value: uint32(max(c.Stats.InactiveCores, 0))},
+			varBind{oid: column(colCacheDirtyFor, id), typ: typeGauge32, value: uint32(max(c.Stats.DirtyForSeconds, 0))},
+		)
+	}
+
+	slices.SortFunc(vars, func(a, b varBind) int {
+		return slices.Compare(a.oid, b.oid)
+	})
+
+	s.mu.Lock()
+	s.vars = vars
+	s.mu.Unlock()
+}
+
+// hundredths returns the percentage in hundredths of a percent, since SNMP has no floats
+func hundredths(percent float64) uint32 {
+	return uint32(math.Round(math.Max(0, percent) * 100))
+}
+
+func (s *Subagent) Describe(ch chan<- *prometheus.Desc) {
+	s.connected.Describe(ch)
+	s.requests.Describe(ch)
+}
+
+func (s *Subagent) Collect(ch chan<- prometheus.Metric) {
+	s.connected.Collect(ch)
+	s.requests.Collect(ch)
+}
@@ -0,0 +1,331 @@
+package zabbix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isard-vdi/CAS_Exporter/casexporter"
+	"github.com/isard-vdi/CAS_Exporter/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultPort is the port of the trapper of the Zabbix servers and proxies
+const DefaultPort = "10051"
+
+// requestTimeout is the maximum time sending the items of an extraction can take
+const requestTimeout = 10 * time.Second
+
+// maxResponseSize is the maximum size of the responses of the server
+const maxResponseSize = 1 << 20
+
+// header is the start of the messages of the Zabbix protocol, followed by the flags (no
+// compression)
+var header = []byte("ZBXD\x01")
+
+// Config is the configuration of the Zabbix sender
+type Config struct {
+	// Server is the address of the Zabbix server or proxy (e.g. zabbix:10051)
+	Server string
+	// Host is the name of the host in Zabbix
+	Host string
+	// Keys are the item keys of the stats sent, indexed by stat
+	Keys map[string]string
+}
+
+// Item is a value of a trapper item of the host
+type Item struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+	NS    int    `json:"ns"`
+}
+
+type request struct {
+	Request string  `json:"request"`
+	Data    []*Item `json:"data"`
+	Clock   int64   `json:"clock"`
+	NS      int     `json:"ns"`
+}
+
+type response struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+// Sender sends the stats of every cache to a Zabbix server or proxy after each extraction,
+// with the sender protocol (like zabbix_sender), for the sites whose monitoring is still
+// standardized on Zabbix. The items have to be of the trapper type
+type Sender struct {
+	cfg       Config
+	e         *casexporter.CasExporter
+	extracted <-chan struct{}
+
+	sent       prometheus.Counter
+	failed     prometheus.Counter
+	sendErrors prometheus.Counter
+}
+
+// NewSender returns the sender of the caches of the exporter. It has to be called before the
+// exporter is started
+func NewSender(cfg Config, e *casexporter.CasExporter) *Sender {
+	return &Sender{
+		cfg:       cfg,
+		e:         e,
+		extracted: e.Subscribe(),
+
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_zabbix_items_sent_total",
+			Help: "Number of cache items processed by the Zabbix server",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_zabbix_items_failed_total",
+			Help: "Number of cache items rejected by the Zabbix server (e.g. items that don't exist or aren't of the trapper type)",
+		}),
+		sendErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ocf_zabbix_send_errors_total",
+			Help: "Number of extractions whose items have failed to be sent to the Zabbix server",
+		}),
+	}
+}
+
+// Start sends the items after every extraction, until the context is done
+func (s *Sender) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-s.extracted:
+			s.send(ctx)
+		}
+	}
+}
+
+func (s *Sender) send(ctx context.Context) {
+	items := s.items(s.e.Inventory())
+	if len(items) == 0 {
+		return
+	}
+
+	processed, failed, err := s.request(ctx, items)
+	if err != nil {
+		// The requests cancelled by the shutdown aren't errors
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The items aren't retried, the next extraction has fresher stats
+		s.sendErrors.Inc()
+		slog.Error("send zabbix items",
+			slog.String("server", s.cfg.Server),
+			slog.String("err", err.Error()),
+		)
+		return
+	}
+
+	s.sent.Add(float64(processed))
+	s.failed.Add(float64(failed))
+	if failed != 0 {
+		slog.Warn("zabbix items rejected",
+			slog.String("server", s.cfg.Server),
+			slog.Int("failed", failed),
+			slog.Int("processed", processed),
+		)
+	}
+}
+
+// items returns the items of the stats of the caches of the inventory. The caches without
+// stats (e.g. they've failed) aren't sent, so the Zabbix triggers on missing data fire
+func (s *Sender) items(inv *casexporter.Inventory) []*Item {
+	if inv.UpdatedAt == nil {
+		return nil
+	}
+
+	stats := make([]string, 0, len(s.cfg.Keys))
+	for stat := range s.cfg.Keys {
+		stats = append(stats, stat)
+	}
+	slices.Sort(stats)
+
+	items := []*Item{}
+	for _, cache := range inv.Caches {
+		if cache.Stats == nil {
+			continue
+		}
+
+		at := *inv.UpdatedAt
+		if cache.UpdatedAt != nil {
+			at = *cache.UpdatedAt
+		}
+
+		values := statValues(cache.Stats)
+		for _, stat := range stats {
+			v, ok := values[stat]
+			if !ok {
+				continue
+			}
+
+			items = append(items, &Item{
+				Host:  s.cfg.Host,
+				Key:   itemKey(s.cfg.Keys[stat], stat, cache),
+				Value: strconv.FormatFloat(v, 'f', -1, 64),
+				Clock: at.Unix(),
+				NS:    at.Nanosecond(),
+			})
+		}
+	}
+
+	return items
+}
+
+// itemKey returns the key of the item of the stat of the cache
+func itemKey(key, stat string, cache *casexporter.InventoryCache) string {
+	return strings.NewReplacer(
+		"{stat}", stat,
+		"{cache_id}", strconv.Itoa(int(cache.ID)),
+		"{device}", cache.Device,
+	).Replace(key)
+}
+
+// statValues returns the values of the stats, named like in the inventory API
+func statValues(stats *casexporter.InventoryStats) map[string]float64 {
+	values := map[string]float64{}
+
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return values
+	}
+	json.Unmarshal(b, &values)
+
+	return values
+}
+
+var infoRegexp = regexp.MustCompile(`processed: (\d+); failed: (\d+)`)
+
+// request sends the items to the server, returning the number of items processed and failed
+func (s *Sender) request(ctx context.Context, items []*Item) (processed, failed int, err error) {
+	now := time.Now()
+	body, err := json.Marshal(request{
+		Request: "sender data",
+		Data:    items,
+		Clock:   now.Unix(),
+		NS:      now.Nanosecond(),
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("marshal request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.cfg.Server)
+	if err != nil {
+		return 0, 0, fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(message(body)); err != nil {
+		return 0, 0, fmt.Errorf("write request: %w", err)
+	}
+
+	b, err := readMessage(conn)
+	if err != nil {
+		return 0, 0, fmt.Errorf("read response: %w", err)
+	}
+
+	var rsp response
+	if err := json.Unmarshal(b, &rsp); err != nil {
+		return 0, 0, fmt.Errorf("unmarshal response: %w", err)
+	}
+
+	if rsp.Response != "success" {
+		return 0, 0, fmt.Errorf("unexpected response '%s': '%s'", rsp.Response, rsp.Info)
+	}
+
+	m := infoRegexp.FindStringSubmatch(rsp.Info)
+	if m == nil {
+		return 0, 0, fmt.Errorf("unexpected response info '%s'", rsp.Info)
+	}
+	processed, _ = strconv.Atoi(m[1])
+	failed, _ = strconv.Atoi(m[2])
+
+	return processed, failed, nil
+}
+
+// message returns the data with the header of the protocol and its length
+func message(data []byte) []byte {
+	b := make([]byte, 0, len(header)+8+len(data))
+	b = append(b, header...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+	// The reserved length, only used with compression
+	b = binary.LittleEndian.AppendUint32(b, 0)
+
+	return append(b, data...)
+}
+
+// readMessage reads a message of the protocol, returning its data
+func readMessage(r io.Reader) ([]byte, error) {
+	h := make([]byte, len(header)+8)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(h[:len(header)], header) {
+		return nil, errors.New("invalid header")
+	}
+
+	size := binary.LittleEndian.Uint32(h[len(header):])
+	if size > maxResponseSize {
+		return nil, fmt.Errorf("too large: %d bytes", size)
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (s *Sender) Describe(ch chan<- *prometheus.Desc) {
+	s.sent.Describe(ch)
+	s.failed.Describe(ch)
+	s.sendErrors.Describe(ch)
+}
+
+func (s *Sender) Collect(ch chan<- prometheus.Metric) {
+	s.sent.Collect(ch)
+	s.failed.Collect(ch)
+	s.sendErrors.Collect(ch)
+}
+
+// DefaultKeys returns the keys of all the stats, with the default key
+func DefaultKeys() map[string]string {
+	keys := map[string]string{}
+	for _, stat := range config.AlertStats {
+		keys[stat] = config.DefaultZabbixKey
+	}
+
+	return keys
+}